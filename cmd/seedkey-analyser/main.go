@@ -0,0 +1,100 @@
+// seedkey-analyser searches recorded SecurityAccess exchanges for the parameters of known seed key algorithms.
+//
+// Usage:
+//
+//	go run ./cmd/seedkey-analyser [-level n] capture.log...
+//
+// Captures can be candump logs, candump output or a husk CAN message log.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+
+	"husk/seedkey"
+)
+
+// maxCandidatesPrinted stops captures with few pairs flooding the output
+const maxCandidatesPrinted = 16
+
+func main() {
+	level := flag.Int("level", 0, "only analyse this security level, 0 analyses every level found")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-level n] capture...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Collect the accepted pairs from every capture by level
+	pairsByLevel := make(map[seedkey.SecurityLevel][]seedkey.SeedKeyPair)
+	for _, path := range flag.Args() {
+		pairs, err := readPairs(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", path, err)
+			os.Exit(1)
+		}
+		for _, pair := range pairs {
+			if *level != 0 && pair.Level != seedkey.SecurityLevel(*level) {
+				continue
+			}
+			pairsByLevel[pair.Level] = append(pairsByLevel[pair.Level], pair)
+		}
+	}
+	if len(pairsByLevel) == 0 {
+		fmt.Fprintln(os.Stderr, "No accepted seed key pairs found")
+		os.Exit(1)
+	}
+
+	levels := make([]seedkey.SecurityLevel, 0, len(pairsByLevel))
+	for l := range pairsByLevel {
+		levels = append(levels, l)
+	}
+	slices.Sort(levels)
+	found := false
+	for _, l := range levels {
+		pairs := pairsByLevel[l]
+		fmt.Printf("Security level %d: %d accepted seed key pairs\n", l, len(pairs))
+		candidates, err := seedkey.Analyse(pairs)
+		if err != nil {
+			fmt.Printf("  %v\n", err)
+			continue
+		}
+		if len(candidates) == 0 {
+			fmt.Println("  No known algorithm matches every pair")
+			continue
+		}
+		found = true
+		if len(candidates) > 1 {
+			fmt.Printf("  %d candidates match, capture more pairs to narrow them down\n", len(candidates))
+		}
+		for i, candidate := range candidates {
+			if i == maxCandidatesPrinted {
+				fmt.Printf("  ... %d more\n", len(candidates)-maxCandidatesPrinted)
+				break
+			}
+			fmt.Printf("  VERIFIED %s: %s\n", candidate.Family, candidate.Parameters)
+		}
+	}
+	if !found {
+		os.Exit(1)
+	}
+}
+
+func readPairs(path string) ([]seedkey.SeedKeyPair, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	frames, err := seedkey.ReadCaptureFrames(file)
+	if err != nil {
+		return nil, err
+	}
+	return seedkey.ExtractSeedKeyPairs(frames), nil
+}
@@ -48,6 +48,8 @@ func ScanForDrivers() {
 
 	availableDrivers = []Driver{}
 	availableDrivers = ScanArduino(ports, availableDrivers)
+	availableDrivers = ScanSocketCAN(availableDrivers)
+	availableDrivers = ScanVirtual(availableDrivers)
 
 	availableDriverNames = make([]string, len(availableDrivers))
 	driverNameToDriver = make(map[string]Driver)
@@ -0,0 +1,379 @@
+//go:build linux
+
+package drivers
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"husk/canbus"
+	"husk/logging"
+	"husk/services"
+)
+
+const (
+	SocketCANReadTimeout = 5 * time.Millisecond
+	// SocketCANFrameSize is the size of struct can_frame from linux/can.h
+	SocketCANFrameSize = 16
+)
+
+// Netlink attribute types from linux/if_link.h and linux/can/netlink.h
+const (
+	iflaInfoData        = 0x02
+	iflaCANBitTiming    = 0x01
+	iflaCANState        = 0x04
+	netlinkAttrTypeMask = 0x3FFF
+)
+
+// Map of CAN controller states (enum can_state) to their names.
+var socketCANStateNames = map[uint32]string{
+	0: "error-active",
+	1: "error-warning",
+	2: "error-passive",
+	3: "bus-off",
+	4: "stopped",
+	5: "sleeping",
+}
+
+// SocketCANDriver handles communication with a Linux SocketCAN interface using a CAN_RAW socket.
+type SocketCANDriver struct {
+	isRunning        int32 // Use int32 for atomic operations
+	interfaceName    string
+	interfaceIndex   int
+	bitrate          uint32
+	state            string
+	fd               int // -1 while the socket isn't open
+	writeLock        sync.Mutex
+	frameBroadcaster *CanFrameBroadcaster
+	wg               sync.WaitGroup
+	cancelFunc       context.CancelFunc
+	closeOnce        sync.Once
+}
+
+// ScanSocketCAN scans network interfaces to find can* and vcan* devices and initializes drivers for them.
+func ScanSocketCAN(drivers []Driver) []Driver {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to list network interfaces: %v", err), logging.LogLevelError)
+		return drivers
+	}
+
+	for _, iface := range interfaces {
+		if !strings.HasPrefix(iface.Name, "can") && !strings.HasPrefix(iface.Name, "vcan") {
+			continue
+		}
+		d := &SocketCANDriver{
+			interfaceName:  iface.Name,
+			interfaceIndex: iface.Index,
+			state:          "N/A",
+			fd:             -1,
+		}
+		// Bitrate and state are optional; virtual interfaces don't report them
+		err = d.readLinkDetails()
+		if err != nil {
+			l.WriteLog(fmt.Sprintf("Couldn't read link details for %s: %v", iface.Name, err), logging.LogLevelWarning)
+		}
+		drivers = append(drivers, d)
+	}
+	return drivers
+}
+
+// String returns a string representation of the SocketCANDriver.
+func (d *SocketCANDriver) String() string {
+	if d.bitrate == 0 {
+		return fmt.Sprintf("SocketCAN: %s", d.interfaceName)
+	}
+	return fmt.Sprintf("SocketCAN: %s (%d kbit/s, %s)", d.interfaceName, d.bitrate/1000, d.state)
+}
+
+// Register opens and binds the CAN_RAW socket and registers the driver with the service registry.
+func (d *SocketCANDriver) Register() (Driver, error) {
+	var err error
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+
+	d.frameBroadcaster = NewCanFrameBroadcaster()
+
+	// Open raw CAN socket
+	d.fd, err = unix.Socket(unix.AF_CAN, unix.SOCK_RAW, unix.CAN_RAW)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error opening CAN socket: %s", err.Error()), logging.LogLevelError)
+		d.fd = -1
+		return nil, err
+	}
+
+	// Set read timeout so the read loop can notice cancellation
+	timeout := unix.NsecToTimeval(SocketCANReadTimeout.Nanoseconds())
+	err = unix.SetsockoptTimeval(d.fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &timeout)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error setting read timeout: %s", err.Error()), logging.LogLevelError)
+		_ = unix.Close(d.fd)
+		d.fd = -1
+		return nil, err
+	}
+
+	// Bind the socket to the interface
+	err = unix.Bind(d.fd, &unix.SockaddrCAN{Ifindex: d.interfaceIndex})
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error binding CAN socket to %s: %s", d.interfaceName, err.Error()), logging.LogLevelError)
+		_ = unix.Close(d.fd)
+		d.fd = -1
+		return nil, err
+	}
+
+	// Register the driver after successful initialization
+	services.Register(services.ServiceDriver, d)
+
+	l.WriteLog(fmt.Sprintf("SocketCAN connected on interface %s", d.interfaceName), logging.LogLevelSuccess)
+	return d, nil
+}
+
+// Start begins the driver's read loop and prepares it for operation.
+func (d *SocketCANDriver) Start(ctx context.Context) (Driver, error) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+
+	// Create a cancellable context
+	ctx, d.cancelFunc = context.WithCancel(ctx)
+
+	// Mark the driver as running
+	atomic.StoreInt32(&d.isRunning, 1)
+
+	// Start the main loop
+	d.wg.Add(1)
+	go d.readAndBroadcastFrames(ctx)
+
+	l.WriteLog("SocketCAN driver running", logging.LogLevelSuccess)
+	return d, nil
+}
+
+// Cleanup stops the driver and releases all resources.
+// The socket is closed even if the driver was never started or the read loop already stopped on an error.
+func (d *SocketCANDriver) Cleanup() {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+
+	atomic.StoreInt32(&d.isRunning, 0)
+
+	// Cancel the context to signal goroutines to exit
+	if d.cancelFunc != nil {
+		d.cancelFunc()
+	}
+
+	// Wait for all goroutines to finish
+	d.wg.Wait()
+
+	d.closeOnce.Do(func() {
+		// Cleanup the broadcaster
+		if d.frameBroadcaster != nil {
+			d.frameBroadcaster.Cleanup()
+		}
+
+		// Close the socket, unless Register never opened it or already closed it on failure
+		if d.fd < 0 {
+			return
+		}
+		err := unix.Close(d.fd)
+		d.fd = -1
+		if err != nil {
+			l.WriteLog(fmt.Sprintf("Error closing CAN socket: %s", err.Error()), logging.LogLevelError)
+			return
+		}
+		l.WriteLog("CAN socket closed successfully", logging.LogLevelSuccess)
+	})
+}
+
+// SendFrame writes a CAN bus frame to the socket.
+// Do not use for high-level communications; use the ECU or protocol layer instead.
+func (d *SocketCANDriver) SendFrame(ctx context.Context, frame *canbus.CanFrame) error {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+
+	if atomic.LoadInt32(&d.isRunning) == 0 {
+		return fmt.Errorf("driver is not running")
+	}
+
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("operation cancelled")
+	default:
+	}
+
+	if frame.DLC > 8 {
+		return fmt.Errorf("invalid DLC value: %d", frame.DLC)
+	}
+
+	// Don't log tester present
+	if frame.Data[1] != 0x3E {
+		l.WriteMessage(fmt.Sprintf("CANBUS Send:\n%s", frame.String()), logging.MessageTypeCANBUSWrite)
+	}
+
+	d.writeLock.Lock()
+	defer d.writeLock.Unlock()
+	n, err := unix.Write(d.fd, frameToSocketCANBytes(frame))
+	if err != nil {
+		return fmt.Errorf("failed to write CAN frame: %w", err)
+	}
+	if n != SocketCANFrameSize {
+		return fmt.Errorf("short write to CAN socket: wrote %d of %d bytes", n, SocketCANFrameSize)
+	}
+	return nil
+}
+
+// SubscribeReadFrames allows a subscriber to receive broadcasted CAN frames.
+func (d *SocketCANDriver) SubscribeReadFrames() chan *canbus.CanFrame {
+	return d.frameBroadcaster.Subscribe()
+}
+
+// UnsubscribeReadFrames removes a subscriber from receiving broadcasted CAN frames.
+func (d *SocketCANDriver) UnsubscribeReadFrames(ch chan *canbus.CanFrame) {
+	d.frameBroadcaster.Unsubscribe(ch)
+}
+
+// readAndBroadcastFrames reads frames from the socket and broadcasts them to subscribers.
+func (d *SocketCANDriver) readAndBroadcastFrames(ctx context.Context) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	defer d.wg.Done()
+
+	buffer := make([]byte, SocketCANFrameSize)
+
+	for {
+		select {
+		case <-ctx.Done():
+			l.WriteLog("Stopping CAN bus frame processing due to context cancellation", logging.LogLevelInfo)
+			return
+		default:
+			n, err := unix.Read(d.fd, buffer)
+			if err != nil {
+				if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+					continue
+				}
+				l.WriteLog(fmt.Sprintf("Error reading from CAN socket: %s", err.Error()), logging.LogLevelError)
+				atomic.StoreInt32(&d.isRunning, 0)
+				d.cancelFunc()
+				return
+			}
+			if n != SocketCANFrameSize {
+				l.WriteLog(fmt.Sprintf("Incomplete CAN frame received, expected %d bytes but got %d", SocketCANFrameSize, n), logging.LogLevelError)
+				continue
+			}
+			frame, ok := socketCANBytesToFrame(buffer)
+			if !ok {
+				// Extended, remote and error frames are not used by the protocol layer
+				continue
+			}
+			if frame.Data[1] != 0x7E {
+				l.WriteMessage(fmt.Sprintf("CANBUS Read:\n%s", frame.String()), logging.MessageTypeCANBUSRead)
+			}
+			d.frameBroadcaster.Broadcast(frame)
+		}
+	}
+}
+
+// readLinkDetails queries rtnetlink for the bitrate and controller state of the interface.
+func (d *SocketCANDriver) readLinkDetails() error {
+	rib, err := syscall.NetlinkRIB(syscall.RTM_GETLINK, syscall.AF_UNSPEC)
+	if err != nil {
+		return err
+	}
+	messages, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return err
+	}
+	for _, message := range messages {
+		if message.Header.Type != unix.RTM_NEWLINK || len(message.Data) < unix.SizeofIfInfomsg {
+			continue
+		}
+		index := int32(binary.NativeEndian.Uint32(message.Data[4:8]))
+		if int(index) != d.interfaceIndex {
+			continue
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(&message)
+		if err != nil {
+			return err
+		}
+		for _, attr := range attrs {
+			if attr.Attr.Type&netlinkAttrTypeMask != unix.IFLA_LINKINFO {
+				continue
+			}
+			infoData, ok := parseNetlinkAttrs(attr.Value)[iflaInfoData]
+			if !ok {
+				// Virtual interfaces have no CAN specific link data
+				return nil
+			}
+			canAttrs := parseNetlinkAttrs(infoData)
+			// struct can_bittiming starts with the bitrate
+			if bitTiming, ok := canAttrs[iflaCANBitTiming]; ok && len(bitTiming) >= 4 {
+				d.bitrate = binary.NativeEndian.Uint32(bitTiming)
+			}
+			if state, ok := canAttrs[iflaCANState]; ok && len(state) >= 4 {
+				if name, found := socketCANStateNames[binary.NativeEndian.Uint32(state)]; found {
+					d.state = name
+				}
+			}
+			return nil
+		}
+		return nil
+	}
+	return fmt.Errorf("interface %s not found in link dump", d.interfaceName)
+}
+
+// parseNetlinkAttrs parses a buffer of (possibly nested) netlink attributes into a map of type to value.
+func parseNetlinkAttrs(b []byte) map[uint16][]byte {
+	attrs := make(map[uint16][]byte)
+	for len(b) >= unix.SizeofRtAttr {
+		length := int(binary.NativeEndian.Uint16(b[0:2]))
+		attrType := binary.NativeEndian.Uint16(b[2:4]) & netlinkAttrTypeMask
+		if length < unix.SizeofRtAttr || length > len(b) {
+			break
+		}
+		attrs[attrType] = b[unix.SizeofRtAttr:length]
+		// Attributes are aligned to 4 bytes
+		aligned := (length + 3) &^ 3
+		if aligned > len(b) {
+			break
+		}
+		b = b[aligned:]
+	}
+	return attrs
+}
+
+// frameToSocketCANBytes converts the CAN frame into a struct can_frame byte slice.
+func frameToSocketCANBytes(frame *canbus.CanFrame) []byte {
+	/*
+		struct can_frame:
+		- [CAN ID (4 bytes, native endian)][DLC][Padding (3 bytes)][Data (8 bytes)]
+	*/
+	frameBytes := make([]byte, SocketCANFrameSize)
+	binary.NativeEndian.PutUint32(frameBytes[0:4], uint32(frame.ID)&unix.CAN_SFF_MASK)
+	dlc := min(frame.DLC, 8)
+	frameBytes[4] = dlc
+	copy(frameBytes[8:], frame.Data[:dlc])
+	return frameBytes
+}
+
+// socketCANBytesToFrame converts a struct can_frame byte slice into a CAN frame.
+// It returns false for frames that can't be represented with an 11-bit identifier.
+func socketCANBytesToFrame(frameBytes []byte) (*canbus.CanFrame, bool) {
+	id := binary.NativeEndian.Uint32(frameBytes[0:4])
+	if id&(unix.CAN_EFF_FLAG|unix.CAN_RTR_FLAG|unix.CAN_ERR_FLAG) != 0 {
+		return nil, false
+	}
+	dlc := frameBytes[4]
+	if dlc > 8 {
+		dlc = 8
+	}
+	frame := &canbus.CanFrame{
+		ID:  uint16(id & unix.CAN_SFF_MASK),
+		DLC: dlc,
+	}
+	copy(frame.Data[:], frameBytes[8:8+dlc])
+	return frame, true
+}
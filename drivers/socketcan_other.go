@@ -0,0 +1,8 @@
+//go:build !linux
+
+package drivers
+
+// ScanSocketCAN is a no-op on platforms without SocketCAN.
+func ScanSocketCAN(drivers []Driver) []Driver {
+	return drivers
+}
@@ -0,0 +1,294 @@
+package drivers
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"husk/canbus"
+	"husk/logging"
+	"husk/services"
+)
+
+const (
+	VirtualBusQueueSize = 1024
+	// VirtualDefaultReorderDelay is used when no reorder delay is configured
+	VirtualDefaultReorderDelay = 10 * time.Millisecond
+	// VirtualTesterName is the name of the tester side endpoint listed by ScanForDrivers
+	VirtualTesterName = "tester"
+)
+
+// VirtualBusConfig configures the impairments applied to frames on a VirtualBus.
+type VirtualBusConfig struct {
+	// Latency is the delay between a frame being sent and it being delivered to other endpoints
+	Latency time.Duration
+	// LossRate is the probability (0-1) that a frame is silently dropped
+	LossRate float64
+	// ReorderRate is the probability (0-1) that a frame is held back and delivered after later frames
+	ReorderRate float64
+	// ReorderDelay is the additional delay applied to reordered frames, defaults to VirtualDefaultReorderDelay
+	ReorderDelay time.Duration
+}
+
+// VirtualBus is an in-process CAN bus. Frames sent by one attached endpoint are delivered to every other endpoint.
+type VirtualBus struct {
+	config    VirtualBusConfig
+	endpoints map[*VirtualDriver]struct{}
+	lock      sync.RWMutex
+	queue     chan virtualDelivery
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// virtualDelivery is a frame waiting to be delivered on the bus.
+type virtualDelivery struct {
+	sender    *VirtualDriver
+	frame     *canbus.CanFrame
+	deliverAt time.Time
+}
+
+// VirtualDriver is an endpoint attached to a VirtualBus.
+type VirtualDriver struct {
+	isRunning        int32 // Use int32 for atomic operations
+	name             string
+	bus              *VirtualBus
+	frameBroadcaster *CanFrameBroadcaster
+	wg               sync.WaitGroup
+	cancelFunc       context.CancelFunc
+}
+
+// virtualBus is the bus listed by ScanForDrivers. It is nil unless enabled with EnableVirtualBus.
+var virtualBus *VirtualBus
+
+// NewVirtualBus creates a new VirtualBus and starts its delivery loop.
+func NewVirtualBus(config VirtualBusConfig) *VirtualBus {
+	b := &VirtualBus{
+		config:    config,
+		endpoints: make(map[*VirtualDriver]struct{}),
+		queue:     make(chan virtualDelivery, VirtualBusQueueSize),
+		done:      make(chan struct{}),
+	}
+	go b.deliveryLoop()
+	return b
+}
+
+// EnableVirtualBus makes a tester endpoint on the given bus available in ScanForDrivers.
+func EnableVirtualBus(bus *VirtualBus) {
+	virtualBus = bus
+}
+
+// GetVirtualBus returns the bus enabled with EnableVirtualBus, or nil if there isn't one.
+func GetVirtualBus() *VirtualBus {
+	return virtualBus
+}
+
+// ScanVirtual adds a tester endpoint for the enabled virtual bus to the available drivers.
+func ScanVirtual(drivers []Driver) []Driver {
+	if virtualBus == nil {
+		return drivers
+	}
+	return append(drivers, NewVirtualDriver(virtualBus, VirtualTesterName))
+}
+
+// Close stops the delivery loop. Frames still queued are discarded.
+func (b *VirtualBus) Close() {
+	b.closeOnce.Do(func() {
+		close(b.done)
+	})
+}
+
+// attach connects an endpoint to the bus so it receives frames.
+func (b *VirtualBus) attach(d *VirtualDriver) {
+	b.lock.Lock()
+	b.endpoints[d] = struct{}{}
+	b.lock.Unlock()
+}
+
+// detach disconnects an endpoint from the bus.
+func (b *VirtualBus) detach(d *VirtualDriver) {
+	b.lock.Lock()
+	delete(b.endpoints, d)
+	b.lock.Unlock()
+}
+
+// send applies the configured impairments to a frame and queues it for delivery.
+func (b *VirtualBus) send(ctx context.Context, sender *VirtualDriver, frame *canbus.CanFrame) error {
+	if b.config.LossRate > 0 && rand.Float64() < b.config.LossRate {
+		// Lost frames look successful to the sender, just like on a real bus without ACK checking
+		return nil
+	}
+	// Copy the frame so the sender can't modify it after sending
+	frameCopy := *frame
+	delivery := virtualDelivery{
+		sender:    sender,
+		frame:     &frameCopy,
+		deliverAt: time.Now().Add(b.config.Latency),
+	}
+	if b.config.ReorderRate > 0 && rand.Float64() < b.config.ReorderRate {
+		// Reordered frames bypass the ordered queue so later frames can overtake them
+		reorderDelay := b.config.ReorderDelay
+		if reorderDelay == 0 {
+			reorderDelay = VirtualDefaultReorderDelay
+		}
+		time.AfterFunc(b.config.Latency+reorderDelay, func() {
+			b.deliver(delivery)
+		})
+		return nil
+	}
+	select {
+	case b.queue <- delivery:
+		return nil
+	case <-b.done:
+		return fmt.Errorf("virtual bus is closed")
+	case <-ctx.Done():
+		return fmt.Errorf("operation cancelled")
+	}
+}
+
+// deliveryLoop delivers queued frames in order once their latency has elapsed.
+func (b *VirtualBus) deliveryLoop() {
+	for {
+		select {
+		case <-b.done:
+			return
+		case delivery := <-b.queue:
+			if wait := time.Until(delivery.deliverAt); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-b.done:
+					return
+				}
+			}
+			b.deliver(delivery)
+		}
+	}
+}
+
+// deliver broadcasts a frame to every attached endpoint except the sender.
+func (b *VirtualBus) deliver(delivery virtualDelivery) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for endpoint := range b.endpoints {
+		if endpoint == delivery.sender {
+			continue
+		}
+		endpoint.receiveFrame(delivery.frame)
+	}
+}
+
+// NewVirtualDriver creates a new endpoint for the given bus. The endpoint is attached when started.
+func NewVirtualDriver(bus *VirtualBus, name string) *VirtualDriver {
+	return &VirtualDriver{
+		name:             name,
+		bus:              bus,
+		frameBroadcaster: NewCanFrameBroadcaster(),
+	}
+}
+
+// String returns a string representation of the VirtualDriver.
+func (d *VirtualDriver) String() string {
+	return fmt.Sprintf("Virtual: %s", d.name)
+}
+
+// Register registers the driver with the service registry.
+// Endpoints used by simulators should be started without being registered.
+func (d *VirtualDriver) Register() (Driver, error) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+
+	services.Register(services.ServiceDriver, d)
+
+	l.WriteLog(fmt.Sprintf("Virtual bus endpoint %s registered", d.name), logging.LogLevelSuccess)
+	return d, nil
+}
+
+// Start attaches the endpoint to the bus. It is detached again when the context is cancelled.
+func (d *VirtualDriver) Start(ctx context.Context) (Driver, error) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+
+	// Create a cancellable context
+	ctx, d.cancelFunc = context.WithCancel(ctx)
+
+	// Mark the driver as running and attach to the bus
+	atomic.StoreInt32(&d.isRunning, 1)
+	d.bus.attach(d)
+
+	d.wg.Add(1)
+	go d.detachOnCancel(ctx)
+
+	l.WriteLog(fmt.Sprintf("Virtual bus endpoint %s running", d.name), logging.LogLevelSuccess)
+	return d, nil
+}
+
+// Cleanup detaches the endpoint from the bus and releases all resources.
+func (d *VirtualDriver) Cleanup() {
+	if !atomic.CompareAndSwapInt32(&d.isRunning, 1, 0) {
+		// If isRunning was not 1, Cleanup has already been called
+		return
+	}
+
+	// Cancel the context to signal goroutines to exit
+	if d.cancelFunc != nil {
+		d.cancelFunc()
+	}
+
+	// Wait for the endpoint to detach before closing subscriber channels
+	d.wg.Wait()
+
+	// Cleanup the broadcaster
+	d.frameBroadcaster.Cleanup()
+}
+
+// SendFrame puts a CAN bus frame on the virtual bus.
+// Do not use for high-level communications; use the ECU or protocol layer instead.
+func (d *VirtualDriver) SendFrame(ctx context.Context, frame *canbus.CanFrame) error {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+
+	if atomic.LoadInt32(&d.isRunning) == 0 {
+		return fmt.Errorf("driver is not running")
+	}
+
+	// Only log frames from the registered endpoint, simulator traffic is logged when it is received
+	if d.isRegistered() && frame.Data[1] != 0x3E {
+		l.WriteMessage(fmt.Sprintf("CANBUS Send:\n%s", frame.String()), logging.MessageTypeCANBUSWrite)
+	}
+
+	return d.bus.send(ctx, d, frame)
+}
+
+// SubscribeReadFrames allows a subscriber to receive broadcasted CAN frames.
+func (d *VirtualDriver) SubscribeReadFrames() chan *canbus.CanFrame {
+	return d.frameBroadcaster.Subscribe()
+}
+
+// UnsubscribeReadFrames removes a subscriber from receiving broadcasted CAN frames.
+func (d *VirtualDriver) UnsubscribeReadFrames(ch chan *canbus.CanFrame) {
+	d.frameBroadcaster.Unsubscribe(ch)
+}
+
+// receiveFrame is called by the bus to hand a frame to this endpoint.
+func (d *VirtualDriver) receiveFrame(frame *canbus.CanFrame) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+
+	if atomic.LoadInt32(&d.isRunning) == 0 {
+		return
+	}
+	if d.isRegistered() && frame.Data[1] != 0x7E {
+		l.WriteMessage(fmt.Sprintf("CANBUS Read:\n%s", frame.String()), logging.MessageTypeCANBUSRead)
+	}
+	d.frameBroadcaster.Broadcast(frame)
+}
+
+// detachOnCancel detaches the endpoint from the bus once the context is cancelled.
+func (d *VirtualDriver) detachOnCancel(ctx context.Context) {
+	defer d.wg.Done()
+	<-ctx.Done()
+	d.bus.detach(d)
+}
+
+// isRegistered reports whether this endpoint is the driver registered with the service registry.
+func (d *VirtualDriver) isRegistered() bool {
+	return services.Get(services.ServiceDriver) == d
+}
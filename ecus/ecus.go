@@ -2,9 +2,13 @@ package ecus
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
 	"husk/logging"
 	"husk/services"
+	"husk/uds"
 )
 
 type (
@@ -15,8 +19,27 @@ type (
 		String() string
 		GetTesterId() uint16
 		GetECUId() uint16
+		// Protocol returns the protocol the ECU speaks natively, used to label its messages
+		Protocol() *uds.Protocol
 		ReadErrors(ctx context.Context) []string
 		ClearErrors(ctx context.Context)
+		ReadECURom(ctx context.Context, path string) ([]byte, error)
+		FlashECURom(ctx context.Context, path string) error
+		// ResetECU resets the ECU with an ECUReset subfunction, e.g. uds.SubfunctionHardReset
+		ResetECU(ctx context.Context, resetType byte) error
+		// Routines returns the routines the ECU can run, RunRoutine runs one by id
+		Routines() []uds.Routine
+		RunRoutine(ctx context.Context, id uint16, parameters map[string]float64) (*uds.RoutineResult, error)
+		// Actuators returns the actuators the ECU lets the tester drive, RunActuatorTest drives one by id
+		// and StopActuatorTests returns control of every actuator to the ECU
+		Actuators() []uds.Actuator
+		RunActuatorTest(ctx context.Context, id uint16, parameters map[string]float64, duration time.Duration) (*uds.ActuatorTest, error)
+		StopActuatorTests()
+		// WritableIdentifiers returns the data identifiers the tester may write, WriteDataIdentifier writes one by id
+		// and RollbackWrite restores the value from before a write in the write journal, the latest if sequence is 0
+		WritableIdentifiers() []uds.Identifier
+		WriteDataIdentifier(ctx context.Context, id uint16, record []byte) error
+		RollbackWrite(ctx context.Context, sequence int) error
 	}
 	ECUType int
 	ECUId   struct {
@@ -44,6 +67,8 @@ func ScanForECUs(ctx context.Context) {
 	availableECUs = []ECUProcessor{}
 	// Add more ecu types here
 	availableECUs = ScanK01(ctx, availableECUs)
+	// OBD-II is scanned for last so bikes with a processor of their own are listed first
+	availableECUs = ScanOBD(ctx, availableECUs)
 	availableECUIds = make([]string, len(availableECUs))
 	ecuIdToECU = make(map[string]ECUProcessor)
 	for i, ecu := range availableECUs {
@@ -81,6 +106,7 @@ func Connect(ctx context.Context, name string) {
 
 func Disconnect() {
 	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	StopActuatorTests()
 	if disconnectFunc != nil {
 		disconnectFunc()
 	}
@@ -89,6 +115,14 @@ func Disconnect() {
 	l.WriteLog("Disconnected from ECU successfully", logging.LogLevelSuccess)
 }
 
+// StopActuatorTests returns control of every actuator to the connected ECU, if there is one.
+// It must be called before the driver is disconnected or the application exits.
+func StopActuatorTests() {
+	if e, ok := services.Get(services.ServiceECU).(ECUProcessor); ok {
+		e.StopActuatorTests()
+	}
+}
+
 func SubscribeToScanEvent(callback func(availableECUIds []string)) {
 	ecuScanCallbacks = append(ecuScanCallbacks, callback)
 }
@@ -118,3 +152,33 @@ func disconnectEvent() {
 		callback()
 	}
 }
+
+// processAndBroadcastMessages reads complete messages, logs them, and broadcasts them to subscribers until ctx is cancelled.
+func processAndBroadcastMessages(ctx context.Context, broadcaster *uds.MessageBroadcaster) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	// One reader for the whole loop so multi frame messages from several ECUs can be received at once
+	reader := uds.NewReader()
+	defer reader.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			l.WriteLog("Stopping UDS message processing due to context cancellation", logging.LogLevelInfo)
+			return
+		default:
+			message, err := reader.Read(ctx)
+			if err != nil {
+				if errors.Is(ctx.Err(), context.Canceled) {
+					return
+				}
+				l.WriteLog(fmt.Sprintf("Error reading UDS: %s", err.Error()), logging.LogLevelError)
+				continue
+			}
+			if message != nil {
+				if message.ServiceID != uds.ServiceTesterPresent {
+					l.WriteMessage(message.ProtocolLabel()+": "+message.String(), logging.MessageTypeUDSRead)
+				}
+				broadcaster.Broadcast(message)
+			}
+		}
+	}
+}
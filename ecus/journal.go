@@ -0,0 +1,150 @@
+package ecus
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultWriteJournalPath is where the value of every data identifier is backed up before it is written
+const DefaultWriteJournalPath = "husk-write-journal.jsonl"
+
+// WriteJournalAction is why a journal entry was written
+type WriteJournalAction string
+
+const (
+	WriteJournalActionWrite WriteJournalAction = "write"
+	// WriteJournalActionRollback restores the previous value of an earlier write
+	WriteJournalActionRollback WriteJournalAction = "rollback"
+)
+
+var (
+	writeJournalLock sync.Mutex
+	writeJournalPath = DefaultWriteJournalPath
+)
+
+// WriteJournalEntry records the value of a data identifier before and after a write. Entries are written
+// before the ECU is changed so the previous value survives a write that fails part way.
+type WriteJournalEntry struct {
+	Sequence int                `json:"sequence"`
+	Time     time.Time          `json:"time"`
+	Action   WriteJournalAction `json:"action"`
+	// RollbackOf is the sequence of the write a rollback restores
+	RollbackOf int    `json:"rollbackOf,omitempty"`
+	HardwareId string `json:"hardwareId"`
+	SoftwareId string `json:"softwareId"`
+	VIN        string `json:"vin"`
+	ID         uint16 `json:"id"`
+	Name       string `json:"name"`
+	// Previous and Value are the hex encoded records before and after the write
+	Previous string `json:"previous"`
+	Value    string `json:"value"`
+}
+
+// PreviousRecord returns the record read before the write.
+func (e WriteJournalEntry) PreviousRecord() ([]byte, error) {
+	return hex.DecodeString(e.Previous)
+}
+
+// SetWriteJournalPath changes where the write journal is kept.
+func SetWriteJournalPath(path string) {
+	writeJournalLock.Lock()
+	defer writeJournalLock.Unlock()
+	writeJournalPath = path
+}
+
+// ReadWriteJournal returns every journal entry, oldest first.
+func ReadWriteJournal() ([]WriteJournalEntry, error) {
+	writeJournalLock.Lock()
+	defer writeJournalLock.Unlock()
+	return readWriteJournal()
+}
+
+func readWriteJournal() ([]WriteJournalEntry, error) {
+	file, err := os.Open(writeJournalPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var entries []WriteJournalEntry
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry WriteJournalEntry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", writeJournalPath, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// appendWriteJournal numbers entry and appends it to the journal, returning once it is on disk.
+func appendWriteJournal(entry WriteJournalEntry) (WriteJournalEntry, error) {
+	writeJournalLock.Lock()
+	defer writeJournalLock.Unlock()
+	entries, err := readWriteJournal()
+	if err != nil {
+		return entry, err
+	}
+	entry.Sequence = 1
+	for _, existing := range entries {
+		entry.Sequence = max(entry.Sequence, existing.Sequence+1)
+	}
+	entry.Time = time.Now()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return entry, err
+	}
+	file, err := os.OpenFile(writeJournalPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return entry, err
+	}
+	_, err = file.Write(append(data, '\n'))
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err != nil {
+		return entry, err
+	}
+	return entry, closeErr
+}
+
+// findRollbackEntry returns the write with sequence, or the latest write to the ECU with vin that hasn't
+// been rolled back if sequence is 0.
+func findRollbackEntry(entries []WriteJournalEntry, vin string, sequence int) (WriteJournalEntry, error) {
+	rolledBack := make(map[int]bool)
+	for _, entry := range entries {
+		if entry.Action == WriteJournalActionRollback {
+			rolledBack[entry.RollbackOf] = true
+		}
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.Action != WriteJournalActionWrite {
+			continue
+		}
+		if sequence != 0 && entry.Sequence == sequence {
+			return entry, nil
+		}
+		if sequence == 0 && entry.VIN == vin && !rolledBack[entry.Sequence] {
+			return entry, nil
+		}
+	}
+	if sequence != 0 {
+		return WriteJournalEntry{}, fmt.Errorf("no write %d in the journal", sequence)
+	}
+	return WriteJournalEntry{}, errors.New("no writes to roll back")
+}
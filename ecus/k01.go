@@ -1,7 +1,9 @@
 package ecus
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"slices"
@@ -9,7 +11,10 @@ import (
 	"sync/atomic"
 	"time"
 
+	"husk/kwp"
+	"husk/livedata"
 	"husk/logging"
+	"husk/seedkey"
 	"husk/services"
 	"husk/uds"
 )
@@ -20,15 +25,47 @@ type K01 struct {
 	isRunning          int32 // Use int32 for atomic operations
 	identification     *ECUId
 	messageBroadcaster *uds.MessageBroadcaster
+	client             *uds.Client
+	security           *uds.SecurityAccess
+	sessions           *uds.SessionManager
 	wg                 sync.WaitGroup
 	cancelFunc         context.CancelFunc
+	actuatorLock       sync.Mutex
+	actuatorTests      []*uds.ActuatorTest
+	actuatorWG         sync.WaitGroup
+	// operation is the operation using the diagnostic session, only one runs at a time so one can't switch or
+	// end the session under another. Actuator tests share it and operationUsers counts them.
+	operationLock  sync.Mutex
+	operation      string
+	operationUsers int
 }
 
 const (
 	TesterPresentDelayK01 = 2 * time.Second
-	ReadTimeoutK01        = 5 * time.Second
+	// RomReadBlockSizeK01 is the number of bytes requested per memory read
+	RomReadBlockSizeK01 uint32 = 0x800
+	// RomReadRetriesK01 is the number of times a failed memory read is retried
+	RomReadRetriesK01 = 3
+	// RomSecurityLevelK01 is the security level required to read and write memory
+	RomSecurityLevelK01 = seedkey.SecurityLevel2
 )
 
+// errorECUBusy is returned when an operation is started while another one is using the diagnostic session
+var errorECUBusy = errors.New("ecu is busy")
+
+// RomRegionK01 is the flash memory region holding the ROM
+var RomRegionK01 = MemoryRegion{
+	Start: 0x00000000,
+	Size:  0x00100000,
+}
+
+// ISOTPConfigK01 is the transport configuration used to talk to the ECU
+var ISOTPConfigK01 = uds.ISOTPConfig{
+	BlockSize:        0,
+	SeparationTime:   0x0A,
+	MaxMessageLength: uds.DefaultMaxMessageLength,
+}
+
 var CompatibleECUHardwareIdsK01 = []string{
 	"613.41.031.300",
 }
@@ -49,6 +86,12 @@ func (e *K01) GetECUId() uint16 {
 	return uds.ECUID
 }
 
+// Protocol returns KWP2000 with the K01 manufacturer services. The K01 also answers the UDS services used for
+// sessions, DTC records, data identifiers, routines and memory access, which are labelled as UDS.
+func (e *K01) Protocol() *uds.Protocol {
+	return kwp.ProtocolK01
+}
+
 func ScanK01(ctx context.Context, ecus []ECUProcessor) []ECUProcessor {
 	l := services.Get(services.ServiceLogger).(*logging.Logger)
 	// Create a temporary instance of the processor
@@ -69,14 +112,11 @@ func ScanK01(ctx context.Context, ecus []ECUProcessor) []ECUProcessor {
 	defer tempProcessor.Cleanup()
 	// Attempt to communicate with the ECU
 	l.WriteLog("Scanning for 2016 to 2020 KTM/Husqvarna", logging.LogLevelInfo)
-	err = uds.SendTesterPresent(ctx)
-	if err != nil {
-		l.WriteLog(fmt.Sprintf("Failed to send tester present: %v", err), logging.LogLevelError)
-		return nil
-	}
 	// Make sure we get a valid response after sending tester preset.
-	service := uds.ServiceTesterPresent
-	_, err = tempProcessor.readMessage(ctx, &service, nil)
+	_, err = tempProcessor.request(ctx, &uds.Message{
+		SenderID:  uds.TesterID,
+		ServiceID: uds.ServiceTesterPresent,
+	})
 	if err != nil {
 		l.WriteLog(fmt.Sprintf("Failed to get tester present response: %v", err), logging.LogLevelError)
 		return nil
@@ -102,7 +142,13 @@ func (e *K01) String() string {
 
 func (e *K01) Register() (ECUProcessor, error) {
 	services.Register(services.ServiceECU, e)
+	uds.ConfigureTransport(uds.TesterID, ISOTPConfigK01)
+	uds.ConfigureDefinitions(uds.TesterID, seedkey.ECUTypeK01)
+	uds.ConfigureProtocol(uds.TesterID, e.Protocol())
 	e.messageBroadcaster = uds.NewUDSMessageBroadcaster()
+	e.client = uds.NewClient(uds.TesterID, e.messageBroadcaster)
+	e.security = uds.NewSecurityAccess(e.client, seedkey.ECUTypeK01)
+	e.sessions = uds.NewSessionManager(e.client, e.security.Unlock)
 	return e, nil
 }
 
@@ -121,26 +167,14 @@ func (e *K01) Start(ctx context.Context) (ECUProcessor, error) {
 	return e, nil
 }
 
-func (e *K01) subscribeReadMessages() (chan *uds.Message, error) {
-	if atomic.LoadInt32(&e.isRunning) == 0 {
-		return nil, fmt.Errorf("can't subscribe to messages, ecu is not connected")
-	}
-	return e.messageBroadcaster.Subscribe(), nil
-}
-
-func (e *K01) unsubscribeReadMessages(ch chan *uds.Message) {
-	if e.messageBroadcaster != nil {
-		e.messageBroadcaster.Unsubscribe(ch)
-	}
-	return
-}
-
 // Cleanup stops the driver and releases all resources.
 func (e *K01) Cleanup() {
 	if !atomic.CompareAndSwapInt32(&e.isRunning, 1, 0) {
 		// If isRunning was not 1, Cleanup has already been called
 		return
 	}
+	// Hand every actuator back to the ECU while we can still talk to it
+	e.StopActuatorTests()
 	// Cancel the context to signal goroutines to exit
 	if e.cancelFunc != nil {
 		e.cancelFunc()
@@ -153,34 +187,93 @@ func (e *K01) Cleanup() {
 	e.wg.Wait()
 }
 
+// ReadErrors reads every DTC the ECU has a status for using ReadDTCInformation so pending faults are reported
+// alongside confirmed ones, falling back to the K01 read errors service if ReadDTCInformation isn't supported.
 func (e *K01) ReadErrors(ctx context.Context) (dtcs []string) {
 	l := services.Get(services.ServiceLogger).(*logging.Logger)
-	serviceId := uds.ServiceReadErrorsK01
-	req := &uds.Message{
-		SenderID:  uds.TesterID,
-		ServiceID: serviceId,
+	records, err := uds.ReadDTCByStatusMask(ctx, e.sessions, uds.TesterID, uds.DTCStatusMaskAll)
+	if errors.Is(err, uds.ErrNRCServiceNotSupported) {
+		return e.readErrorsLegacy(ctx)
 	}
-	err := req.Send(ctx)
 	if err != nil {
-		l.WriteLog(fmt.Sprintf("Error failed to send read error message: %v", err), logging.LogLevelError)
+		l.WriteLog(fmt.Sprintf("Error failed to read errors: %v", err), logging.LogLevelError)
+		return
+	}
+	result := "ERRORS:\n"
+	for _, record := range records {
+		// A DTC without any status bits set has no fault recorded
+		if record.Status == 0 {
+			continue
+		}
+		// The K01 only uses the SAE J2012 part of the DTC
+		code := uint16(record.Code >> 8)
+		dtcs = append(dtcs, uds.FormatDTC(code))
+		result += fmt.Sprintf("DTC: %s\nStatus: %s\n", uds.GetDTCLabel(seedkey.ECUTypeK01, code), record.Status)
+		err = e.readDTCRecords(ctx, &record)
+		if err != nil {
+			l.WriteLog(fmt.Sprintf("Failed to read freeze frame of %s: %v", uds.FormatDTC(code), err), logging.LogLevelWarning)
+		}
+		result += formatDTCRecordsK01(record)
+	}
+	l.WriteLog("SUCCESSFULLY READ ERRORS", logging.LogLevelSuccess)
+	if len(dtcs) > 0 {
+		l.WriteLog(result, logging.LogLevelResult)
 		return
 	}
-	resp, err := e.readMessage(ctx, &serviceId, nil)
+	l.WriteLog("NO ERRORS FOUND", logging.LogLevelResult)
+	return
+}
+
+// readDTCRecords reads the freeze frames and extended data the ECU stored when dtc failed.
+func (e *K01) readDTCRecords(ctx context.Context, dtc *uds.DTC) (err error) {
+	dtc.Snapshots, err = uds.ReadDTCSnapshotRecords(ctx, e.sessions, uds.TesterID, dtc.Code, 0xFF, uds.LookupDefinitions(seedkey.ECUTypeK01).DataIdentifierLengths())
 	if err != nil {
-		l.WriteLog(fmt.Sprintf("Error failed to read read error response: %v", err), logging.LogLevelError)
+		return err
+	}
+	dtc.ExtendedData, err = uds.ReadDTCExtendedData(ctx, e.sessions, uds.TesterID, dtc.Code, 0xFF, uds.ExtDataRecordLengthsK01)
+	return err
+}
+
+// formatDTCRecordsK01 returns the decoded freeze frames and extended data of dtc, one value per line.
+func formatDTCRecordsK01(dtc uds.DTC) (result string) {
+	definitions := uds.LookupDefinitions(seedkey.ECUTypeK01)
+	for _, snapshot := range dtc.Snapshots {
+		result += fmt.Sprintf("Freeze Frame %d:\n", snapshot.Number)
+		for _, value := range definitions.DecodeDataIdentifiers(snapshot.Values) {
+			result += fmt.Sprintf("  %s\n", value)
+		}
+	}
+	for _, record := range dtc.ExtendedData {
+		name, ok := uds.ExtDataRecordNamesK01[record.Number]
+		if !ok || len(record.Data) != 1 {
+			result += fmt.Sprintf("Extended Data 0x%02X: 0x%X\n", record.Number, record.Data)
+			continue
+		}
+		result += fmt.Sprintf("%s: %d\n", name, record.Data[0])
+	}
+	return result
+}
+
+// readErrorsLegacy reads the stored DTCs using the K01 read errors service, which doesn't report a status.
+func (e *K01) readErrorsLegacy(ctx context.Context) (dtcs []string) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	resp, err := e.request(ctx, &uds.Message{
+		SenderID:  uds.TesterID,
+		ServiceID: kwp.ServiceReadErrorsK01,
+		Protocol:  kwp.ProtocolK01,
+	})
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to read errors: %v", err), logging.LogLevelError)
 		return
 	}
-	for i := 1; i < len(resp.Data); i += 2 {
-		// Convert each dtc to a string representing the dtc code
-		dtc := fmt.Sprintf("%02X%02X", resp.Data[i], resp.Data[i+1])
-		dtcs = append(dtcs, dtc)
+	result := "ERRORS:\n"
+	for i := 1; i+1 < len(resp.Data); i += 2 {
+		code := binary.BigEndian.Uint16(resp.Data[i : i+2])
+		dtcs = append(dtcs, uds.FormatDTC(code))
+		result += fmt.Sprintf("DTC: %s\n", uds.GetDTCLabel(seedkey.ECUTypeK01, code))
 	}
 	l.WriteLog("SUCCESSFULLY READ ERRORS", logging.LogLevelSuccess)
 	if len(dtcs) > 0 {
-		result := "ERRORS:\n"
-		for _, dtc := range dtcs {
-			result += fmt.Sprintf("DTC: %s\n", uds.GetDTCLabel(dtc))
-		}
 		l.WriteLog(result, logging.LogLevelResult)
 		return
 	}
@@ -190,98 +283,280 @@ func (e *K01) ReadErrors(ctx context.Context) (dtcs []string) {
 
 func (e *K01) ClearErrors(ctx context.Context) {
 	l := services.Get(services.ServiceLogger).(*logging.Logger)
-	serviceId := uds.ServiceClearErrorsK01
-	req := &uds.Message{
+	_, err := e.request(ctx, &uds.Message{
 		SenderID:  uds.TesterID,
-		ServiceID: serviceId,
-	}
-	err := req.Send(ctx)
+		ServiceID: kwp.ServiceClearErrorsK01,
+		Protocol:  kwp.ProtocolK01,
+	})
 	if err != nil {
-		l.WriteLog(fmt.Sprintf("Error failed to send clear error message: %v", err), logging.LogLevelError)
-		return
-	}
-	_, err = e.readMessage(ctx, &serviceId, nil)
-	if err != nil {
-		l.WriteLog(fmt.Sprintf("Error failed to read clear error response: %v", err), logging.LogLevelError)
+		l.WriteLog(fmt.Sprintf("Error failed to clear errors: %v", err), logging.LogLevelError)
 		return
 	}
 	l.WriteLog("CLEARED ERRORS SUCCESSFULLY", logging.LogLevelSuccess)
 }
 
-// ReadECURom reads the entire ROM from the ECU using UDS multi-frame communication.
-func (e *K01) ReadECURom(ctx context.Context) ([]byte, error) {
+// ResetECU resets the ECU with resetType, e.g. uds.SubfunctionHardReset. The ECU restarts in the default session.
+func (e *K01) ResetECU(ctx context.Context, resetType byte) error {
 	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	// A reset ends every actuator test without returning control cleanly
+	e.StopActuatorTests()
+	err := e.beginOperation("ECU reset", false)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to reset ECU: %v", err), logging.LogLevelError)
+		return err
+	}
+	defer e.endOperation()
+	err = uds.ECUReset(ctx, e.sessions, uds.TesterID, resetType)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to reset ECU: %v", err), logging.LogLevelError)
+		return err
+	}
+	// The ECU has already left any session an operation asked for
+	e.endSession(ctx)
+	l.WriteLog("ECU RESET SUCCESSFULLY", logging.LogLevelSuccess)
+	return nil
+}
 
+// StreamLiveData starts streaming channels from the ECU, or every local identifier field if no channels are given.
+// The streamer must be stopped when it is no longer needed.
+func (e *K01) StreamLiveData(ctx context.Context, channels []livedata.Channel) *livedata.Streamer {
+	if len(channels) == 0 {
+		channels = livedata.Channels(uds.LookupDefinitions(seedkey.ECUTypeK01), uds.SourceLocalIdentifier)
+	}
+	return livedata.NewStreamer(e.sessions, uds.TesterID, channels).Start(ctx)
+}
+
+// ReadECURom reads the entire ROM from the ECU and writes it to path along with a metadata sidecar.
+// An interrupted read of the same ECU to the same path is resumed.
+func (e *K01) ReadECURom(ctx context.Context, path string) ([]byte, error) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
 	l.WriteLog("Starting ROM read process", logging.LogLevelInfo)
+	if e.identification == nil {
+		return nil, fmt.Errorf("ecu has not been identified")
+	}
+	err := e.beginOperation("ROM read", false)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to read ROM: %v", err), logging.LogLevelError)
+		return nil, err
+	}
+	defer e.endOperation()
+	// Memory can only be read in the extended session
+	err = e.sessions.RequireSession(ctx, uds.SubfunctionExtendedDiagnosticSession)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to enter extended diagnostic session: %v", err), logging.LogLevelError)
+		return nil, err
+	}
+	defer e.endSession(ctx)
+	err = e.sessions.RequireSecurityLevel(ctx, RomSecurityLevelK01)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to unlock security access: %v", err), logging.LogLevelError)
+		return nil, err
+	}
+	metadata := newRomMetadata(e.identification, RomRegionK01)
+	file, rom, err := openPartialRom(path, metadata, RomReadBlockSizeK01)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to open ROM file: %v", err), logging.LogLevelError)
+		return nil, err
+	}
+	defer file.Close()
+	if len(rom) > 0 {
+		l.WriteLog(fmt.Sprintf("Resuming ROM read from 0x%08X", RomRegionK01.Start+uint32(len(rom))), logging.LogLevelInfo)
+	}
+	// Read the remaining blocks, saving each to the partial dump so the read can be resumed
+	reader := &k01MemoryReader{ecu: e}
+	defer func() { reader.close(ctx) }()
+	progress := newRomProgress(RomRegionK01.Size, func(message string) {
+		l.WriteLog("Reading ROM: "+message, logging.LogLevelInfo)
+	})
+	for offset := uint32(len(rom)); offset < RomRegionK01.Size; {
+		size := min(RomReadBlockSizeK01, RomRegionK01.Size-offset)
+		block, err := reader.readBlockWithRetries(ctx, RomRegionK01.Start+offset, size)
+		if err != nil {
+			l.WriteLog(fmt.Sprintf("Error failed to read ROM at 0x%08X: %v", RomRegionK01.Start+offset, err), logging.LogLevelError)
+			return nil, err
+		}
+		_, err = file.Write(block)
+		if err != nil {
+			l.WriteLog(fmt.Sprintf("Error failed to write ROM file: %v", err), logging.LogLevelError)
+			return nil, err
+		}
+		rom = append(rom, block...)
+		offset += size
+		progress.update(offset)
+	}
+	// Read everything back and compare, resumed blocks may have come from an earlier session
+	reader.close(ctx)
+	reader = &k01MemoryReader{ecu: e}
+	progress = newRomProgress(RomRegionK01.Size, func(message string) {
+		l.WriteLog("Verifying ROM: "+message, logging.LogLevelInfo)
+	})
+	for offset := uint32(0); offset < RomRegionK01.Size; {
+		size := min(RomReadBlockSizeK01, RomRegionK01.Size-offset)
+		block, err := reader.readBlockWithRetries(ctx, RomRegionK01.Start+offset, size)
+		if err != nil {
+			l.WriteLog(fmt.Sprintf("Error failed to read back ROM at 0x%08X: %v", RomRegionK01.Start+offset, err), logging.LogLevelError)
+			return nil, err
+		}
+		if !bytes.Equal(block, rom[offset:offset+size]) {
+			// Discard the partial dump so the next attempt starts from scratch
+			_ = file.Truncate(0)
+			err = fmt.Errorf("read back mismatch in block at 0x%08X", RomRegionK01.Start+offset)
+			l.WriteLog(fmt.Sprintf("Error failed to verify ROM: %v", err), logging.LogLevelError)
+			return nil, err
+		}
+		offset += size
+		progress.update(offset)
+	}
+	err = finishRom(path, metadata, rom)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to write ROM file: %v", err), logging.LogLevelError)
+		return nil, err
+	}
+	l.WriteLog(fmt.Sprintf("ROM READ SUCCESSFULLY: %s", path), logging.LogLevelSuccess)
+	return rom, nil
+}
+
+// request sends a request and reads the response, negative responses are returned along with an error.
+func (e *K01) request(ctx context.Context, req *uds.Message) (*uds.Message, error) {
+	if atomic.LoadInt32(&e.isRunning) == 0 {
+		return nil, fmt.Errorf("can't send request, ecu is not connected")
+	}
+	return e.sessions.Request(ctx, req)
+}
 
-	// TODO
+// endSession returns the ECU to the default session once an operation has finished, even if it was cancelled.
+func (e *K01) endSession(ctx context.Context) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	err := e.sessions.EndSession(context.WithoutCancel(ctx))
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to return to default session: %v", err), logging.LogLevelWarning)
+	}
+}
 
-	return nil, nil
+// beginOperation claims the diagnostic session for an operation. It fails if another operation is running, unless
+// both are shared operations with the same name. endOperation must be called once the session has been ended.
+func (e *K01) beginOperation(name string, shared bool) error {
+	e.operationLock.Lock()
+	defer e.operationLock.Unlock()
+	if e.operation != "" && (!shared || e.operation != name) {
+		return fmt.Errorf("%w, %s in progress", errorECUBusy, e.operation)
+	}
+	e.operation = name
+	e.operationUsers++
+	return nil
 }
 
-// readMessage will read the next UDS message received. It will block by the specified read timeout and will filter based on serviceId and subfunction
-func (e *K01) readMessage(ctx context.Context, serviceId *byte, subfunction *byte) (*uds.Message, error) {
+// endOperation releases the diagnostic session claimed with beginOperation.
+func (e *K01) endOperation() {
+	e.operationLock.Lock()
+	defer e.operationLock.Unlock()
+	e.releaseOperation()
+}
+
+// releaseOperation drops a user of the operation, operationLock must be held.
+func (e *K01) releaseOperation() {
+	e.operationUsers--
+	if e.operationUsers == 0 {
+		e.operation = ""
+	}
+}
+
+// k01MemoryReader reads ECU memory with ReadMemoryByAddress, falling back to RequestUpload
+// if the ECU doesn't support it.
+type k01MemoryReader struct {
+	ecu                  *K01
+	useUpload            bool
+	uploadActive         bool
+	uploadAddress        uint32
+	blockSequenceCounter byte
+}
+
+// readBlockWithRetries reads a block, retrying failed reads up to RomReadRetriesK01 times.
+func (r *k01MemoryReader) readBlockWithRetries(ctx context.Context, address uint32, size uint32) (block []byte, err error) {
 	l := services.Get(services.ServiceLogger).(*logging.Logger)
-	if atomic.LoadInt32(&e.isRunning) == 0 {
-		return nil, fmt.Errorf("can't read message ecu is not connected")
+	for attempt := 0; attempt <= RomReadRetriesK01; attempt++ {
+		if attempt > 0 {
+			l.WriteLog(fmt.Sprintf("Retrying ROM block at 0x%08X: %v", address, err), logging.LogLevelWarning)
+		}
+		block, err = r.readBlock(ctx, address, size)
+		if err == nil {
+			return block, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 	}
-	messageChan, _ := e.subscribeReadMessages()
-	defer e.unsubscribeReadMessages(messageChan)
-	readCtx, cancel := context.WithTimeout(ctx, ReadTimeoutK01)
-	defer cancel()
-	for {
-		select {
-		case message := <-messageChan:
-			// if service id is provided match service ids
-			if serviceId != nil && message.ServiceID != *serviceId {
-				continue
-			}
-			// IsPositive should never be nil on a response
-			if message.IsPositive == nil {
-				continue
-			}
-			// negative responses don't have subfunctions so we can return early
-			if !*message.IsPositive {
-				return message, nil
-			}
-			// if the subfunction filter was provided ensure message subfunction is present and then match subfunctions
-			if subfunction != nil && (message.Subfunction == nil || *message.Subfunction != *subfunction) {
-				continue
+	return nil, err
+}
+
+func (r *k01MemoryReader) readBlock(ctx context.Context, address uint32, size uint32) ([]byte, error) {
+	if !r.useUpload {
+		resp, err := r.ecu.request(ctx, uds.NewReadMemoryByAddressRequest(uds.TesterID, address, uint16(size)))
+		if err == nil {
+			if uint32(len(resp.Data)) != size {
+				return nil, fmt.Errorf("expected %d bytes but got %d", size, len(resp.Data))
 			}
-			return message, nil
-		case <-readCtx.Done():
-			l.WriteLog(fmt.Sprintf("Timeout waiting for request response, service ID: %02X, subfunction: %02X", &serviceId, &subfunction), logging.LogLevelError)
-			return nil, readCtx.Err()
+			return resp.Data, nil
 		}
+		if !errors.Is(err, uds.ErrNRCServiceNotSupported) {
+			return nil, err
+		}
+		r.useUpload = true
 	}
+	return r.uploadBlock(ctx, address, size)
 }
 
-// processAndBroadcastUDSMessages reads complete UDS messages from readChan, processes them, and broadcasts to subscribers.
-func (e *K01) processAndBroadcastUDSMessages(ctx context.Context) {
-	l := services.Get(services.ServiceLogger).(*logging.Logger)
-	defer e.wg.Done()
-	for {
-		select {
-		case <-ctx.Done():
-			l.WriteLog("Stopping UDS message processing due to context cancellation", logging.LogLevelInfo)
-			return
-		default:
-			message, err := uds.Read(ctx)
-			if err != nil {
-				if errors.Is(ctx.Err(), context.Canceled) {
-					return
-				}
-				l.WriteLog(fmt.Sprintf("Error reading UDS: %s", err.Error()), logging.LogLevelError)
-				continue
-			}
-			if message != nil {
-				if message.ServiceID != uds.ServiceTesterPresent {
-					l.WriteMessage("UDS: "+message.String(), logging.MessageTypeUDSRead)
-				}
-				e.messageBroadcaster.Broadcast(message)
-			}
+// uploadBlock reads a block using TransferData, starting an upload of the rest of the ROM if required.
+func (r *k01MemoryReader) uploadBlock(ctx context.Context, address uint32, size uint32) ([]byte, error) {
+	if !r.uploadActive || r.uploadAddress != address {
+		r.close(ctx)
+		end := RomRegionK01.Start + RomRegionK01.Size
+		resp, err := r.ecu.request(ctx, uds.NewRequestUploadRequest(uds.TesterID, address, end-address))
+		if err != nil {
+			return nil, err
+		}
+		maxBlockLength, err := uds.ParseMaxNumberOfBlockLength(resp)
+		if err != nil {
+			return nil, err
 		}
+		// The max block length includes the service id and block sequence counter
+		if maxBlockLength < size+2 {
+			return nil, fmt.Errorf("ecu max block length %d is smaller than the read block size", maxBlockLength)
+		}
+		r.uploadActive = true
+		r.uploadAddress = address
+		r.blockSequenceCounter = 0
+	}
+	// A failed block is retried with the same block sequence counter
+	blockSequenceCounter := r.blockSequenceCounter + 1
+	resp, err := r.ecu.request(ctx, uds.NewTransferDataRequest(uds.TesterID, blockSequenceCounter, nil))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) < 1 || resp.Data[0] != blockSequenceCounter {
+		return nil, fmt.Errorf("unexpected block sequence counter in transfer data response")
+	}
+	if uint32(len(resp.Data)-1) != size {
+		return nil, fmt.Errorf("expected %d bytes but got %d", size, len(resp.Data)-1)
+	}
+	r.blockSequenceCounter = blockSequenceCounter
+	r.uploadAddress += size
+	return resp.Data[1:], nil
+}
+
+// close ends any active upload.
+func (r *k01MemoryReader) close(ctx context.Context) {
+	if !r.uploadActive {
+		return
 	}
+	r.uploadActive = false
+	_, _ = r.ecu.request(ctx, uds.NewRequestTransferExitRequest(uds.TesterID))
+}
+
+// processAndBroadcastUDSMessages reads complete UDS messages and broadcasts them to subscribers.
+func (e *K01) processAndBroadcastUDSMessages(ctx context.Context) {
+	defer e.wg.Done()
+	processAndBroadcastMessages(ctx, e.messageBroadcaster)
 }
 
 func (e *K01) testerPresentLoop(ctx context.Context) {
@@ -292,9 +567,10 @@ func (e *K01) testerPresentLoop(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		default:
-			err := uds.SendTesterPresent(ctx)
-			if err != nil {
-				l.WriteLog(fmt.Sprintf("Error couldn't send UDS tester present"), logging.LogLevelError)
+			// Keeps non default sessions alive and restores them if the ECU dropped them
+			err := e.sessions.KeepAlive(ctx)
+			if err != nil && ctx.Err() == nil {
+				l.WriteLog(fmt.Sprintf("Error couldn't keep diagnostic session alive: %v", err), logging.LogLevelError)
 			}
 		}
 		time.Sleep(TesterPresentDelayK01)
@@ -303,78 +579,55 @@ func (e *K01) testerPresentLoop(ctx context.Context) {
 
 func (e *K01) scanEcu(ctx context.Context) (identification ECUId, err error) {
 	// Check hardware ID
-	serviceId := uds.ServiceReadIdK01
-	subfunction := uds.SubfunctionReadECUHardwareIdK01
-	req := &uds.Message{
-		SenderID:    uds.TesterID,
-		ServiceID:   serviceId,
-		Subfunction: &subfunction,
-	}
-	err = req.Send(ctx)
-	if err != nil {
-		return
-	}
-	resp, err := e.readMessage(ctx, &serviceId, &subfunction)
+	identification.hardwareId, err = e.readId(ctx, kwp.IdentificationOptionECUHardwareIdK01)
 	if err != nil {
 		return
 	}
-	if !slices.Contains(CompatibleECUHardwareIdsK01, resp.ASCIIRepresentation()) {
-		return identification, fmt.Errorf("incompatible hardware ID: %s", resp.ASCIIRepresentation())
+	if !slices.Contains(CompatibleECUHardwareIdsK01, identification.hardwareId) {
+		return identification, fmt.Errorf("incompatible hardware ID: %s", identification.hardwareId)
 	}
-	identification.hardwareId = resp.ASCIIRepresentation()
 	// Check software ID
-	subfunction = uds.SubfunctionReadECUSoftwareIdK01
-	req.Subfunction = &subfunction
-	err = req.Send(ctx)
+	identification.softwareId, err = e.readId(ctx, kwp.IdentificationOptionECUSoftwareIdK01)
 	if err != nil {
 		return
 	}
-	resp, err = e.readMessage(ctx, &serviceId, &subfunction)
-	if err != nil {
-		return
+	if !slices.Contains(CompatibleECUSoftwareIdsK01, identification.softwareId) {
+		return identification, fmt.Errorf("incompatible software ID: %s", identification.softwareId)
 	}
-	if !slices.Contains(CompatibleECUSoftwareIdsK01, resp.ASCIIRepresentation()) {
-		return identification, fmt.Errorf("incompatible software ID: %s", resp.ASCIIRepresentation())
-	}
-	identification.softwareId = resp.ASCIIRepresentation()
 	// Check model
-	subfunction = uds.SubfunctionReadModelK01
-	req.Subfunction = &subfunction
-	err = req.Send(ctx)
+	identification.model, err = e.readId(ctx, kwp.IdentificationOptionModelK01)
 	if err != nil {
 		return
 	}
-	resp, err = e.readMessage(ctx, &serviceId, &subfunction)
-	if err != nil {
-		return
-	}
-	if !slices.Contains(CompatibleModelsK01, resp.ASCIIRepresentation()) {
-		return identification, fmt.Errorf("incompatible model: %s", resp.ASCIIRepresentation())
+	if !slices.Contains(CompatibleModelsK01, identification.model) {
+		return identification, fmt.Errorf("incompatible model: %s", identification.model)
 	}
-	identification.model = resp.ASCIIRepresentation()
 	// Get VIN
-	subfunction = uds.SubfunctionReadVINK01
-	req.Subfunction = &subfunction
-	err = req.Send(ctx)
-	if err != nil {
-		return
-	}
-	resp, err = e.readMessage(ctx, &serviceId, &subfunction)
+	identification.vin, err = e.readId(ctx, kwp.IdentificationOptionVINK01)
 	if err != nil {
 		return
 	}
-	identification.vin = resp.ASCIIRepresentation()
 	// Get manufacturer
-	subfunction = uds.SubfunctionReadManufacturerK01
-	req.Subfunction = &subfunction
-	err = req.Send(ctx)
+	identification.manufacturer, err = e.readId(ctx, kwp.IdentificationOptionManufacturerK01)
+	return
+}
+
+// readId reads an identification option and returns it decoded by its definition.
+func (e *K01) readId(ctx context.Context, option byte) (string, error) {
+	record, err := kwp.ReadEcuIdentification(ctx, e.sessions, uds.TesterID, option)
 	if err != nil {
-		return
+		return "", err
 	}
-	resp, err = e.readMessage(ctx, &serviceId, &subfunction)
+	identifier, ok := uds.LookupDefinitions(seedkey.ECUTypeK01).Lookup(uds.SourceECUIdentification, uint16(option))
+	if !ok {
+		return "", fmt.Errorf("no definition for identification option 0x%02X", option)
+	}
+	values, err := identifier.Decode(record)
 	if err != nil {
-		return
+		return "", err
 	}
-	identification.manufacturer = resp.ASCIIRepresentation()
-	return
+	if len(values) != 1 {
+		return "", fmt.Errorf("identification option 0x%02X has %d values", option, len(values))
+	}
+	return values[0].FormattedValue(), nil
 }
@@ -0,0 +1,121 @@
+package ecus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"husk/logging"
+	"husk/seedkey"
+	"husk/services"
+	"husk/uds"
+)
+
+// Actuators returns the actuators defined for the K01.
+func (e *K01) Actuators() []uds.Actuator {
+	return uds.LookupDefinitions(seedkey.ECUTypeK01).Actuators
+}
+
+// RunActuatorTest drives the actuator with id for duration, limited to its maximum duration, using the defaults for
+// parameters that aren't given. Control is returned to the ECU when the test ends, ctx is done or StopActuatorTests
+// is called. The returned test can be stopped early.
+func (e *K01) RunActuatorTest(ctx context.Context, id uint16, parameters map[string]float64, duration time.Duration) (*uds.ActuatorTest, error) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	actuator, ok := uds.LookupDefinitions(seedkey.ECUTypeK01).LookupActuator(id)
+	if !ok {
+		err := fmt.Errorf("no actuator 0x%04X", id)
+		l.WriteLog(fmt.Sprintf("Error failed to test actuator: %v", err), logging.LogLevelError)
+		return nil, err
+	}
+	_, err := actuator.EncodeParameters(parameters)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error refusing to test %s: %v", actuator.Name, err), logging.LogLevelError)
+		return nil, err
+	}
+	// Actuator tests can run together, they share the extended session
+	err = e.beginOperation("actuator test", true)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to test %s: %v", actuator.Name, err), logging.LogLevelError)
+		return nil, err
+	}
+	err = e.sessions.RequireSession(ctx, uds.SubfunctionExtendedDiagnosticSession)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to enter extended diagnostic session: %v", err), logging.LogLevelError)
+		e.endActuatorSession(ctx)
+		return nil, err
+	}
+	if actuator.SecurityLevel != seedkey.SecurityLevelUnspecified {
+		err = e.sessions.RequireSecurityLevel(ctx, actuator.SecurityLevel)
+		if err != nil {
+			l.WriteLog(fmt.Sprintf("Error failed to unlock security access: %v", err), logging.LogLevelError)
+			e.endActuatorSession(ctx)
+			return nil, err
+		}
+	}
+	test, err := uds.StartActuatorTest(ctx, e.sessions, uds.TesterID, actuator, parameters, duration)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to start %s test: %v", actuator.Name, err), logging.LogLevelError)
+		e.endActuatorSession(ctx)
+		return nil, err
+	}
+	// The test is counted before it is published so StopActuatorTests can't miss it while waiting
+	e.actuatorLock.Lock()
+	e.actuatorWG.Add(1)
+	e.actuatorTests = append(e.actuatorTests, test)
+	e.actuatorLock.Unlock()
+	l.WriteLog(fmt.Sprintf("Testing %s", actuator.Name), logging.LogLevelInfo)
+	go e.finishActuatorTest(ctx, test)
+	return test, nil
+}
+
+// StopActuatorTests stops every running actuator test and waits for control to be returned to the ECU.
+func (e *K01) StopActuatorTests() {
+	e.actuatorLock.Lock()
+	tests := append([]*uds.ActuatorTest(nil), e.actuatorTests...)
+	e.actuatorLock.Unlock()
+	for _, test := range tests {
+		_ = test.Stop()
+	}
+	// Wait for the tests to be logged and the session to be ended
+	e.actuatorWG.Wait()
+}
+
+// finishActuatorTest waits for a test to return control, then ends the session once no other test needs it.
+func (e *K01) finishActuatorTest(ctx context.Context, test *uds.ActuatorTest) {
+	defer e.actuatorWG.Done()
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	<-test.Done()
+	err := test.Err()
+	switch {
+	case errors.Is(err, uds.ErrActuatorTestSession):
+		l.WriteLog(fmt.Sprintf("%s test ended, the ECU left the diagnostic session", test.Actuator.Name), logging.LogLevelWarning)
+	case errors.Is(err, context.Canceled):
+		l.WriteLog(fmt.Sprintf("%s test cancelled, control returned to ECU", test.Actuator.Name), logging.LogLevelWarning)
+	case err != nil:
+		l.WriteLog(fmt.Sprintf("Error %s test: %v", test.Actuator.Name, err), logging.LogLevelError)
+	default:
+		l.WriteLog(fmt.Sprintf("%s TEST COMPLETE, CONTROL RETURNED TO ECU", test.Actuator.Name), logging.LogLevelSuccess)
+	}
+	e.actuatorLock.Lock()
+	for i, running := range e.actuatorTests {
+		if running == test {
+			e.actuatorTests = append(e.actuatorTests[:i], e.actuatorTests[i+1:]...)
+			break
+		}
+	}
+	e.actuatorLock.Unlock()
+	e.endActuatorSession(context.WithoutCancel(ctx))
+}
+
+// endActuatorSession returns the ECU to the default session unless another actuator test is still using it, then
+// releases the operation claimed by the test.
+func (e *K01) endActuatorSession(ctx context.Context) {
+	// Hold the operation so no test can join while the session is being ended
+	e.operationLock.Lock()
+	defer e.operationLock.Unlock()
+	if e.operationUsers == 1 {
+		e.endSession(ctx)
+	}
+	e.releaseOperation()
+}
@@ -0,0 +1,204 @@
+package ecus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"slices"
+
+	"husk/logging"
+	"husk/services"
+	"husk/uds"
+)
+
+// FlashRetriesK01 is the number of times a failed TransferData block is resent
+const FlashRetriesK01 = 3
+
+// FlashECURom programs the ROM image at path into the ECU. The image must have a metadata sidecar, as written
+// by ReadECURom, with a hardware id that is compatible with K01 and matches the connected ECU. The other ECUs on
+// the bus are kept quiet while flashing and the ECU is reset afterwards, whether or not flashing succeeded.
+func (e *K01) FlashECURom(ctx context.Context, path string) error {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	l.WriteLog("Starting ROM flash process", logging.LogLevelInfo)
+	image, err := e.loadFlashImage(path)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error refusing to flash ROM: %v", err), logging.LogLevelError)
+		return err
+	}
+	err = e.beginOperation("ROM flash", false)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error refusing to flash ROM: %v", err), logging.LogLevelError)
+		return err
+	}
+	defer e.endOperation()
+	// The programming preamble is sent from the extended session
+	err = e.sessions.RequireSession(ctx, uds.SubfunctionExtendedDiagnosticSession)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to enter extended diagnostic session: %v", err), logging.LogLevelError)
+		return err
+	}
+	l.WriteLog("Disabling DTC setting and communication on the bus", logging.LogLevelInfo)
+	preparation, err := uds.PrepareForProgramming(ctx, e.sessions, uds.TesterID)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to prepare the bus for programming: %v", err), logging.LogLevelError)
+		e.endSession(context.WithoutCancel(ctx))
+		return err
+	}
+	err = e.flashImage(ctx, image)
+	// Reset the ECU so it boots the new ROM, or whatever is left of it after a failure
+	l.WriteLog("Restoring DTC setting and communication and resetting ECU", logging.LogLevelInfo)
+	restoreErr := preparation.Restore(ctx, uds.SubfunctionHardReset)
+	if restoreErr != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to restore the bus after flashing: %v", restoreErr), logging.LogLevelWarning)
+	}
+	// The ECU restarts in the default session, don't restore the programming session
+	e.endSession(context.WithoutCancel(ctx))
+	if err != nil {
+		return err
+	}
+	l.WriteLog("ROM FLASHED SUCCESSFULLY", logging.LogLevelSuccess)
+	return nil
+}
+
+// flashImage erases the ROM, downloads image and verifies its checksum in the programming session.
+func (e *K01) flashImage(ctx context.Context, image []byte) error {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	// Programming requires the programming session, which locks security access again
+	err := e.sessions.RequireSession(ctx, uds.SubfunctionProgrammingSession)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to enter programming session: %v", err), logging.LogLevelError)
+		return err
+	}
+	err = e.sessions.RequireSecurityLevel(ctx, RomSecurityLevelK01)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to unlock security access: %v", err), logging.LogLevelError)
+		return err
+	}
+	l.WriteLog("Erasing ROM", logging.LogLevelInfo)
+	_, err = e.startRoutine(ctx, uds.RoutineEraseMemory, addressAndSize(RomRegionK01))
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to erase ROM: %v", err), logging.LogLevelError)
+		return err
+	}
+	err = e.downloadImage(ctx, image)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to download ROM: %v", err), logging.LogLevelError)
+		return err
+	}
+	l.WriteLog("Verifying ROM checksum", logging.LogLevelInfo)
+	options := binary.BigEndian.AppendUint32(addressAndSize(RomRegionK01), crc32.ChecksumIEEE(image))
+	status, err := e.startRoutine(ctx, uds.RoutineCheckMemoryK01, options)
+	if err == nil && status != uds.RoutineStatusCorrect {
+		err = fmt.Errorf("checksum routine reported status 0x%02X", status)
+	}
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to verify ROM: %v", err), logging.LogLevelError)
+		return err
+	}
+	return nil
+}
+
+// loadFlashImage loads a ROM image and checks it was made for this ECU.
+func (e *K01) loadFlashImage(path string) ([]byte, error) {
+	if e.identification == nil {
+		return nil, fmt.Errorf("ecu has not been identified")
+	}
+	image, metadata, err := loadRomImage(path)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(CompatibleECUHardwareIdsK01, metadata.HardwareId) {
+		return nil, fmt.Errorf("incompatible hardware ID: %s", metadata.HardwareId)
+	}
+	if metadata.HardwareId != e.identification.hardwareId {
+		return nil, fmt.Errorf("image hardware ID %s doesn't match ecu hardware ID %s", metadata.HardwareId, e.identification.hardwareId)
+	}
+	if metadata.StartAddress != RomRegionK01.Start || metadata.Size != RomRegionK01.Size {
+		return nil, fmt.Errorf("image region 0x%08X+0x%X doesn't match the ROM region", metadata.StartAddress, metadata.Size)
+	}
+	return image, nil
+}
+
+// downloadImage streams the image to the ECU with RequestDownload, TransferData and RequestTransferExit.
+func (e *K01) downloadImage(ctx context.Context, image []byte) error {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	resp, err := e.request(ctx, uds.NewRequestDownloadRequest(uds.TesterID, RomRegionK01.Start, RomRegionK01.Size))
+	if err != nil {
+		return err
+	}
+	maxBlockLength, err := uds.ParseMaxNumberOfBlockLength(resp)
+	if err != nil {
+		return err
+	}
+	// The max block length includes the service id and block sequence counter
+	if maxBlockLength <= 2 {
+		return fmt.Errorf("invalid max block length %d", maxBlockLength)
+	}
+	blockSize := maxBlockLength - 2
+	progress := newRomProgress(RomRegionK01.Size, func(message string) {
+		l.WriteLog("Flashing ROM: "+message, logging.LogLevelInfo)
+	})
+	// The block sequence counter starts at 1 and wraps from 0xFF to 0x00
+	blockSequenceCounter := byte(1)
+	for offset := uint32(0); offset < RomRegionK01.Size; {
+		size := min(blockSize, RomRegionK01.Size-offset)
+		err = e.transferBlock(ctx, blockSequenceCounter, image[offset:offset+size])
+		if err != nil {
+			return fmt.Errorf("block at 0x%08X: %w", RomRegionK01.Start+offset, err)
+		}
+		blockSequenceCounter++
+		offset += size
+		progress.update(offset)
+	}
+	_, err = e.request(ctx, uds.NewRequestTransferExitRequest(uds.TesterID))
+	return err
+}
+
+// transferBlock sends a single TransferData block, resending it if the request fails.
+func (e *K01) transferBlock(ctx context.Context, blockSequenceCounter byte, block []byte) error {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	var err error
+	for attempt := 0; attempt <= FlashRetriesK01; attempt++ {
+		if attempt > 0 {
+			l.WriteLog(fmt.Sprintf("Resending transfer block %d: %v", blockSequenceCounter, err), logging.LogLevelWarning)
+		}
+		var resp *uds.Message
+		resp, err = e.request(ctx, uds.NewTransferDataRequest(uds.TesterID, blockSequenceCounter, block))
+		if err == nil {
+			if len(resp.Data) < 1 || resp.Data[0] != blockSequenceCounter {
+				return fmt.Errorf("unexpected block sequence counter in transfer data response")
+			}
+			return nil
+		}
+		// Anything other than a lost response or a busy ECU won't be fixed by resending
+		var nrcErr *uds.NRCError
+		if errors.As(err, &nrcErr) && !errors.Is(err, uds.ErrNRCBusyRepeatRequest) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// startRoutine starts a routine and returns the routine status byte from the response.
+func (e *K01) startRoutine(ctx context.Context, routineId uint16, options []byte) (byte, error) {
+	record, err := uds.RoutineControl(ctx, e.sessions, uds.TesterID, uds.SubfunctionStartRoutine, routineId, options)
+	if err != nil {
+		return 0, err
+	}
+	if len(record) < 1 {
+		return 0, fmt.Errorf("routine control response too short")
+	}
+	return record[0], nil
+}
+
+// addressAndSize encodes a memory region using AddressAndLengthFormat4x4.
+func addressAndSize(region MemoryRegion) []byte {
+	data := []byte{uds.AddressAndLengthFormat4x4}
+	data = binary.BigEndian.AppendUint32(data, region.Start)
+	return binary.BigEndian.AppendUint32(data, region.Size)
+}
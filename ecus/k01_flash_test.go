@@ -0,0 +1,72 @@
+package ecus
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"husk/drivers"
+	"husk/logging"
+	"husk/simulator"
+	"husk/uds"
+)
+
+// setupSimulatedK01 connects to a simulated K01 on a virtual bus and flashes only region of its ROM.
+func setupSimulatedK01(t *testing.T, region MemoryRegion) (context.Context, *K01, *simulator.K01) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+	// The logger isn't started, nothing displays the log in tests
+	logging.RegisterLogger()
+	bus := drivers.NewVirtualBus(drivers.VirtualBusConfig{Latency: time.Millisecond})
+	t.Cleanup(bus.Close)
+	sim, err := simulator.NewK01(bus, simulator.DefaultK01Config()).Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sim.Cleanup)
+	tester := drivers.NewVirtualDriver(bus, drivers.VirtualTesterName)
+	_, _ = tester.Register()
+	_, _ = tester.Start(ctx)
+	found := ScanK01(ctx, nil)
+	if len(found) != 1 {
+		t.Fatalf("found %d ECUs, want 1", len(found))
+	}
+	e := found[0].(*K01)
+	if _, err = e.Register(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = e.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Cleanup)
+	romRegion := RomRegionK01
+	RomRegionK01 = region
+	t.Cleanup(func() { RomRegionK01 = romRegion })
+	return ctx, e, sim
+}
+
+func TestFlashECURomResendsBlockAfterLostResponse(t *testing.T) {
+	region := MemoryRegion{Start: 0x1000, Size: 0x1100}
+	ctx, e, sim := setupSimulatedK01(t, region)
+	image := bytes.Repeat([]byte{0xAB}, int(region.Size))
+	path := filepath.Join(t.TempDir(), "rom.bin")
+	if err := os.WriteFile(path, image, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeRomMetadata(path, newRomMetadata(e.identification, region)); err != nil {
+		t.Fatal(err)
+	}
+	// The ECU programs the first block but the tester never hears about it
+	sim.DropResponses(uds.ServiceTransferData, 1)
+
+	if err := e.FlashECURom(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+	rom := sim.Rom()[region.Start : region.Start+region.Size]
+	if !bytes.Equal(rom, image) {
+		t.Fatal("flashed ROM doesn't match the image")
+	}
+}
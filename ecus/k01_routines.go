@@ -0,0 +1,65 @@
+package ecus
+
+import (
+	"context"
+	"fmt"
+
+	"husk/logging"
+	"husk/seedkey"
+	"husk/services"
+	"husk/uds"
+)
+
+// Routines returns the routines defined for the K01.
+func (e *K01) Routines() []uds.Routine {
+	return uds.LookupDefinitions(seedkey.ECUTypeK01).Routines
+}
+
+// RunRoutine runs the routine with id in the extended session, using the defaults for parameters that aren't given.
+func (e *K01) RunRoutine(ctx context.Context, id uint16, parameters map[string]float64) (*uds.RoutineResult, error) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	routine, ok := uds.LookupDefinitions(seedkey.ECUTypeK01).LookupRoutine(id)
+	if !ok {
+		err := fmt.Errorf("no routine 0x%04X", id)
+		l.WriteLog(fmt.Sprintf("Error failed to run routine: %v", err), logging.LogLevelError)
+		return nil, err
+	}
+	// Check the parameters before changing session
+	_, err := routine.EncodeParameters(parameters)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error refusing to run %s: %v", routine.Name, err), logging.LogLevelError)
+		return nil, err
+	}
+	err = e.beginOperation("routine", false)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to run %s: %v", routine.Name, err), logging.LogLevelError)
+		return nil, err
+	}
+	defer e.endOperation()
+	l.WriteLog(fmt.Sprintf("Running %s", routine.Name), logging.LogLevelInfo)
+	err = e.sessions.RequireSession(ctx, uds.SubfunctionExtendedDiagnosticSession)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to enter extended diagnostic session: %v", err), logging.LogLevelError)
+		return nil, err
+	}
+	defer e.endSession(ctx)
+	if routine.SecurityLevel != seedkey.SecurityLevelUnspecified {
+		err = e.sessions.RequireSecurityLevel(ctx, routine.SecurityLevel)
+		if err != nil {
+			l.WriteLog(fmt.Sprintf("Error failed to unlock security access: %v", err), logging.LogLevelError)
+			return nil, err
+		}
+	}
+	result, err := uds.RunRoutine(ctx, e.sessions, uds.TesterID, routine, parameters)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to run %s: %v", routine.Name, err), logging.LogLevelError)
+		return result, err
+	}
+	if !result.Succeeded() {
+		l.WriteLog(result.String(), logging.LogLevelError)
+		return result, nil
+	}
+	l.WriteLog(fmt.Sprintf("%s COMPLETED SUCCESSFULLY", routine.Name), logging.LogLevelSuccess)
+	l.WriteLog(result.String(), logging.LogLevelResult)
+	return result, nil
+}
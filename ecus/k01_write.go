@@ -0,0 +1,192 @@
+package ecus
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"husk/logging"
+	"husk/seedkey"
+	"husk/services"
+	"husk/uds"
+)
+
+// WritableIdentifiers returns the data identifiers that are marked writable in the definitions.
+func (e *K01) WritableIdentifiers() []uds.Identifier {
+	var writable []uds.Identifier
+	for _, identifier := range uds.LookupDefinitions(seedkey.ECUTypeK01).BySource(uds.SourceDataIdentifier) {
+		if identifier.Writable {
+			writable = append(writable, identifier)
+		}
+	}
+	return writable
+}
+
+// WriteDataIdentifier sets a writable data identifier to record. The current value is read and stored in the
+// write journal first, and the identifier is read back afterwards to check the ECU kept the new value.
+func (e *K01) WriteDataIdentifier(ctx context.Context, id uint16, record []byte) error {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	identifier, ok := uds.LookupDefinitions(seedkey.ECUTypeK01).Lookup(uds.SourceDataIdentifier, id)
+	if !ok || !identifier.Writable {
+		err := fmt.Errorf("data identifier 0x%04X isn't writable", id)
+		l.WriteLog(fmt.Sprintf("Error refusing to write: %v", err), logging.LogLevelError)
+		return err
+	}
+	return e.writeDataIdentifier(ctx, identifier, func([]byte) ([]byte, error) {
+		return record, nil
+	}, WriteJournalEntry{Action: WriteJournalActionWrite})
+}
+
+// WriteDataIdentifierValue sets the field called name of a writable data identifier to value in its
+// engineering units, e.g. WriteDataIdentifierValue(ctx, "Idle Speed Target", 1600).
+func (e *K01) WriteDataIdentifierValue(ctx context.Context, name string, value float64) error {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	identifier, field, ok := uds.LookupDefinitions(seedkey.ECUTypeK01).LookupField(uds.SourceDataIdentifier, name)
+	if !ok || !identifier.Writable {
+		err := fmt.Errorf("%s isn't writable", name)
+		l.WriteLog(fmt.Sprintf("Error refusing to write: %v", err), logging.LogLevelError)
+		return err
+	}
+	// Other fields in the record keep their current values
+	return e.writeDataIdentifier(ctx, identifier, func(current []byte) ([]byte, error) {
+		record := bytes.Clone(current)
+		return record, field.Encode(record, value)
+	}, WriteJournalEntry{Action: WriteJournalActionWrite})
+}
+
+// RollbackWrite restores the value a data identifier had before the write with sequence in the write journal,
+// or before the latest write to this ECU that hasn't been rolled back if sequence is 0.
+func (e *K01) RollbackWrite(ctx context.Context, sequence int) error {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	if e.identification == nil {
+		return fmt.Errorf("ecu has not been identified")
+	}
+	entries, err := ReadWriteJournal()
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to read write journal: %v", err), logging.LogLevelError)
+		return err
+	}
+	entry, err := findRollbackEntry(entries, e.identification.vin, sequence)
+	if err == nil && entry.VIN != e.identification.vin {
+		err = fmt.Errorf("write %d was made to %s not this ECU", entry.Sequence, entry.VIN)
+	}
+	var previous []byte
+	if err == nil {
+		previous, err = entry.PreviousRecord()
+	}
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error refusing to roll back: %v", err), logging.LogLevelError)
+		return err
+	}
+	identifier, ok := uds.LookupDefinitions(seedkey.ECUTypeK01).Lookup(uds.SourceDataIdentifier, entry.ID)
+	if !ok {
+		// The definition may have been removed since the write, the record is restored as it was read
+		identifier = uds.Identifier{Source: uds.SourceDataIdentifier, ID: entry.ID, Name: entry.Name}
+	}
+	return e.writeDataIdentifier(ctx, identifier, func(current []byte) ([]byte, error) {
+		if hex.EncodeToString(current) != entry.Value {
+			l.WriteLog(fmt.Sprintf("%s has changed since write %d, restoring it anyway", identifier.Name, entry.Sequence), logging.LogLevelWarning)
+		}
+		return previous, nil
+	}, WriteJournalEntry{Action: WriteJournalActionRollback, RollbackOf: entry.Sequence})
+}
+
+// writeDataIdentifier reads the current record, journals it along with the record returned by update, writes
+// the new record and reads it back.
+func (e *K01) writeDataIdentifier(ctx context.Context, identifier uds.Identifier, update func(current []byte) ([]byte, error), entry WriteJournalEntry) error {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	if e.identification == nil {
+		return fmt.Errorf("ecu has not been identified")
+	}
+	err := e.beginOperation("data identifier write", false)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to write %s: %v", identifier.Name, err), logging.LogLevelError)
+		return err
+	}
+	defer e.endOperation()
+	l.WriteLog(fmt.Sprintf("Writing %s", identifier.Name), logging.LogLevelInfo)
+	// Data identifiers can only be written in the extended session
+	err = e.sessions.RequireSession(ctx, uds.SubfunctionExtendedDiagnosticSession)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to enter extended diagnostic session: %v", err), logging.LogLevelError)
+		return err
+	}
+	defer e.endSession(ctx)
+	if identifier.SecurityLevel != seedkey.SecurityLevelUnspecified {
+		err = e.sessions.RequireSecurityLevel(ctx, identifier.SecurityLevel)
+		if err != nil {
+			l.WriteLog(fmt.Sprintf("Error failed to unlock security access: %v", err), logging.LogLevelError)
+			return err
+		}
+	}
+	current, err := uds.ReadDataByIdentifier(ctx, e.sessions, uds.TesterID, identifier.ID)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to read %s before writing: %v", identifier.Name, err), logging.LogLevelError)
+		return err
+	}
+	record, err := update(current)
+	if err == nil && identifier.Length() > 0 && len(record) != identifier.Length() {
+		err = fmt.Errorf("%s is %d bytes but the value is %d", identifier.Name, identifier.Length(), len(record))
+	}
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error refusing to write: %v", err), logging.LogLevelError)
+		return err
+	}
+	if bytes.Equal(record, current) {
+		l.WriteLog(fmt.Sprintf("%s is already %s", identifier.Name, formatRecord(identifier, record)), logging.LogLevelResult)
+		return nil
+	}
+
+	// Keep the current value before anything is changed
+	entry.HardwareId = e.identification.hardwareId
+	entry.SoftwareId = e.identification.softwareId
+	entry.VIN = e.identification.vin
+	entry.ID = identifier.ID
+	entry.Name = identifier.Name
+	entry.Previous = hex.EncodeToString(current)
+	entry.Value = hex.EncodeToString(record)
+	entry, err = appendWriteJournal(entry)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error refusing to write without a backup, failed to write journal: %v", err), logging.LogLevelError)
+		return err
+	}
+
+	err = uds.WriteDataByIdentifier(ctx, e.sessions, uds.TesterID, identifier.ID, record)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to write %s: %v", identifier.Name, err), logging.LogLevelError)
+		return err
+	}
+	readBack, err := uds.ReadDataByIdentifier(ctx, e.sessions, uds.TesterID, identifier.ID)
+	if err == nil && !bytes.Equal(readBack, record) {
+		err = fmt.Errorf("read back %s but wrote %s", formatRecord(identifier, readBack), formatRecord(identifier, record))
+	}
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to verify %s, the previous value is in write %d of the journal: %v", identifier.Name, entry.Sequence, err), logging.LogLevelError)
+		return err
+	}
+	if entry.Action == WriteJournalActionRollback {
+		l.WriteLog(fmt.Sprintf("ROLLED BACK WRITE %d SUCCESSFULLY: %s is %s", entry.RollbackOf, identifier.Name, formatRecord(identifier, record)), logging.LogLevelSuccess)
+		return nil
+	}
+	l.WriteLog(fmt.Sprintf("WROTE %s SUCCESSFULLY: %s (was %s, journal entry %d)", identifier.Name,
+		formatRecord(identifier, record), formatRecord(identifier, current), entry.Sequence), logging.LogLevelSuccess)
+	return nil
+}
+
+// formatRecord returns the decoded values of a record, or the record in hex if it can't be decoded.
+func formatRecord(identifier uds.Identifier, record []byte) string {
+	values, err := identifier.Decode(record)
+	if err != nil || len(values) == 0 {
+		return fmt.Sprintf("0x%X", record)
+	}
+	if len(values) == 1 {
+		return values[0].FormattedValue()
+	}
+	formatted := make([]string, len(values))
+	for i, value := range values {
+		formatted[i] = value.String()
+	}
+	return strings.Join(formatted, ", ")
+}
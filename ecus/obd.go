@@ -0,0 +1,340 @@
+package ecus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"husk/logging"
+	"husk/obd"
+	"husk/seedkey"
+	"husk/services"
+	"husk/uds"
+)
+
+// OBD covers any ECU answering the generic OBD-II (SAE J1979) requests sent to every ECU on the functional
+// address. It gives basic diagnostics for bikes that don't have a processor of their own.
+type OBD struct {
+	isRunning          int32 // Use int32 for atomic operations
+	responderIDs       []uint16
+	vin                string
+	messageBroadcaster *uds.MessageBroadcaster
+	client             *obd.Client
+	wg                 sync.WaitGroup
+	cancelFunc         context.CancelFunc
+}
+
+var errorUnsupportedOBD = errors.New("not supported over OBD-II")
+
+// obdDTCModes are the modes read by ReadErrors along with the status they report
+var obdDTCModes = []struct {
+	mode   byte
+	status string
+}{
+	{obd.ModeShowStoredDTCs, "Stored"},
+	{obd.ModeShowPendingDTCs, "Pending"},
+	{obd.ModeShowPermanentDTCs, "Permanent"},
+}
+
+func (e *OBD) GetTesterId() uint16 {
+	return uds.FunctionalID
+}
+
+func (e *OBD) GetECUId() uint16 {
+	if len(e.responderIDs) == 0 {
+		return uds.PhysicalResponseIDMin
+	}
+	return e.responderIDs[0]
+}
+
+// Protocol returns OBD-II.
+func (e *OBD) Protocol() *uds.Protocol {
+	return obd.Protocol
+}
+
+// ScanOBD looks for ECUs answering OBD-II requests, they are added to ecus as a single processor.
+func ScanOBD(ctx context.Context, ecus []ECUProcessor) []ECUProcessor {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	// Create a temporary instance of the processor
+	tempProcessor := &OBD{}
+	_, err := tempProcessor.Register()
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Failed to register temp OBD-II ECU Processor: %v", err), logging.LogLevelError)
+		return ecus
+	}
+	// We want to deregister our temporary ecu service so that the actual ecu service can be registered
+	defer services.Deregister(services.ServiceECU)
+	_, err = tempProcessor.Start(ctx)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Failed to start temp OBD-II ECU Processor: %v", err), logging.LogLevelError)
+		return ecus
+	}
+	defer tempProcessor.Cleanup()
+	l.WriteLog("Scanning for OBD-II ECUs", logging.LogLevelInfo)
+	// Every OBD-II ECU supports the first PID support bitmask
+	responses, err := tempProcessor.client.Request(ctx, obd.ModeShowCurrentData, obd.PIDSupported01To20)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("No OBD-II ECU detected: %v", err), logging.LogLevelWarning)
+		return ecus
+	}
+	e := &OBD{responderIDs: slices.Sorted(maps.Keys(responses))}
+	tempProcessor.client.SetResponders(e.responderIDs)
+	// Not every ECU knows the VIN
+	vins, err := obd.ReadVIN(ctx, tempProcessor.client)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Failed to read VIN over OBD-II: %v", err), logging.LogLevelWarning)
+	}
+	for _, responderID := range e.responderIDs {
+		if vin, ok := vins[responderID]; ok {
+			e.vin = vin
+			break
+		}
+	}
+	l.WriteLog(fmt.Sprintf("Found %d OBD-II ECUs", len(e.responderIDs)), logging.LogLevelSuccess)
+	return append(ecus, e)
+}
+
+// String returns the VIN and response IDs of the ECUs
+func (e *OBD) String() string {
+	vin := e.vin
+	if vin == "" {
+		vin = "Unknown VIN"
+	}
+	ids := make([]string, len(e.responderIDs))
+	for i, responderID := range e.responderIDs {
+		ids[i] = fmt.Sprintf("0x%03X", responderID)
+	}
+	return fmt.Sprintf("OBD-II %s ECUs: %s", vin, strings.Join(ids, ", "))
+}
+
+func (e *OBD) Register() (ECUProcessor, error) {
+	services.Register(services.ServiceECU, e)
+	// Any ECU may answer, label and decode every responder as OBD-II
+	for testerID := uds.RequesterID(uds.PhysicalResponseIDMin); testerID <= uds.RequesterID(uds.PhysicalResponseIDMax); testerID++ {
+		uds.ConfigureDefinitions(testerID, seedkey.ECUTypeOBD)
+		uds.ConfigureProtocol(testerID, e.Protocol())
+	}
+	e.messageBroadcaster = uds.NewUDSMessageBroadcaster()
+	e.client = obd.NewClient(e.messageBroadcaster)
+	e.client.SetResponders(e.responderIDs)
+	return e, nil
+}
+
+func (e *OBD) Start(ctx context.Context) (ECUProcessor, error) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	ecuCtx, cancelFunc := context.WithCancel(ctx)
+	e.cancelFunc = cancelFunc
+	atomic.StoreInt32(&e.isRunning, 1)
+	// OBD-II has no sessions to keep alive, only responses need reading
+	e.wg.Add(1)
+	go e.processAndBroadcastMessages(ecuCtx)
+	l.WriteLog("ECU processor running", logging.LogLevelSuccess)
+	return e, nil
+}
+
+// Cleanup stops the processor and releases all resources.
+func (e *OBD) Cleanup() {
+	if !atomic.CompareAndSwapInt32(&e.isRunning, 1, 0) {
+		return
+	}
+	if e.cancelFunc != nil {
+		e.cancelFunc()
+	}
+	if e.messageBroadcaster != nil {
+		e.messageBroadcaster.Cleanup()
+	}
+	e.wg.Wait()
+}
+
+// ReadErrors reads the stored, pending and permanent DTCs of every ECU. ECUs that don't keep pending or
+// permanent DTCs don't answer for them.
+func (e *OBD) ReadErrors(ctx context.Context) (dtcs []string) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	result := "ERRORS:\n"
+	for _, dtcMode := range obdDTCModes {
+		codes, err := obd.ReadDTCs(ctx, e.client, dtcMode.mode)
+		if err != nil && dtcMode.mode == obd.ModeShowStoredDTCs {
+			l.WriteLog(fmt.Sprintf("Error failed to read errors: %v", err), logging.LogLevelError)
+			return nil
+		}
+		if err != nil {
+			l.WriteLog(fmt.Sprintf("Failed to read %s DTCs: %v", strings.ToLower(dtcMode.status), err), logging.LogLevelWarning)
+			continue
+		}
+		for _, responderID := range slices.Sorted(maps.Keys(codes)) {
+			for _, code := range codes[responderID] {
+				if !slices.Contains(dtcs, uds.FormatDTC(code)) {
+					dtcs = append(dtcs, uds.FormatDTC(code))
+				}
+				result += fmt.Sprintf("DTC: %s\nStatus: %s\nECU: 0x%03X\n", uds.GetDTCLabel(seedkey.ECUTypeOBD, code), dtcMode.status, responderID)
+			}
+		}
+	}
+	l.WriteLog("SUCCESSFULLY READ ERRORS", logging.LogLevelSuccess)
+	if len(dtcs) > 0 {
+		l.WriteLog(result, logging.LogLevelResult)
+		return
+	}
+	l.WriteLog("NO ERRORS FOUND", logging.LogLevelResult)
+	return
+}
+
+// ClearErrors clears the DTCs and freeze frames of every ECU and turns off the MIL.
+func (e *OBD) ClearErrors(ctx context.Context) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	_, err := obd.ClearDTCs(ctx, e.client)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to clear errors: %v", err), logging.LogLevelError)
+		return
+	}
+	l.WriteLog("CLEARED ERRORS SUCCESSFULLY", logging.LogLevelSuccess)
+}
+
+// ReadData logs the current value of every PID each ECU supports along with its VIN, calibration ids and
+// calibration verification numbers.
+func (e *OBD) ReadData(ctx context.Context) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	values, err := e.ReadCurrentData(ctx)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error failed to read current data: %v", err), logging.LogLevelError)
+		return
+	}
+	vins, err := obd.ReadVIN(ctx, e.client)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Failed to read VIN: %v", err), logging.LogLevelWarning)
+	}
+	calibrationIDs, err := obd.ReadCalibrationIDs(ctx, e.client)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Failed to read calibration ids: %v", err), logging.LogLevelWarning)
+	}
+	cvns, err := obd.ReadCalibrationVerificationNumbers(ctx, e.client)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Failed to read calibration verification numbers: %v", err), logging.LogLevelWarning)
+	}
+	result := "OBD-II DATA:\n"
+	for _, responderID := range e.responderIDs {
+		result += fmt.Sprintf("ECU: 0x%03X\n", responderID)
+		if vin, ok := vins[responderID]; ok {
+			result += fmt.Sprintf("VIN: %s\n", vin)
+		}
+		for i, id := range calibrationIDs[responderID] {
+			result += fmt.Sprintf("Calibration ID: %s\n", id)
+			if i < len(cvns[responderID]) {
+				result += fmt.Sprintf("Calibration Verification Number: %s\n", cvns[responderID][i])
+			}
+		}
+		for _, value := range values[responderID] {
+			result += value.String() + "\n"
+		}
+	}
+	l.WriteLog("SUCCESSFULLY READ OBD-II DATA", logging.LogLevelSuccess)
+	l.WriteLog(result, logging.LogLevelResult)
+}
+
+// ReadCurrentData returns the decoded value of every mode 01 PID each ECU supports, keyed by the ID the ECU
+// responded on. PIDs without a definition are returned raw.
+func (e *OBD) ReadCurrentData(ctx context.Context) (map[uint16][]uds.DecodedValue, error) {
+	supported, err := obd.SupportedPIDs(ctx, e.client)
+	if err != nil {
+		return nil, err
+	}
+	definitions := uds.LookupDefinitions(seedkey.ECUTypeOBD)
+	var pids []byte
+	for _, responderPIDs := range supported {
+		for _, pid := range responderPIDs {
+			if !slices.Contains(pids, pid) {
+				pids = append(pids, pid)
+			}
+		}
+	}
+	slices.Sort(pids)
+	values := make(map[uint16][]uds.DecodedValue)
+	for _, pid := range pids {
+		records, err := obd.ReadPID(ctx, e.client, pid)
+		if err != nil {
+			return nil, fmt.Errorf("PID 0x%02X: %w", pid, err)
+		}
+		identifier, ok := definitions.Lookup(uds.SourceOBDPID, uint16(pid))
+		for responderID, record := range records {
+			if ok {
+				decoded, err := identifier.Decode(record)
+				if err == nil {
+					values[responderID] = append(values[responderID], decoded...)
+					continue
+				}
+			}
+			raw := uds.DecodedValue{Field: uds.Field{Name: fmt.Sprintf("PID 0x%02X", pid)}, Raw: record}
+			values[responderID] = append(values[responderID], raw)
+		}
+	}
+	return values, nil
+}
+
+// ReadECURom isn't possible over OBD-II.
+func (e *OBD) ReadECURom(ctx context.Context, path string) ([]byte, error) {
+	return nil, e.unsupported("read ROM")
+}
+
+// FlashECURom isn't possible over OBD-II.
+func (e *OBD) FlashECURom(ctx context.Context, path string) error {
+	return e.unsupported("flash ROM")
+}
+
+// ResetECU isn't possible over OBD-II.
+func (e *OBD) ResetECU(ctx context.Context, resetType byte) error {
+	return e.unsupported("reset ECU")
+}
+
+// Routines returns no routines, OBD-II doesn't define any that can be run on a bike.
+func (e *OBD) Routines() []uds.Routine {
+	return nil
+}
+
+func (e *OBD) RunRoutine(ctx context.Context, id uint16, parameters map[string]float64) (*uds.RoutineResult, error) {
+	return nil, e.unsupported("run routine")
+}
+
+// Actuators returns no actuators, OBD-II can't drive them.
+func (e *OBD) Actuators() []uds.Actuator {
+	return nil
+}
+
+func (e *OBD) RunActuatorTest(ctx context.Context, id uint16, parameters map[string]float64, duration time.Duration) (*uds.ActuatorTest, error) {
+	return nil, e.unsupported("test actuator")
+}
+
+// StopActuatorTests does nothing since no actuator tests can be running.
+func (e *OBD) StopActuatorTests() {}
+
+// WritableIdentifiers returns no identifiers, OBD-II data can't be written.
+func (e *OBD) WritableIdentifiers() []uds.Identifier {
+	return nil
+}
+
+func (e *OBD) WriteDataIdentifier(ctx context.Context, id uint16, record []byte) error {
+	return e.unsupported("write data identifier")
+}
+
+func (e *OBD) RollbackWrite(ctx context.Context, sequence int) error {
+	return e.unsupported("roll back write")
+}
+
+// unsupported logs and returns the error for an operation OBD-II can't perform.
+func (e *OBD) unsupported(operation string) error {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	err := fmt.Errorf("can't %s: %w", operation, errorUnsupportedOBD)
+	l.WriteLog(fmt.Sprintf("Error %v", err), logging.LogLevelError)
+	return err
+}
+
+// processAndBroadcastMessages reads complete messages and broadcasts them to subscribers.
+func (e *OBD) processAndBroadcastMessages(ctx context.Context) {
+	defer e.wg.Done()
+	processAndBroadcastMessages(ctx, e.messageBroadcaster)
+}
@@ -0,0 +1,176 @@
+package ecus
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// RomPartialFileSuffix is appended to the dump path while a ROM read is in progress
+	RomPartialFileSuffix = ".part"
+	// RomMetadataFileSuffix is appended to the dump path for the sidecar metadata record
+	RomMetadataFileSuffix = ".json"
+)
+
+// MemoryRegion is a contiguous block of ECU memory.
+type MemoryRegion struct {
+	Start uint32
+	Size  uint32
+}
+
+// RomMetadata is written alongside a ROM dump so the dump can be matched to the ECU it came from.
+type RomMetadata struct {
+	HardwareId   string    `json:"hardwareId"`
+	SoftwareId   string    `json:"softwareId"`
+	Manufacturer string    `json:"manufacturer"`
+	Model        string    `json:"model"`
+	VIN          string    `json:"vin"`
+	StartAddress uint32    `json:"startAddress"`
+	Size         uint32    `json:"size"`
+	SHA256       string    `json:"sha256,omitempty"`
+	Completed    time.Time `json:"completed,omitempty"`
+}
+
+func newRomMetadata(identification *ECUId, region MemoryRegion) RomMetadata {
+	return RomMetadata{
+		HardwareId:   identification.hardwareId,
+		SoftwareId:   identification.softwareId,
+		Manufacturer: identification.manufacturer,
+		Model:        identification.model,
+		VIN:          identification.vin,
+		StartAddress: region.Start,
+		Size:         region.Size,
+	}
+}
+
+// sameSource reports whether two metadata records describe the same ECU and memory region.
+func (m RomMetadata) sameSource(other RomMetadata) bool {
+	return m.HardwareId == other.HardwareId &&
+		m.SoftwareId == other.SoftwareId &&
+		m.VIN == other.VIN &&
+		m.StartAddress == other.StartAddress &&
+		m.Size == other.Size
+}
+
+func writeRomMetadata(path string, metadata RomMetadata) error {
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+RomMetadataFileSuffix, data, 0o644)
+}
+
+func readRomMetadata(path string) (RomMetadata, error) {
+	var metadata RomMetadata
+	data, err := os.ReadFile(path + RomMetadataFileSuffix)
+	if err != nil {
+		return metadata, err
+	}
+	err = json.Unmarshal(data, &metadata)
+	return metadata, err
+}
+
+// openPartialRom opens the partial dump for path. If an earlier partial dump from the same ECU exists the
+// whole blocks already read are returned so the read can resume, otherwise a new partial dump is started.
+func openPartialRom(path string, metadata RomMetadata, blockSize uint32) (*os.File, []byte, error) {
+	partialPath := path + RomPartialFileSuffix
+	existing, err := readRomMetadata(path)
+	if err == nil && existing.SHA256 == "" && existing.sameSource(metadata) {
+		file, err := os.OpenFile(partialPath, os.O_RDWR, 0o644)
+		if err == nil {
+			rom, err := io.ReadAll(file)
+			if err != nil {
+				_ = file.Close()
+				return nil, nil, err
+			}
+			// Drop any trailing partial block, it is read again
+			resumeLength := min(uint32(len(rom))/blockSize*blockSize, metadata.Size)
+			err = file.Truncate(int64(resumeLength))
+			if err == nil {
+				_, err = file.Seek(int64(resumeLength), io.SeekStart)
+			}
+			if err != nil {
+				_ = file.Close()
+				return nil, nil, err
+			}
+			return file, rom[:resumeLength], nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, err
+		}
+	}
+	// Write the metadata first so an interrupted dump can be matched when resuming
+	err = writeRomMetadata(path, metadata)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Create(partialPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, nil, nil
+}
+
+// finishRom writes the completed dump and its metadata and removes the partial dump.
+func finishRom(path string, metadata RomMetadata, rom []byte) error {
+	err := os.WriteFile(path, rom, 0o644)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(rom)
+	metadata.SHA256 = hex.EncodeToString(sum[:])
+	metadata.Completed = time.Now()
+	err = writeRomMetadata(path, metadata)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path + RomPartialFileSuffix)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// loadRomImage reads a ROM image and its metadata sidecar.
+func loadRomImage(path string) ([]byte, RomMetadata, error) {
+	metadata, err := readRomMetadata(path)
+	if err != nil {
+		return nil, metadata, fmt.Errorf("failed to read rom metadata: %w", err)
+	}
+	image, err := os.ReadFile(path)
+	if err != nil {
+		return nil, metadata, err
+	}
+	if uint32(len(image)) != metadata.Size {
+		return nil, metadata, fmt.Errorf("rom image is %d bytes but metadata expects %d", len(image), metadata.Size)
+	}
+	return image, metadata, nil
+}
+
+// romProgress logs progress of a long running memory transfer in steps.
+type romProgress struct {
+	total    uint32
+	nextStep uint32
+	write    func(message string)
+}
+
+const romProgressStepPercent = 5
+
+func newRomProgress(total uint32, write func(message string)) *romProgress {
+	return &romProgress{total: total, write: write}
+}
+
+func (p *romProgress) update(done uint32) {
+	percent := uint32(uint64(done) * 100 / uint64(p.total))
+	if percent < p.nextStep {
+		return
+	}
+	p.write(fmt.Sprintf("%d%% (%d/%d bytes)", percent, done, p.total))
+	p.nextStep = (percent/romProgressStepPercent + 1) * romProgressStepPercent
+}
@@ -5,6 +5,7 @@ go 1.23.2
 require (
 	fyne.io/fyne/v2 v2.5.1
 	go.bug.st/serial v1.6.2
+	golang.org/x/sys v0.30.0
 )
 
 require (
@@ -35,7 +36,6 @@ require (
 	golang.org/x/image v0.18.0 // indirect
 	golang.org/x/mobile v0.0.0-20231127183840-76ac6878050a // indirect
 	golang.org/x/net v0.36.0 // indirect
-	golang.org/x/sys v0.30.0 // indirect
 	golang.org/x/text v0.22.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -10,9 +10,12 @@ import (
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
 	"fyne.io/fyne/v2/widget"
 	"husk/drivers"
 	"husk/ecus"
+	"husk/livedata"
 	"husk/logging"
 	"husk/services"
 	"husk/uds"
@@ -40,6 +43,25 @@ const (
 	ecuLabelText                = "Select ECU"
 	readErrorsButtonText        = "Read Errors"
 	clearErrorsButtonText       = "Clear Errors"
+	readRomButtonText           = "Read ROM"
+	romDumpFileName             = "rom.bin"
+	flashRomButtonText          = "Flash ROM"
+	flashRomConfirmTitle        = "Flash ROM"
+	resetECUButtonText          = "Reset ECU"
+	routineSelectPlaceholder    = "Select routine"
+	runRoutineButtonText        = "Run Routine"
+	actuatorSelectPlaceholder   = "Select actuator"
+	testActuatorButtonText      = "Test Actuator"
+	stopActuatorsButtonText     = "Stop Tests"
+	readOBDDataButtonText       = "Read OBD Data"
+	recordLiveDataButtonText    = "Record Live Data"
+	identifierSelectPlaceholder = "Select identifier"
+	identifierValuePlaceholder  = "Value in hex..."
+	writeIdentifierButtonText   = "Write Identifier"
+	writeIdentifierConfirmTitle = "Write Identifier"
+	rollbackWriteButtonText     = "Roll Back Write"
+	rollbackWriteConfirmTitle   = "Roll Back Write"
+	stopRecordingButtonText     = "Stop Recording"
 )
 
 type GUI struct {
@@ -50,6 +72,12 @@ type GUI struct {
 	autoScrollLogs     bool
 	autoScrollMessages bool
 	driverName         string
+	ecuConnected       bool
+	// operationRunning is set while an operation using the diagnostic session runs, only one can run at a time
+	operationRunning bool
+	// streamer and recorder are set while live data is being recorded
+	streamer *livedata.Streamer
+	recorder *livedata.Recorder
 	// UI elements
 	driverScanButton       *widget.Button
 	driverSelect           *widget.Select
@@ -61,6 +89,20 @@ type GUI struct {
 	ecuDisconnectButton    *widget.Button
 	manualFrameEntry       *widget.Entry
 	sendManualFrameButton  *widget.Button
+	readRomButton          *widget.Button
+	flashRomButton         *widget.Button
+	resetECUButton         *widget.Button
+	routineSelect          *widget.Select
+	runRoutineButton       *widget.Button
+	identifierSelect       *widget.Select
+	identifierValueEntry   *widget.Entry
+	writeIdentifierButton  *widget.Button
+	rollbackWriteButton    *widget.Button
+	actuatorSelect         *widget.Select
+	testActuatorButton     *widget.Button
+	stopActuatorsButton    *widget.Button
+	readOBDDataButton      *widget.Button
+	recordLiveDataButton   *widget.Button
 	logContainer           *fyne.Container
 	logScrollContainer     *container.Scroll
 	messageContainer       *fyne.Container
@@ -86,6 +128,8 @@ func (g *GUI) Start(ctx context.Context) *GUI {
 	g.subToEvents()
 	g.isRunning = true
 	g.window.ShowAndRun()
+	// Finish the file of a recording still running when the window is closed
+	g.stopRecording()
 	return g
 }
 
@@ -199,8 +243,9 @@ func (g *GUI) createCommandContainer(ctx context.Context) *fyne.Container {
 	g.driverConnectButton = widget.NewButton(
 		driverConnectButtonText, func() { drivers.Connect(ctx, g.driverSelect.Selected) })
 	g.driverConnectButton.Disable()
+	// Disconnect the ECU first so it can be handed back control of any actuators
 	g.driverDisconnectButton = widget.NewButton(
-		driverDisconnectButtonText, func() { drivers.Disconnect(); ecus.Disconnect() })
+		driverDisconnectButtonText, func() { ecus.Disconnect(); drivers.Disconnect() })
 	g.driverDisconnectButton.Disable()
 	driverContainer := container.NewHBox(
 		driverLabel,
@@ -245,7 +290,100 @@ func (g *GUI) createCommandContainer(ctx context.Context) *fyne.Container {
 		e.ClearErrors(ctx)
 	})
 
-	miscCommands := container.NewHBox(readErrorsButton, clearErrorsButton)
+	g.readRomButton = widget.NewButton(readRomButtonText, func() {
+		e := services.Get(services.ServiceECU).(ecus.ECUProcessor)
+		g.runOperation(func() {
+			_, _ = e.ReadECURom(ctx, romDumpFileName)
+		})
+	})
+	g.readRomButton.Disable()
+
+	// Flashing overwrites the ECU, the file is picked and confirmed first
+	g.flashRomButton = widget.NewButton(flashRomButtonText, func() { g.flashRom(ctx) })
+	g.flashRomButton.Disable()
+
+	g.resetECUButton = widget.NewButton(resetECUButtonText, func() {
+		e := services.Get(services.ServiceECU).(ecus.ECUProcessor)
+		g.runOperation(func() {
+			_ = e.ResetECU(ctx, uds.SubfunctionHardReset)
+		})
+	})
+	g.resetECUButton.Disable()
+
+	// Routines are listed once an ECU is connected and run with their default parameters
+	g.routineSelect = widget.NewSelect(nil, func(_ string) {
+		g.updateOperationButtons()
+	})
+	g.routineSelect.PlaceHolder = routineSelectPlaceholder
+	g.routineSelect.Disable()
+	g.runRoutineButton = widget.NewButton(runRoutineButtonText, func() {
+		e := services.Get(services.ServiceECU).(ecus.ECUProcessor)
+		for _, routine := range e.Routines() {
+			if routine.Name == g.routineSelect.Selected {
+				g.runOperation(func() {
+					_, _ = e.RunRoutine(ctx, routine.ID, nil)
+				})
+			}
+		}
+	})
+	g.runRoutineButton.Disable()
+
+	// Writable identifiers are written with a raw record, every write is journalled so it can be rolled back
+	g.identifierSelect = widget.NewSelect(nil, func(_ string) {
+		g.updateOperationButtons()
+	})
+	g.identifierSelect.PlaceHolder = identifierSelectPlaceholder
+	g.identifierSelect.Disable()
+	g.identifierValueEntry = widget.NewEntry()
+	g.identifierValueEntry.SetPlaceHolder(identifierValuePlaceholder)
+	g.identifierValueEntry.Disable()
+	g.writeIdentifierButton = widget.NewButton(writeIdentifierButtonText, func() { g.writeIdentifier(ctx) })
+	g.writeIdentifierButton.Disable()
+	g.rollbackWriteButton = widget.NewButton(rollbackWriteButtonText, func() { g.rollbackWrite(ctx) })
+	g.rollbackWriteButton.Disable()
+
+	// Actuators are tested with their default parameters for their maximum duration unless stopped
+	g.actuatorSelect = widget.NewSelect(nil, func(_ string) {
+		g.updateOperationButtons()
+	})
+	g.actuatorSelect.PlaceHolder = actuatorSelectPlaceholder
+	g.actuatorSelect.Disable()
+	g.testActuatorButton = widget.NewButton(testActuatorButtonText, func() {
+		e := services.Get(services.ServiceECU).(ecus.ECUProcessor)
+		for _, actuator := range e.Actuators() {
+			if actuator.Name == g.actuatorSelect.Selected {
+				// The test runs until it is stopped or times out, other operations wait for it
+				g.runOperation(func() {
+					test, err := e.RunActuatorTest(ctx, actuator.ID, nil, 0)
+					if err == nil {
+						<-test.Done()
+					}
+				})
+			}
+		}
+	})
+	g.testActuatorButton.Disable()
+	g.stopActuatorsButton = widget.NewButton(stopActuatorsButtonText, func() {
+		go ecus.StopActuatorTests()
+	})
+	g.stopActuatorsButton.Disable()
+
+	// Current data and vehicle information are only read from OBD-II ECUs
+	g.readOBDDataButton = widget.NewButton(readOBDDataButtonText, func() {
+		if e, ok := services.Get(services.ServiceECU).(*ecus.OBD); ok {
+			go e.ReadData(ctx)
+		}
+	})
+	g.readOBDDataButton.Disable()
+
+	// Every live data channel is recorded to CSV in the working directory until stopped
+	g.recordLiveDataButton = widget.NewButton(recordLiveDataButtonText, func() { g.toggleRecording(ctx) })
+	g.recordLiveDataButton.Disable()
+
+	miscCommands := container.NewHBox(readErrorsButton, clearErrorsButton, g.readRomButton, g.flashRomButton, g.resetECUButton,
+		g.routineSelect, g.runRoutineButton, g.identifierSelect, g.identifierValueEntry, g.writeIdentifierButton,
+		g.rollbackWriteButton, g.actuatorSelect, g.testActuatorButton, g.stopActuatorsButton,
+		g.readOBDDataButton, g.recordLiveDataButton)
 
 	commandContainer := container.NewBorder(
 		nil,
@@ -376,6 +514,148 @@ func (g *GUI) sendManualFrame(ctx context.Context) {
 	}
 }
 
+// flashRom asks for the file to flash and a confirmation before flashing it to the connected ECU.
+func (g *GUI) flashRom(ctx context.Context) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	e := services.Get(services.ServiceECU).(ecus.ECUProcessor)
+	fileDialog := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+		if err != nil {
+			l.WriteLog(fmt.Sprintf("Error opening flash file: %s", err.Error()), logging.LogLevelError)
+			return
+		}
+		if reader == nil {
+			// Cancelled
+			return
+		}
+		path := reader.URI().Path()
+		_ = reader.Close()
+		message := fmt.Sprintf("Flash %s to %s?\nDon't switch off the ignition until flashing has finished.", path, e.String())
+		dialog.ShowConfirm(flashRomConfirmTitle, message, func(confirmed bool) {
+			if !confirmed {
+				return
+			}
+			g.runOperation(func() {
+				_ = e.FlashECURom(ctx, path)
+			})
+		}, g.window)
+	}, g.window)
+	fileDialog.SetFilter(storage.NewExtensionFileFilter([]string{".bin"}))
+	fileDialog.Show()
+}
+
+// writeIdentifier writes the value entered in hex to the selected identifier once confirmed.
+func (g *GUI) writeIdentifier(ctx context.Context) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	e := services.Get(services.ServiceECU).(ecus.ECUProcessor)
+	for _, identifier := range e.WritableIdentifiers() {
+		if identifier.Name != g.identifierSelect.Selected {
+			continue
+		}
+		record, err := utils.HexStringToByteArray(strings.ReplaceAll(g.identifierValueEntry.Text, " ", ""))
+		if err != nil {
+			l.WriteLog(fmt.Sprintf("Error parsing identifier value: %s", err.Error()), logging.LogLevelError)
+			return
+		}
+		message := fmt.Sprintf("Write 0x%X to %s on %s?", record, identifier.Name, e.String())
+		dialog.ShowConfirm(writeIdentifierConfirmTitle, message, func(confirmed bool) {
+			if !confirmed {
+				return
+			}
+			g.runOperation(func() {
+				_ = e.WriteDataIdentifier(ctx, identifier.ID, record)
+			})
+		}, g.window)
+		return
+	}
+}
+
+// rollbackWrite restores the value from before the latest write to the connected ECU once confirmed.
+func (g *GUI) rollbackWrite(ctx context.Context) {
+	e := services.Get(services.ServiceECU).(ecus.ECUProcessor)
+	message := fmt.Sprintf("Restore the value from before the latest write to %s?", e.String())
+	dialog.ShowConfirm(rollbackWriteConfirmTitle, message, func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+		g.runOperation(func() {
+			_ = e.RollbackWrite(ctx, 0)
+		})
+	}, g.window)
+}
+
+// toggleRecording starts streaming and recording every live data channel of the connected ECU, or stops the
+// recording in progress.
+func (g *GUI) toggleRecording(ctx context.Context) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	if g.recorder != nil {
+		g.stopRecording()
+		return
+	}
+	e, ok := services.Get(services.ServiceECU).(*ecus.K01)
+	if !ok {
+		return
+	}
+	streamer := e.StreamLiveData(ctx, nil)
+	recorder := livedata.NewRecorder(streamer, streamer.Channels(), livedata.RecorderConfig{
+		Trigger: livedata.ManualTrigger(),
+	}).Start(ctx)
+	err := recorder.StartRecording()
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error starting live data recording: %v", err), logging.LogLevelError)
+		recorder.Stop()
+		streamer.Stop()
+		return
+	}
+	g.streamer, g.recorder = streamer, recorder
+	g.recordLiveDataButton.SetText(stopRecordingButtonText)
+}
+
+// stopRecording stops the live data recording, if there is one.
+func (g *GUI) stopRecording() {
+	if g.recorder == nil {
+		return
+	}
+	g.recorder.Stop()
+	g.streamer.Stop()
+	g.streamer, g.recorder = nil, nil
+	g.recordLiveDataButton.SetText(recordLiveDataButtonText)
+}
+
+// runOperation runs an operation using the diagnostic session without blocking the UI. The operation buttons are
+// disabled until it finishes so operations can't overlap.
+func (g *GUI) runOperation(operation func()) {
+	g.operationRunning = true
+	g.updateOperationButtons()
+	go func() {
+		defer func() {
+			g.operationRunning = false
+			g.updateOperationButtons()
+		}()
+		operation()
+	}()
+}
+
+// updateOperationButtons enables the operation buttons while an ECU is connected and no operation is running.
+func (g *GUI) updateOperationButtons() {
+	enabled := g.ecuConnected && !g.operationRunning
+	setEnabled(g.readRomButton, enabled)
+	setEnabled(g.flashRomButton, enabled)
+	setEnabled(g.resetECUButton, enabled)
+	setEnabled(g.runRoutineButton, enabled && g.routineSelect.Selected != "")
+	setEnabled(g.writeIdentifierButton, enabled && g.identifierSelect.Selected != "")
+	setEnabled(g.rollbackWriteButton, enabled && len(g.identifierSelect.Options) > 0)
+	setEnabled(g.testActuatorButton, enabled && g.actuatorSelect.Selected != "")
+}
+
+// setEnabled enables or disables a widget.
+func setEnabled(w fyne.Disableable, enabled bool) {
+	if enabled {
+		w.Enable()
+		return
+	}
+	w.Disable()
+}
+
 func (g *GUI) onDriversScan(availableDriverNames []string) {
 	g.driverSelect.SetOptions(availableDriverNames)
 	g.driverSelect.Selected = ""
@@ -421,6 +701,44 @@ func (g *GUI) onECUConnected() {
 	g.ecuDisconnectButton.Enable()
 	g.manualFrameEntry.Enable()
 	g.sendManualFrameButton.Enable()
+	g.ecuConnected = true
+	e := services.Get(services.ServiceECU).(ecus.ECUProcessor)
+	var routineNames []string
+	for _, routine := range e.Routines() {
+		routineNames = append(routineNames, routine.Name)
+	}
+	g.routineSelect.SetOptions(routineNames)
+	g.routineSelect.Selected = ""
+	if len(routineNames) > 0 {
+		g.routineSelect.Enable()
+	}
+	var identifierNames []string
+	for _, identifier := range e.WritableIdentifiers() {
+		identifierNames = append(identifierNames, identifier.Name)
+	}
+	g.identifierSelect.SetOptions(identifierNames)
+	g.identifierSelect.Selected = ""
+	if len(identifierNames) > 0 {
+		g.identifierSelect.Enable()
+		g.identifierValueEntry.Enable()
+	}
+	var actuatorNames []string
+	for _, actuator := range e.Actuators() {
+		actuatorNames = append(actuatorNames, actuator.Name)
+	}
+	g.actuatorSelect.SetOptions(actuatorNames)
+	g.actuatorSelect.Selected = ""
+	if len(actuatorNames) > 0 {
+		g.actuatorSelect.Enable()
+		g.stopActuatorsButton.Enable()
+	}
+	if _, ok := e.(*ecus.OBD); ok {
+		g.readOBDDataButton.Enable()
+	}
+	if _, ok := e.(*ecus.K01); ok {
+		g.recordLiveDataButton.Enable()
+	}
+	g.updateOperationButtons()
 }
 
 func (g *GUI) onECUDisconnected() {
@@ -430,4 +748,14 @@ func (g *GUI) onECUDisconnected() {
 	g.ecuDisconnectButton.Disable()
 	g.manualFrameEntry.Disable()
 	g.sendManualFrameButton.Disable()
+	g.ecuConnected = false
+	g.updateOperationButtons()
+	g.routineSelect.Disable()
+	g.identifierSelect.Disable()
+	g.identifierValueEntry.Disable()
+	g.actuatorSelect.Disable()
+	g.stopActuatorsButton.Disable()
+	g.readOBDDataButton.Disable()
+	g.stopRecording()
+	g.recordLiveDataButton.Disable()
 }
@@ -0,0 +1,57 @@
+package kwp
+
+import (
+	"context"
+	"fmt"
+
+	"husk/uds"
+)
+
+// NewReadEcuIdentificationRequest creates a ReadEcuIdentification request.
+func NewReadEcuIdentificationRequest(senderID uint16, option byte) *uds.Message {
+	return &uds.Message{
+		SenderID:    senderID,
+		ServiceID:   ServiceReadEcuIdentification,
+		Subfunction: &option,
+		Protocol:    Protocol,
+	}
+}
+
+// ReadEcuIdentification returns the record of an identification option.
+func ReadEcuIdentification(ctx context.Context, r uds.Requester, senderID uint16, option byte) ([]byte, error) {
+	resp, err := r.Request(ctx, NewReadEcuIdentificationRequest(senderID, option))
+	if err != nil {
+		return nil, err
+	}
+	// Data holds the echoed identification option followed by the value
+	if len(resp.Data) < 1 || resp.Data[0] != option {
+		return nil, fmt.Errorf("unexpected identification option in read ecu identification response")
+	}
+	return resp.Data[1:], nil
+}
+
+// NewReadDataByLocalIdentifierRequest creates a ReadDataByLocalIdentifier request.
+func NewReadDataByLocalIdentifierRequest(senderID uint16, localID byte) *uds.Message {
+	return &uds.Message{
+		SenderID:  senderID,
+		ServiceID: ServiceReadDataByLocalIdentifier,
+		Data:      []byte{localID},
+		Protocol:  Protocol,
+	}
+}
+
+// ReadDataByLocalIdentifier returns the record of a local identifier.
+func ReadDataByLocalIdentifier(ctx context.Context, r uds.Requester, senderID uint16, localID byte) ([]byte, error) {
+	resp, err := r.Request(ctx, NewReadDataByLocalIdentifierRequest(senderID, localID))
+	if err != nil {
+		return nil, err
+	}
+	// Data holds the echoed local identifier followed by the record
+	if len(resp.Data) < 1 {
+		return nil, fmt.Errorf("read data by local identifier response too short")
+	}
+	if resp.Data[0] != localID {
+		return nil, fmt.Errorf("response is for local identifier 0x%02X not 0x%02X", resp.Data[0], localID)
+	}
+	return resp.Data[1:], nil
+}
@@ -0,0 +1,35 @@
+package kwp
+
+// Manufacturer specific services of the K01
+
+const (
+	// ServiceReadErrorsK01 returns the stored DTCs, 2 bytes each, without a status
+	ServiceReadErrorsK01 byte = 0x03
+	// ServiceClearErrorsK01 clears every stored DTC
+	ServiceClearErrorsK01 byte = 0x04
+)
+
+// ReadEcuIdentification options
+
+const (
+	IdentificationOptionVINK01           byte = 0x01
+	IdentificationOptionECUHardwareIdK01 byte = 0x02
+	IdentificationOptionECUSoftwareIdK01 byte = 0x05
+	IdentificationOptionCountryK01       byte = 0x06
+	IdentificationOptionManufacturerK01  byte = 0x07
+	IdentificationOptionModelK01         byte = 0x08
+)
+
+// Local Identifiers
+
+const (
+	// LocalIdentifierEngineDataK01 holds engine speed (2 bytes), coolant temperature (1 byte),
+	// throttle position (1 byte) and battery voltage (2 bytes) using the same scaling as the data identifiers
+	LocalIdentifierEngineDataK01 byte = 0x01
+)
+
+// ProtocolK01 is KWP2000 with the manufacturer specific services of the K01.
+var ProtocolK01 = Protocol.Extend(map[byte]string{
+	ServiceReadErrorsK01:  "Read Errors",
+	ServiceClearErrorsK01: "Clear Errors",
+})
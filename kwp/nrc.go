@@ -0,0 +1,77 @@
+package kwp
+
+import (
+	"husk/uds"
+)
+
+// KWP2000 Negative Response Codes. Codes shared with UDS, such as the response pending and busy codes the
+// client handles, have the same values.
+const (
+	NRCGeneralReject                           byte = 0x10
+	NRCServiceNotSupported                     byte = 0x11
+	NRCSubFunctionNotSupportedInvalidFormat    byte = 0x12
+	NRCBusyRepeatRequest                       byte = 0x21
+	NRCConditionsNotCorrectOrSequenceError     byte = 0x22
+	NRCRoutineNotComplete                      byte = 0x23
+	NRCRequestOutOfRange                       byte = 0x31
+	NRCSecurityAccessDenied                    byte = 0x33
+	NRCInvalidKey                              byte = 0x35
+	NRCExceedNumberOfAttempts                  byte = 0x36
+	NRCRequiredTimeDelayNotExpired             byte = 0x37
+	NRCDownloadNotAccepted                     byte = 0x40
+	NRCImproperDownloadType                    byte = 0x41
+	NRCCantDownloadToSpecifiedAddress          byte = 0x42
+	NRCCantDownloadNumberOfBytesRequested      byte = 0x43
+	NRCUploadNotAccepted                       byte = 0x50
+	NRCImproperUploadType                      byte = 0x51
+	NRCCantUploadFromSpecifiedAddress          byte = 0x52
+	NRCCantUploadNumberOfBytesRequested        byte = 0x53
+	NRCTransferSuspended                       byte = 0x71
+	NRCTransferAborted                         byte = 0x72
+	NRCIllegalAddressInBlockTransfer           byte = 0x74
+	NRCIllegalByteCountInBlockTransfer         byte = 0x75
+	NRCIllegalBlockTransferType                byte = 0x76
+	NRCBlockTransferDataChecksumError          byte = 0x77
+	NRCRequestCorrectlyReceivedResponsePending byte = 0x78
+	NRCIncorrectByteCountDuringBlockTransfer   byte = 0x79
+	NRCServiceNotSupportedInActiveSession      byte = 0x80
+)
+
+// Map of KWP2000 NRCs to their names.
+var nrcNames = map[byte]string{
+	NRCGeneralReject:                           "General Reject",
+	NRCServiceNotSupported:                     "Service Not Supported",
+	NRCSubFunctionNotSupportedInvalidFormat:    "Sub-Function Not Supported or Invalid Format",
+	NRCBusyRepeatRequest:                       "Busy Repeat Request",
+	NRCConditionsNotCorrectOrSequenceError:     "Conditions Not Correct or Request Sequence Error",
+	NRCRoutineNotComplete:                      "Routine Not Complete",
+	NRCRequestOutOfRange:                       "Request Out of Range",
+	NRCSecurityAccessDenied:                    "Security Access Denied",
+	NRCInvalidKey:                              "Invalid Key",
+	NRCExceedNumberOfAttempts:                  "Exceed Number of Attempts",
+	NRCRequiredTimeDelayNotExpired:             "Required Time Delay Not Expired",
+	NRCDownloadNotAccepted:                     "Download Not Accepted",
+	NRCImproperDownloadType:                    "Improper Download Type",
+	NRCCantDownloadToSpecifiedAddress:          "Can't Download to Specified Address",
+	NRCCantDownloadNumberOfBytesRequested:      "Can't Download Number of Bytes Requested",
+	NRCUploadNotAccepted:                       "Upload Not Accepted",
+	NRCImproperUploadType:                      "Improper Upload Type",
+	NRCCantUploadFromSpecifiedAddress:          "Can't Upload from Specified Address",
+	NRCCantUploadNumberOfBytesRequested:        "Can't Upload Number of Bytes Requested",
+	NRCTransferSuspended:                       "Transfer Suspended",
+	NRCTransferAborted:                         "Transfer Aborted",
+	NRCIllegalAddressInBlockTransfer:           "Illegal Address in Block Transfer",
+	NRCIllegalByteCountInBlockTransfer:         "Illegal Byte Count in Block Transfer",
+	NRCIllegalBlockTransferType:                "Illegal Block Transfer Type",
+	NRCBlockTransferDataChecksumError:          "Block Transfer Data Checksum Error",
+	NRCRequestCorrectlyReceivedResponsePending: "Request Correctly Received - Response Pending",
+	NRCIncorrectByteCountDuringBlockTransfer:   "Incorrect Byte Count During Block Transfer",
+	NRCServiceNotSupportedInActiveSession:      "Service Not Supported in Active Diagnostic Session",
+}
+
+// Common NRCs for use with errors.Is, uds.NRCError matches on the NRC value alone
+var (
+	ErrNRCSubFunctionNotSupportedInvalidFormat = &uds.NRCError{NRC: NRCSubFunctionNotSupportedInvalidFormat}
+	ErrNRCConditionsNotCorrectOrSequenceError  = &uds.NRCError{NRC: NRCConditionsNotCorrectOrSequenceError}
+	ErrNRCServiceNotSupportedInActiveSession   = &uds.NRCError{NRC: NRCServiceNotSupportedInActiveSession}
+)
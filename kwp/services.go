@@ -0,0 +1,112 @@
+package kwp
+
+import (
+	"husk/uds"
+)
+
+// KWP2000 Service ID constants
+const (
+	ServiceStartDiagnosticSession               byte = 0x10
+	ServiceECUReset                             byte = 0x11
+	ServiceReadFreezeFrameData                  byte = 0x12
+	ServiceReadDiagnosticTroubleCodes           byte = 0x13
+	ServiceClearDiagnosticInformation           byte = 0x14
+	ServiceReadStatusOfDiagnosticTroubleCodes   byte = 0x17
+	ServiceReadDiagnosticTroubleCodesByStatus   byte = 0x18
+	ServiceReadEcuIdentification                byte = 0x1A
+	ServiceStopDiagnosticSession                byte = 0x20
+	ServiceReadDataByLocalIdentifier            byte = 0x21
+	ServiceReadDataByCommonIdentifier           byte = 0x22
+	ServiceReadMemoryByAddress                  byte = 0x23
+	ServiceSecurityAccess                       byte = 0x27
+	ServiceDisableNormalMessageTransmission     byte = 0x28
+	ServiceEnableNormalMessageTransmission      byte = 0x29
+	ServiceWriteDataByCommonIdentifier          byte = 0x2E
+	ServiceInputOutputControlByCommonIdentifier byte = 0x2F
+	ServiceInputOutputControlByLocalIdentifier  byte = 0x30
+	ServiceStartRoutineByLocalIdentifier        byte = 0x31
+	ServiceStopRoutineByLocalIdentifier         byte = 0x32
+	ServiceRequestRoutineResultsByLocalID       byte = 0x33
+	ServiceRequestDownload                      byte = 0x34
+	ServiceRequestUpload                        byte = 0x35
+	ServiceTransferData                         byte = 0x36
+	ServiceRequestTransferExit                  byte = 0x37
+	ServiceWriteDataByLocalIdentifier           byte = 0x3B
+	ServiceWriteMemoryByAddress                 byte = 0x3D
+	ServiceTesterPresent                        byte = 0x3E
+	ServiceControlDTCSetting                    byte = 0x85
+)
+
+// Map of KWP2000 service IDs to their names.
+var serviceIDNames = map[byte]string{
+	ServiceStartDiagnosticSession:               "Start Diagnostic Session",
+	ServiceECUReset:                             "ECU Reset",
+	ServiceReadFreezeFrameData:                  "Read Freeze Frame Data",
+	ServiceReadDiagnosticTroubleCodes:           "Read Diagnostic Trouble Codes",
+	ServiceClearDiagnosticInformation:           "Clear Diagnostic Information",
+	ServiceReadStatusOfDiagnosticTroubleCodes:   "Read Status Of Diagnostic Trouble Codes",
+	ServiceReadDiagnosticTroubleCodesByStatus:   "Read Diagnostic Trouble Codes By Status",
+	ServiceReadEcuIdentification:                "Read ECU Identification",
+	ServiceStopDiagnosticSession:                "Stop Diagnostic Session",
+	ServiceReadDataByLocalIdentifier:            "Read Data By Local Identifier",
+	ServiceReadDataByCommonIdentifier:           "Read Data By Common Identifier",
+	ServiceReadMemoryByAddress:                  "Read Memory By Address",
+	ServiceSecurityAccess:                       "Security Access",
+	ServiceDisableNormalMessageTransmission:     "Disable Normal Message Transmission",
+	ServiceEnableNormalMessageTransmission:      "Enable Normal Message Transmission",
+	ServiceWriteDataByCommonIdentifier:          "Write Data By Common Identifier",
+	ServiceInputOutputControlByCommonIdentifier: "Input Output Control By Common Identifier",
+	ServiceInputOutputControlByLocalIdentifier:  "Input Output Control By Local Identifier",
+	ServiceStartRoutineByLocalIdentifier:        "Start Routine By Local Identifier",
+	ServiceStopRoutineByLocalIdentifier:         "Stop Routine By Local Identifier",
+	ServiceRequestRoutineResultsByLocalID:       "Request Routine Results By Local Identifier",
+	ServiceRequestDownload:                      "Request Download",
+	ServiceRequestUpload:                        "Request Upload",
+	ServiceTransferData:                         "Transfer Data",
+	ServiceRequestTransferExit:                  "Request Transfer Exit",
+	ServiceWriteDataByLocalIdentifier:           "Write Data By Local Identifier",
+	ServiceWriteMemoryByAddress:                 "Write Memory By Address",
+	ServiceTesterPresent:                        "Tester Present",
+	ServiceControlDTCSetting:                    "Control DTC Setting",
+}
+
+// KWP2000 diagnostic sessions for StartDiagnosticSession
+const (
+	SessionStandard    byte = 0x81
+	SessionProgramming byte = 0x85
+	SessionDevelopment byte = 0x86
+	SessionAdjustment  byte = 0x87
+)
+
+// KWP2000 access modes for SecurityAccess, higher levels use the following odd and even pairs
+const (
+	AccessModeRequestSeed byte = 0x01
+	AccessModeSendKey     byte = 0x02
+)
+
+// Map of KWP2000 subfunctions (for specific service IDs) to their names.
+var subfunctionNames = map[byte]map[byte]string{
+	ServiceStartDiagnosticSession: {
+		SessionStandard:    "Standard Session",
+		SessionProgramming: "Programming Session",
+		SessionDevelopment: "Development Session",
+		SessionAdjustment:  "Adjustment Session",
+	},
+	ServiceSecurityAccess: {
+		AccessModeRequestSeed: "Request Seed",
+		AccessModeSendKey:     "Send Key",
+	},
+}
+
+// Protocol is KWP2000 on CAN (ISO 14230-3 services over ISO 15765-2).
+var Protocol = &uds.Protocol{
+	Name:         "KWP2000",
+	Services:     serviceIDNames,
+	Subfunctions: subfunctionNames,
+	NRCs:         nrcNames,
+	IdentifierServices: map[byte]uds.IdentifierSource{
+		ServiceReadEcuIdentification:      uds.SourceECUIdentification,
+		ServiceReadDataByLocalIdentifier:  uds.SourceLocalIdentifier,
+		ServiceReadDataByCommonIdentifier: uds.SourceDataIdentifier,
+	},
+}
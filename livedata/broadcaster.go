@@ -0,0 +1,64 @@
+package livedata
+
+import (
+	"sync"
+
+	"husk/logging"
+	"husk/services"
+)
+
+// SampleBroadcaster broadcasts live data samples
+type SampleBroadcaster struct {
+	subscribers map[chan *Sample]struct{}
+	lock        sync.RWMutex
+}
+
+// NewSampleBroadcaster creates a new SampleBroadcaster.
+func NewSampleBroadcaster() *SampleBroadcaster {
+	return &SampleBroadcaster{
+		subscribers: make(map[chan *Sample]struct{}),
+	}
+}
+
+// Subscribe adds a new subscriber and returns a channel to receive samples.
+func (b *SampleBroadcaster) Subscribe() chan *Sample {
+	ch := make(chan *Sample, 128)
+	b.lock.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.lock.Unlock()
+	return ch
+}
+
+// Unsubscribe removes a subscriber.
+func (b *SampleBroadcaster) Unsubscribe(ch chan *Sample) {
+	b.lock.Lock()
+	// The channel has already been closed if the broadcaster was cleaned up
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+	b.lock.Unlock()
+}
+
+// Broadcast sends a sample to all subscribers.
+func (b *SampleBroadcaster) Broadcast(sample *Sample) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- sample:
+		default:
+			l.WriteLog("Slow subscriber, sample channel is full. Dropping sample.", logging.LogLevelWarning)
+		}
+	}
+}
+
+func (b *SampleBroadcaster) Cleanup() {
+	b.lock.Lock()
+	for channel := range b.subscribers {
+		delete(b.subscribers, channel)
+		close(channel)
+	}
+	b.lock.Unlock()
+}
@@ -0,0 +1,42 @@
+package livedata
+
+import (
+	"time"
+
+	"husk/uds"
+)
+
+// Channel is a value streamed from the ECU, decoded by the embedded field.
+type Channel struct {
+	uds.Field
+	Source uds.IdentifierSource
+	// ID is the data identifier or local identifier the field is read from
+	ID uint16
+}
+
+// Sample is a decoded channel value along with when it was read.
+type Sample struct {
+	Channel Channel
+	Value   float64
+	// Text is the name of an enum value
+	Text string
+	Time time.Time
+}
+
+// String returns the channel name, value and unit, e.g. "Engine Speed: 4250 rpm".
+func (s *Sample) String() string {
+	return uds.DecodedValue{Field: s.Channel.Field, Value: s.Value, Text: s.Text}.String()
+}
+
+// Channels returns a channel for each numeric field of the identifiers definitions reads from source.
+func Channels(definitions *uds.Definitions, source uds.IdentifierSource) []Channel {
+	var channels []Channel
+	for _, identifier := range definitions.BySource(source) {
+		for _, field := range identifier.Fields {
+			if field.IsNumeric() {
+				channels = append(channels, Channel{Field: field, Source: source, ID: identifier.ID})
+			}
+		}
+	}
+	return channels
+}
@@ -0,0 +1,74 @@
+package livedata
+
+import (
+	"encoding/csv"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// csvWriter writes a timestamp, the seconds since the file started and a column per channel.
+type csvWriter struct {
+	file   *os.File
+	writer *csv.Writer
+	start  time.Time
+	size   *countingWriter
+}
+
+func newCSVWriter(file *os.File, channels []Channel, start time.Time) (*csvWriter, error) {
+	size := &countingWriter{writer: file}
+	w := &csvWriter{file: file, writer: csv.NewWriter(size), start: start, size: size}
+	header := []string{"Timestamp", "Time (s)"}
+	for _, channel := range channels {
+		name := channel.Name
+		if channel.Unit != "" {
+			name += " (" + channel.Unit + ")"
+		}
+		header = append(header, name)
+	}
+	return w, w.writer.Write(header)
+}
+
+func (w *csvWriter) WriteRow(t time.Time, values []float64) error {
+	row := []string{
+		t.UTC().Format("2006-01-02T15:04:05.000Z"),
+		strconv.FormatFloat(t.Sub(w.start).Seconds(), 'f', 3, 64),
+	}
+	for _, value := range values {
+		// Channels that haven't been read yet are left empty
+		if math.IsNaN(value) {
+			row = append(row, "")
+			continue
+		}
+		row = append(row, strconv.FormatFloat(value, 'f', -1, 64))
+	}
+	return w.writer.Write(row)
+}
+
+// Size returns the bytes flushed to the file, the csv writer buffers up to 4KB.
+func (w *csvWriter) Size() int64 {
+	return w.size.count
+}
+
+func (w *csvWriter) Close() error {
+	w.writer.Flush()
+	err := w.writer.Error()
+	closeErr := w.file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// countingWriter counts the bytes written to a file.
+type countingWriter struct {
+	writer *os.File
+	count  int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.writer.Write(p)
+	c.count += int64(n)
+	return n, err
+}
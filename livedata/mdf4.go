@@ -0,0 +1,252 @@
+package livedata
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"runtime/debug"
+	"time"
+)
+
+// MDF 4.10 constants, see ASAM MDF 4.1.1
+const (
+	mdfIDBlockSize      = 64
+	mdfBlockHeaderSize  = 24
+	mdfVersion          = 410
+	mdfChannelTypeValue = 0
+	mdfChannelMaster    = 2
+	mdfSyncTypeNone     = 0
+	mdfSyncTypeTime     = 1
+	// mdfDataTypeFloatLE is an IEEE 754 little endian float
+	mdfDataTypeFloatLE = 4
+	// mdfChannelFlagPrecisionValid marks cn_precision as the number of decimal places to show
+	mdfChannelFlagPrecisionValid = 0x04
+	// mdfUnfinishedFlags mark the cycle count and DT block length as not yet written, so an
+	// interrupted recording can still be recovered by analysis tools
+	mdfUnfinishedFlags = 0x01 | 0x04
+	// mdfNoLink is a nil link
+	mdfNoLink = -1
+	// mdfDTLink links to the DT block written after the metadata
+	mdfDTLink = -2
+)
+
+// mdfBlock is a block before its links have been resolved to file offsets.
+type mdfBlock struct {
+	id string
+	// links are indexes of other blocks, mdfNoLink or mdfDTLink
+	links []int
+	data  []byte
+}
+
+func (b mdfBlock) size() int {
+	return mdfBlockHeaderSize + 8*len(b.links) + len(b.data)
+}
+
+// mdf4Writer writes a single channel group of little endian doubles, the first channel being the time in seconds.
+// The metadata is written when the file is created, records are appended to the DT block and the cycle count
+// and DT block length are updated when the file is closed.
+type mdf4Writer struct {
+	file        *os.File
+	buffer      *bufio.Writer
+	size        *countingWriter
+	start       time.Time
+	records     uint64
+	cycleOffset int64
+	dtOffset    int64
+	record      []byte
+}
+
+func newMDF4Writer(file *os.File, channels []Channel, start time.Time) (*mdf4Writer, error) {
+	w := &mdf4Writer{file: file, start: start, record: make([]byte, 8*(len(channels)+1))}
+	w.size = &countingWriter{writer: file}
+	w.buffer = bufio.NewWriter(w.size)
+
+	blocks := []mdfBlock{
+		// Filled in once the other blocks have been added
+		{id: "##HD"},
+	}
+	add := func(block mdfBlock) int {
+		blocks = append(blocks, block)
+		return len(blocks) - 1
+	}
+	addText := func(text string) int {
+		return add(mdfBlock{id: "##TX", data: mdfText(text)})
+	}
+
+	comment := add(mdfBlock{id: "##MD", data: mdfText(fmt.Sprintf(
+		"<FHcomment><TX>Live data recorded by husk</TX><tool_id>husk</tool_id><tool_vendor>husk</tool_vendor><tool_version>%s</tool_version></FHcomment>",
+		toolVersion()))})
+	fileHistory := add(mdfBlock{id: "##FH", links: []int{mdfNoLink, comment}, data: mdfTimeData(start, 16)})
+	dataGroup := add(mdfBlock{id: "##DG"})
+	channelGroup := add(mdfBlock{id: "##CG"})
+
+	// The master channel comes first followed by one channel per live data channel
+	type channelInfo struct {
+		name, unit        string
+		channelType, sync byte
+		precision         int
+		hasPrecision      bool
+	}
+	infos := []channelInfo{{name: "Time", unit: "s", channelType: mdfChannelMaster, sync: mdfSyncTypeTime}}
+	for _, channel := range channels {
+		infos = append(infos, channelInfo{name: channel.Name, unit: channel.Unit, channelType: mdfChannelTypeValue,
+			sync: mdfSyncTypeNone, precision: channel.Decimals, hasPrecision: true})
+	}
+	channelBlocks := make([]int, len(infos))
+	for i, info := range infos {
+		name := addText(info.name)
+		unit := mdfNoLink
+		if info.unit != "" {
+			unit = addText(info.unit)
+		}
+		data := make([]byte, 72)
+		data[0] = info.channelType
+		data[1] = info.sync
+		data[2] = mdfDataTypeFloatLE
+		binary.LittleEndian.PutUint32(data[4:8], uint32(8*i))
+		binary.LittleEndian.PutUint32(data[8:12], 64)
+		if info.hasPrecision {
+			binary.LittleEndian.PutUint32(data[12:16], mdfChannelFlagPrecisionValid)
+			data[20] = byte(min(info.precision, 0xFF))
+		}
+		// The next channel link is set below
+		channelBlocks[i] = add(mdfBlock{id: "##CN", links: []int{mdfNoLink, mdfNoLink, name, mdfNoLink, mdfNoLink, mdfNoLink, unit, mdfNoLink}, data: data})
+	}
+	for i := 0; i+1 < len(channelBlocks); i++ {
+		blocks[channelBlocks[i]].links[0] = channelBlocks[i+1]
+	}
+
+	groupData := make([]byte, 32)
+	// The cycle count at groupData[8:16] is written on close
+	binary.LittleEndian.PutUint32(groupData[24:28], uint32(len(w.record)))
+	blocks[channelGroup] = mdfBlock{id: "##CG", links: []int{mdfNoLink, channelBlocks[0], mdfNoLink, mdfNoLink, mdfNoLink, mdfNoLink}, data: groupData}
+	blocks[dataGroup] = mdfBlock{id: "##DG", links: []int{mdfNoLink, channelGroup, mdfDTLink, mdfNoLink}, data: make([]byte, 8)}
+	blocks[0] = mdfBlock{id: "##HD", links: []int{dataGroup, fileHistory, mdfNoLink, mdfNoLink, mdfNoLink, mdfNoLink}, data: mdfTimeData(start, 32)}
+
+	// Resolve links now every block size is known
+	offsets := make([]int64, len(blocks))
+	offset := int64(mdfIDBlockSize)
+	for i, block := range blocks {
+		offsets[i] = offset
+		offset += int64(block.size())
+	}
+	w.dtOffset = offset
+	w.cycleOffset = offsets[channelGroup] + mdfBlockHeaderSize + 6*8 + 8
+
+	_, err := w.buffer.Write(mdfIDBlock(true))
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range blocks {
+		_, err = w.buffer.Write(mdfBlockBytes(block, offsets, w.dtOffset))
+		if err != nil {
+			return nil, err
+		}
+	}
+	// The DT block length is written on close
+	_, err = w.buffer.Write(mdfBlockBytes(mdfBlock{id: "##DT"}, nil, 0))
+	return w, err
+}
+
+func (w *mdf4Writer) WriteRow(t time.Time, values []float64) error {
+	binary.LittleEndian.PutUint64(w.record, math.Float64bits(t.Sub(w.start).Seconds()))
+	for i, value := range values {
+		binary.LittleEndian.PutUint64(w.record[8*(i+1):], math.Float64bits(value))
+	}
+	_, err := w.buffer.Write(w.record)
+	if err == nil {
+		w.records++
+	}
+	return err
+}
+
+func (w *mdf4Writer) Size() int64 {
+	return w.size.count + int64(w.buffer.Buffered())
+}
+
+// Close writes the cycle count and DT block length then marks the file as finished.
+func (w *mdf4Writer) Close() error {
+	err := w.buffer.Flush()
+	if err == nil {
+		err = w.finish()
+	}
+	closeErr := w.file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func (w *mdf4Writer) finish() error {
+	_, err := w.file.WriteAt(binary.LittleEndian.AppendUint64(nil, w.records), w.cycleOffset)
+	if err != nil {
+		return err
+	}
+	dtLength := uint64(mdfBlockHeaderSize) + w.records*uint64(len(w.record))
+	_, err = w.file.WriteAt(binary.LittleEndian.AppendUint64(nil, dtLength), w.dtOffset+8)
+	if err != nil {
+		return err
+	}
+	_, err = w.file.WriteAt(mdfIDBlock(false), 0)
+	return err
+}
+
+// mdfIDBlock returns the identification block, an unfinished file has a different file identifier and flags.
+func mdfIDBlock(unfinished bool) []byte {
+	block := make([]byte, mdfIDBlockSize)
+	copy(block[0:8], "MDF     ")
+	if unfinished {
+		copy(block[0:8], "UnFinMF ")
+		binary.LittleEndian.PutUint16(block[60:62], mdfUnfinishedFlags)
+	}
+	copy(block[8:16], "4.10    ")
+	copy(block[16:24], "husk    ")
+	binary.LittleEndian.PutUint16(block[28:30], mdfVersion)
+	return block
+}
+
+// mdfBlockBytes returns a block with its links resolved.
+func mdfBlockBytes(block mdfBlock, offsets []int64, dtOffset int64) []byte {
+	data := make([]byte, 0, block.size())
+	data = append(data, block.id...)
+	data = append(data, 0, 0, 0, 0)
+	data = binary.LittleEndian.AppendUint64(data, uint64(block.size()))
+	data = binary.LittleEndian.AppendUint64(data, uint64(len(block.links)))
+	for _, link := range block.links {
+		var offset int64
+		switch link {
+		case mdfNoLink:
+		case mdfDTLink:
+			offset = dtOffset
+		default:
+			offset = offsets[link]
+		}
+		data = binary.LittleEndian.AppendUint64(data, uint64(offset))
+	}
+	return append(data, block.data...)
+}
+
+// mdfText returns a zero terminated string padded to the 8 byte block alignment.
+func mdfText(text string) []byte {
+	data := append([]byte(text), 0)
+	for len(data)%8 != 0 {
+		data = append(data, 0)
+	}
+	return data
+}
+
+// mdfTimeData returns size bytes starting with a UTC time stamp in nanoseconds, as used by the HD and FH blocks.
+func mdfTimeData(t time.Time, size int) []byte {
+	data := make([]byte, size)
+	binary.LittleEndian.PutUint64(data, uint64(t.UnixNano()))
+	return data
+}
+
+func toolVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "devel"
+}
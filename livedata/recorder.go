@@ -0,0 +1,310 @@
+package livedata
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"husk/logging"
+	"husk/services"
+)
+
+// Channel names used by the RPM and throttle triggers, these match the names in the definition files
+const (
+	ChannelEngineSpeed      = "Engine Speed"
+	ChannelThrottlePosition = "Throttle Position"
+)
+
+// DefaultTriggerStopDelay keeps a triggered recording going through brief dips below the threshold
+const DefaultTriggerStopDelay = 3 * time.Second
+
+// Format is the file format a recorder writes
+type Format int
+
+const (
+	FormatCSV Format = iota
+	// FormatMDF4 is ASAM MDF 4.10
+	FormatMDF4
+)
+
+func (f Format) extension() string {
+	if f == FormatMDF4 {
+		return ".mf4"
+	}
+	return ".csv"
+}
+
+// Trigger starts a recording when a channel reaches a threshold and stops it once the channel has stayed below
+// the threshold for StopDelay. A trigger without a channel only records when started manually.
+type Trigger struct {
+	Channel   string
+	Threshold float64
+	StopDelay time.Duration
+}
+
+// ManualTrigger only records between calls to StartRecording and StopRecording.
+func ManualTrigger() Trigger {
+	return Trigger{}
+}
+
+// RPMTrigger records while the engine speed is at least rpm.
+func RPMTrigger(rpm float64) Trigger {
+	return Trigger{Channel: ChannelEngineSpeed, Threshold: rpm, StopDelay: DefaultTriggerStopDelay}
+}
+
+// ThrottleTrigger records while the throttle is open at least percent.
+func ThrottleTrigger(percent float64) Trigger {
+	return Trigger{Channel: ChannelThrottlePosition, Threshold: percent, StopDelay: DefaultTriggerStopDelay}
+}
+
+// RecorderConfig describes where and when a recorder writes.
+type RecorderConfig struct {
+	// Directory the files are written to, the working directory if empty
+	Directory string
+	// Name prefixes every file name, the start time and part number follow it
+	Name    string
+	Format  Format
+	Trigger Trigger
+	// A new file is started once a file is older than MaxFileDuration or larger than MaxFileSize, 0 disables either
+	MaxFileDuration time.Duration
+	MaxFileSize     int64
+}
+
+// logWriter writes rows of channel values to a file.
+type logWriter interface {
+	// WriteRow writes the value of every channel, NaN if a channel hasn't been read yet
+	WriteRow(t time.Time, values []float64) error
+	// Size returns the number of bytes written
+	Size() int64
+	Close() error
+}
+
+// Recorder writes samples of channels from a streamer to files. Samples are written from the recorder's own
+// goroutine so a slow disk never holds up polling, the broadcaster drops samples instead.
+type Recorder struct {
+	isRunning  int32 // Use int32 for atomic operations
+	streamer   *Streamer
+	channels   []Channel
+	config     RecorderConfig
+	samples    chan *Sample
+	wg         sync.WaitGroup
+	cancelFunc context.CancelFunc
+	lock       sync.Mutex
+	values     []float64
+	// rowTime is the time of the samples in values that haven't been written yet
+	rowTime   time.Time
+	writer    logWriter
+	fileStart time.Time
+	// recordingStart is the start time used in the names of every part of a recording
+	recordingStart time.Time
+	part           int
+	// belowSince is when the trigger channel fell below the threshold
+	belowSince time.Time
+}
+
+// NewRecorder creates a recorder for channels, which should all be streamed by streamer. It doesn't record until started.
+func NewRecorder(streamer *Streamer, channels []Channel, config RecorderConfig) *Recorder {
+	if config.Name == "" {
+		config.Name = "husk"
+	}
+	r := &Recorder{
+		streamer: streamer,
+		channels: channels,
+		config:   config,
+		values:   make([]float64, len(channels)),
+	}
+	for i := range r.values {
+		r.values[i] = math.NaN()
+	}
+	return r
+}
+
+// Start begins listening for samples, recording starts manually or when the trigger fires.
+func (r *Recorder) Start(ctx context.Context) *Recorder {
+	if !atomic.CompareAndSwapInt32(&r.isRunning, 0, 1) {
+		return r
+	}
+	ctx, r.cancelFunc = context.WithCancel(ctx)
+	r.samples = r.streamer.Subscribe()
+	r.wg.Add(1)
+	go r.listen(ctx)
+	return r
+}
+
+// Stop stops listening and closes the current file.
+func (r *Recorder) Stop() {
+	if !atomic.CompareAndSwapInt32(&r.isRunning, 1, 0) {
+		return
+	}
+	r.cancelFunc()
+	r.streamer.Unsubscribe(r.samples)
+	r.wg.Wait()
+	r.StopRecording()
+}
+
+// IsRecording returns true while samples are being written.
+func (r *Recorder) IsRecording() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.writer != nil
+}
+
+// StartRecording starts a new recording if one isn't already in progress.
+func (r *Recorder) StartRecording() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.writer != nil {
+		return nil
+	}
+	r.recordingStart = time.Now()
+	r.part = 0
+	return r.openFile()
+}
+
+// StopRecording writes any pending samples and closes the current file.
+func (r *Recorder) StopRecording() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.closeFile()
+}
+
+func (r *Recorder) listen(ctx context.Context) {
+	defer r.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sample, ok := <-r.samples:
+			if !ok {
+				return
+			}
+			r.record(sample)
+		}
+	}
+}
+
+// record stores a sample and writes the previous row once every sample read at the same time has arrived.
+func (r *Recorder) record(sample *Sample) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.writer != nil && !sample.Time.Equal(r.rowTime) && !r.rowTime.IsZero() {
+		err := r.writeRow()
+		if err != nil {
+			l.WriteLog(fmt.Sprintf("Error writing live data log: %v", err), logging.LogLevelError)
+			r.closeFile()
+		}
+	}
+	for i, channel := range r.channels {
+		if channel.Source == sample.Channel.Source && channel.ID == sample.Channel.ID && channel.Name == sample.Channel.Name {
+			r.values[i] = sample.Value
+		}
+	}
+	r.rowTime = sample.Time
+	if sample.Channel.Name == r.config.Trigger.Channel {
+		r.checkTrigger(sample)
+	}
+}
+
+// checkTrigger starts or stops recording when the trigger channel crosses the threshold.
+func (r *Recorder) checkTrigger(sample *Sample) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	if sample.Value >= r.config.Trigger.Threshold {
+		r.belowSince = time.Time{}
+		if r.writer == nil {
+			r.recordingStart = sample.Time
+			r.part = 0
+			err := r.openFile()
+			if err != nil {
+				l.WriteLog(fmt.Sprintf("Error starting live data log: %v", err), logging.LogLevelError)
+			}
+		}
+		return
+	}
+	if r.writer == nil {
+		return
+	}
+	if r.belowSince.IsZero() {
+		r.belowSince = sample.Time
+	}
+	if sample.Time.Sub(r.belowSince) >= r.config.Trigger.StopDelay {
+		r.closeFile()
+	}
+}
+
+// writeRow writes the pending row, starting a new file first if the current one is full.
+func (r *Recorder) writeRow() error {
+	if (r.config.MaxFileDuration > 0 && r.rowTime.Sub(r.fileStart) >= r.config.MaxFileDuration) ||
+		(r.config.MaxFileSize > 0 && r.writer.Size() >= r.config.MaxFileSize) {
+		err := r.writer.Close()
+		r.writer = nil
+		if err != nil {
+			return err
+		}
+		r.part++
+		err = r.openFile()
+		if err != nil {
+			return err
+		}
+	}
+	return r.writer.WriteRow(r.rowTime, r.values)
+}
+
+func (r *Recorder) openFile() error {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	name := fmt.Sprintf("%s-%s-%03d%s", r.config.Name, r.recordingStart.Format("20060102-150405"), r.part+1, r.config.Format.extension())
+	path := filepath.Join(r.config.Directory, name)
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	r.fileStart = time.Now()
+	if !r.rowTime.IsZero() {
+		r.fileStart = r.rowTime
+	}
+	var writer logWriter
+	switch r.config.Format {
+	case FormatMDF4:
+		writer, err = newMDF4Writer(file, r.channels, r.fileStart)
+	default:
+		writer, err = newCSVWriter(file, r.channels, r.fileStart)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.writer = writer
+	l.WriteLog(fmt.Sprintf("RECORDING LIVE DATA TO %s", path), logging.LogLevelSuccess)
+	return nil
+}
+
+func (r *Recorder) closeFile() {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	if r.writer == nil {
+		return
+	}
+	if !r.rowTime.IsZero() {
+		err := r.writer.WriteRow(r.rowTime, r.values)
+		if err != nil {
+			l.WriteLog(fmt.Sprintf("Error writing live data log: %v", err), logging.LogLevelError)
+		}
+	}
+	err := r.writer.Close()
+	r.writer = nil
+	r.belowSince = time.Time{}
+	// The next recording starts without the values of this one
+	r.rowTime = time.Time{}
+	for i := range r.values {
+		r.values[i] = math.NaN()
+	}
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error closing live data log: %v", err), logging.LogLevelError)
+		return
+	}
+	l.WriteLog("STOPPED RECORDING LIVE DATA", logging.LogLevelSuccess)
+}
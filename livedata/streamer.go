@@ -0,0 +1,203 @@
+package livedata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"husk/kwp"
+	"husk/logging"
+	"husk/services"
+	"husk/uds"
+)
+
+const (
+	// MinPollInterval limits how often every channel is read when the ECU answers quickly
+	MinPollInterval = 20 * time.Millisecond
+	// PollBusShare is the share of time spent polling, leaving room on the bus for other requests
+	PollBusShare = 0.5
+	// roundTripSmoothing is the weight of the latest poll when averaging the poll time
+	roundTripSmoothing = 0.2
+)
+
+// Streamer polls channels and broadcasts their decoded samples. Channels read from the same identifier
+// share a request, and the poll interval follows the time it takes the ECU to answer every request.
+type Streamer struct {
+	isRunning   int32 // Use int32 for atomic operations
+	requester   uds.Requester
+	senderID    uint16
+	groups      []*channelGroup
+	broadcaster *SampleBroadcaster
+	wg          sync.WaitGroup
+	cancelFunc  context.CancelFunc
+	lock        sync.Mutex
+	// pollTime is the smoothed time taken to read every channel once
+	pollTime time.Duration
+}
+
+// channelGroup holds the channels read from the same identifier.
+type channelGroup struct {
+	source   uds.IdentifierSource
+	id       uint16
+	channels []Channel
+	// failing stops a group that keeps failing flooding the log
+	failing bool
+}
+
+// NewStreamer creates a streamer that reads channels using requester. It doesn't poll until started.
+func NewStreamer(requester uds.Requester, senderID uint16, channels []Channel) *Streamer {
+	s := &Streamer{
+		requester:   requester,
+		senderID:    senderID,
+		broadcaster: NewSampleBroadcaster(),
+	}
+	for _, channel := range channels {
+		group := s.group(channel.Source, channel.ID)
+		group.channels = append(group.channels, channel)
+	}
+	return s
+}
+
+// group returns the group for an identifier, creating it if needed.
+func (s *Streamer) group(source uds.IdentifierSource, id uint16) *channelGroup {
+	for _, group := range s.groups {
+		if group.source == source && group.id == id {
+			return group
+		}
+	}
+	group := &channelGroup{source: source, id: id}
+	s.groups = append(s.groups, group)
+	return group
+}
+
+// Channels returns the streamed channels, grouped by the identifier they are read from.
+func (s *Streamer) Channels() []Channel {
+	var channels []Channel
+	for _, group := range s.groups {
+		channels = append(channels, group.channels...)
+	}
+	return channels
+}
+
+// Subscribe returns a channel that receives every sample.
+func (s *Streamer) Subscribe() chan *Sample {
+	return s.broadcaster.Subscribe()
+}
+
+// Unsubscribe stops ch receiving samples.
+func (s *Streamer) Unsubscribe(ch chan *Sample) {
+	s.broadcaster.Unsubscribe(ch)
+}
+
+// Interval returns the current time between polls of each channel.
+func (s *Streamer) Interval() time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.interval()
+}
+
+func (s *Streamer) interval() time.Duration {
+	return max(MinPollInterval, time.Duration(float64(s.pollTime)/PollBusShare))
+}
+
+// Start begins polling until ctx is done or Stop is called.
+func (s *Streamer) Start(ctx context.Context) *Streamer {
+	if !atomic.CompareAndSwapInt32(&s.isRunning, 0, 1) {
+		return s
+	}
+	ctx, s.cancelFunc = context.WithCancel(ctx)
+	s.wg.Add(1)
+	go s.pollLoop(ctx)
+	return s
+}
+
+// Stop stops polling and closes every subscriber channel.
+func (s *Streamer) Stop() {
+	if !atomic.CompareAndSwapInt32(&s.isRunning, 1, 0) {
+		return
+	}
+	s.cancelFunc()
+	s.wg.Wait()
+	s.broadcaster.Cleanup()
+}
+
+func (s *Streamer) pollLoop(ctx context.Context) {
+	defer s.wg.Done()
+	for {
+		start := time.Now()
+		s.poll(ctx)
+		elapsed := time.Since(start)
+
+		s.lock.Lock()
+		if s.pollTime == 0 {
+			s.pollTime = elapsed
+		} else {
+			s.pollTime += time.Duration(roundTripSmoothing * float64(elapsed-s.pollTime))
+		}
+		wait := s.interval() - elapsed
+		s.lock.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// poll reads every group once and broadcasts a sample for each channel.
+func (s *Streamer) poll(ctx context.Context) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	for _, group := range s.groups {
+		samples, err := s.read(ctx, group)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			if !group.failing {
+				l.WriteLog(fmt.Sprintf("Error reading live data from %s identifier 0x%04X: %v", group.source, group.id, err), logging.LogLevelError)
+			}
+			group.failing = true
+			continue
+		}
+		group.failing = false
+		for _, sample := range samples {
+			s.broadcaster.Broadcast(sample)
+		}
+	}
+}
+
+// read reads the record of a group and decodes a sample for each of its channels.
+func (s *Streamer) read(ctx context.Context, group *channelGroup) ([]*Sample, error) {
+	record, err := s.readRecord(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	samples := make([]*Sample, 0, len(group.channels))
+	for _, channel := range group.channels {
+		value, err := channel.Decode(record)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, &Sample{Channel: channel, Value: value.Value, Text: value.Text, Time: now})
+	}
+	return samples, nil
+}
+
+func (s *Streamer) readRecord(ctx context.Context, group *channelGroup) ([]byte, error) {
+	switch group.source {
+	case uds.SourceDataIdentifier:
+		return uds.ReadDataByIdentifier(ctx, s.requester, s.senderID, group.id)
+	case uds.SourceLocalIdentifier:
+		if group.id > 0xFF {
+			return nil, errors.New("local identifiers are 1 byte")
+		}
+		return kwp.ReadDataByLocalIdentifier(ctx, s.requester, s.senderID, byte(group.id))
+	default:
+		return nil, fmt.Errorf("can't stream from source %q", group.source)
+	}
+}
@@ -2,6 +2,7 @@ package logging
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"husk/services"
@@ -23,6 +24,8 @@ type Message struct {
 }
 
 type Logger struct {
+	// lock guards the buffers, which are written from every goroutine
+	lock             sync.Mutex
 	bufferedLog      []Log
 	bufferedMessages []Message
 }
@@ -79,12 +82,16 @@ func (l *Logger) AddMessageSub(subFunc MessageSubFunc) {
 // WriteLog writes to the log buffer
 func (l *Logger) WriteLog(message string, logType LogLevel) {
 	log := Log{Message: message, Level: logType}
+	l.lock.Lock()
+	defer l.lock.Unlock()
 	l.bufferedLog = append(l.bufferedLog, log)
 }
 
 // WriteMessage writes a canbus/uds message to the message buffer
 func (l *Logger) WriteMessage(data string, messageType MessageType) {
 	message := Message{Data: data, MessageType: messageType}
+	l.lock.Lock()
+	defer l.lock.Unlock()
 	l.bufferedMessages = append(l.bufferedMessages, message)
 }
 
@@ -96,19 +103,22 @@ func (l *Logger) displayLogLoop(ctx context.Context) {
 			return
 		default:
 			time.Sleep(refreshDelay)
+			// Take the buffers so subscribers can log without waiting for the lock
+			l.lock.Lock()
+			bufferedLog, bufferedMessages := l.bufferedLog, l.bufferedMessages
+			l.bufferedLog = nil
+			l.bufferedMessages = nil
+			l.lock.Unlock()
 			for _, subscriber := range logSubscribers {
-				for _, log := range l.bufferedLog {
+				for _, log := range bufferedLog {
 					subscriber(log)
 				}
 			}
 			for _, subscriber := range messageSubscribers {
-				for _, message := range l.bufferedMessages {
+				for _, message := range bufferedMessages {
 					subscriber(message)
 				}
 			}
-			// Clear buffers after displaying
-			l.bufferedLog = nil
-			l.bufferedMessages = nil
 		}
 	}
 }
@@ -2,15 +2,37 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
+	"husk/drivers"
+	"husk/ecus"
 	"husk/gui"
 	"husk/logging"
+	"husk/seedkey"
+	"husk/simulator"
+	"husk/uds"
 )
 
 func main() {
+	// Parse command line options
+	debug := flag.Bool("debug", false, "enable debug features such as the virtual CAN bus driver")
+	virtualLatency := flag.Duration("virtual-latency", 0, "latency applied to frames on the virtual CAN bus")
+	virtualLossRate := flag.Float64("virtual-loss", 0, "probability (0-1) of a frame being dropped on the virtual CAN bus")
+	virtualReorderRate := flag.Float64("virtual-reorder", 0, "probability (0-1) of a frame being reordered on the virtual CAN bus")
+	simulateK01 := flag.Bool("simulate-k01", false, "attach a simulated K01 ECU to the virtual CAN bus, requires -debug")
+	simulatedDTCs := flag.String("simulate-dtcs", "", "comma separated hex DTCs stored by the simulated ECU, e.g. P0105,P1590")
+	simulatedPendingDTCs := flag.String("simulate-pending-dtcs", "", "comma separated hex DTCs the simulated ECU reports as pending")
+	dtcDatabases := flag.String("dtc-database", "", "comma separated .json or .csv DTC description files that override the built in descriptions")
+	definitionFiles := flag.String("definitions", "", "comma separated .json identifier definition files that override the built in definitions")
+	writeJournal := flag.String("write-journal", ecus.DefaultWriteJournalPath, "file the previous value of every data identifier written to the ECU is backed up to")
+	seedKeyPlugins := flag.String("seedkey-plugins", "", "comma separated external seed key algorithms as ecu:level=path, e.g. K01:1=/opt/keys/k01")
+	flag.Parse()
+
 	// Create a context that can be canceled
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel() // Ensure the context is canceled to free resources when main function exits
@@ -22,10 +44,79 @@ func main() {
 	// Register and start services
 	l := logging.RegisterLogger().Start(ctx)
 
+	// Use in house seed key algorithms that can't be part of husk
+	err := seedkey.RegisterPlugins(*seedKeyPlugins)
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error registering seed key plugins: %v", err), logging.LogLevelError)
+	}
+
+	// Descriptions and definitions shipped beside the binary are overridden by any the user passes in
+	err = uds.LoadShippedDTCDatabase()
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error loading DTC database: %v", err), logging.LogLevelError)
+	}
+	for _, path := range strings.Split(*dtcDatabases, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		err = uds.LoadDTCDatabase(path)
+		if err != nil {
+			l.WriteLog(fmt.Sprintf("Error loading DTC database: %v", err), logging.LogLevelError)
+		}
+	}
+	err = uds.LoadShippedDefinitions()
+	if err != nil {
+		l.WriteLog(fmt.Sprintf("Error loading identifier definitions: %v", err), logging.LogLevelError)
+	}
+	for _, path := range strings.Split(*definitionFiles, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		err = uds.LoadDefinitions(path)
+		if err != nil {
+			l.WriteLog(fmt.Sprintf("Error loading identifier definitions: %v", err), logging.LogLevelError)
+		}
+	}
+
+	ecus.SetWriteJournalPath(*writeJournal)
+
+	// Make the virtual bus available to the driver scan in debug mode
+	if *debug {
+		bus := drivers.NewVirtualBus(drivers.VirtualBusConfig{
+			Latency:     *virtualLatency,
+			LossRate:    *virtualLossRate,
+			ReorderRate: *virtualReorderRate,
+		})
+		defer bus.Close()
+		drivers.EnableVirtualBus(bus)
+
+		// Attach a simulated ECU so the whole stack can be used without a bike
+		if *simulateK01 {
+			config := simulator.DefaultK01Config()
+			dtcs, err := simulator.ParseDTCs(*simulatedDTCs)
+			if err != nil {
+				l.WriteLog(fmt.Sprintf("Error parsing simulated DTCs: %v", err), logging.LogLevelError)
+			}
+			config.DTCs = dtcs
+			pendingDTCs, err := simulator.ParseDTCs(*simulatedPendingDTCs)
+			if err != nil {
+				l.WriteLog(fmt.Sprintf("Error parsing simulated pending DTCs: %v", err), logging.LogLevelError)
+			}
+			config.PendingDTCs = pendingDTCs
+			sim, err := simulator.NewK01(bus, config).Start(ctx)
+			if err != nil {
+				l.WriteLog(fmt.Sprintf("Error starting simulated ECU: %v", err), logging.LogLevelError)
+			} else {
+				defer sim.Cleanup()
+			}
+		}
+	}
+
 	// Start a separate goroutine to listen for OS signals to handle shutdown gracefully
 	go func() {
 		<-signalChan
 		l.WriteLog("Received shutdown signal, canceling context and cleaning up", logging.LogLevelInfo)
+		ecus.StopActuatorTests()
 		cancel()
 	}()
 
@@ -33,4 +124,6 @@ func main() {
 	g := gui.RegisterGUI()
 	// Start logger (this will block)
 	g.Start(ctx)
+	// Never leave an actuator running once the window is closed
+	ecus.StopActuatorTests()
 }
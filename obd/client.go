@@ -0,0 +1,141 @@
+package obd
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"husk/uds"
+)
+
+// ResponseTimeout is how long every ECU has to start its response to a functional request (P2 on CAN)
+const ResponseTimeout = uds.DefaultP2 + uds.P2NetworkDelay
+
+var ErrNoResponse = errors.New("no ecu responded to the obd request")
+
+// Client sends OBD-II requests to every ECU on the bus on uds.FunctionalID and collects their responses.
+// Only one request is outstanding at a time since any ECU may answer it.
+type Client struct {
+	broadcaster *uds.MessageBroadcaster
+	lock        sync.Mutex
+	// responderIDs are the ECUs known to be on the bus, once each has answered there is nothing left to wait for
+	responderIDs []uint16
+}
+
+// NewClient creates a client reading responses from broadcaster.
+func NewClient(broadcaster *uds.MessageBroadcaster) *Client {
+	return &Client{broadcaster: broadcaster}
+}
+
+// SetResponders sets the ECUs known to be on the bus, e.g. from a previous request. Requests they have all
+// answered return without waiting for ResponseTimeout.
+func (c *Client) SetResponders(responderIDs []uint16) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.responderIDs = slices.Clone(responderIDs)
+}
+
+// NewRequest creates a functional request for mode with the given parameters, e.g. the PIDs for mode 01.
+func NewRequest(mode byte, parameters ...byte) *uds.Message {
+	return &uds.Message{
+		SenderID:  uds.FunctionalID,
+		ServiceID: mode,
+		Data:      parameters,
+		Protocol:  Protocol,
+	}
+}
+
+// Request sends a mode request to every ECU and returns the positive responses keyed by the ID each ECU
+// responded on. ECUs that don't support the request stay quiet, so responses are collected until ResponseTimeout
+// has passed or every responder set with SetResponders has answered. If no ECU responds positively the first
+// negative response is returned as an error.
+func (c *Client) Request(ctx context.Context, mode byte, parameters ...byte) (map[uint16]*uds.Message, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	// Subscribe before sending so a fast response can't be missed
+	messageChan := c.broadcaster.Subscribe()
+	defer c.broadcaster.Unsubscribe(messageChan)
+	err := NewRequest(mode, parameters...).Send(ctx)
+	if err != nil {
+		return nil, err
+	}
+	responses := make(map[uint16]*uds.Message)
+	var nrcErr error
+	// answered are the ECUs that have sent a final response, positive or negative
+	answered := make(map[uint16]struct{})
+	timer := time.NewTimer(ResponseTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case message, ok := <-messageChan:
+			if !ok {
+				return nil, uds.ErrClientClosed
+			}
+			if !message.IsResponse || message.IsPositive == nil || message.ServiceID != mode {
+				continue
+			}
+			if message.SenderID < uds.PhysicalResponseIDMin || message.SenderID > uds.PhysicalResponseIDMax {
+				continue
+			}
+			if !*message.IsPositive && message.NRC == nil {
+				continue
+			}
+			if !*message.IsPositive && *message.NRC == uds.NRCRequestCorrectlyReceivedResponsePending {
+				// The ECU needs more time, it has until P2* to respond
+				timer.Reset(uds.DefaultP2Extended + uds.P2NetworkDelay)
+				continue
+			}
+			if *message.IsPositive {
+				responses[message.SenderID] = message
+			} else if nrcErr == nil {
+				nrcErr = uds.NewNRCError(message)
+			}
+			answered[message.SenderID] = struct{}{}
+			if len(c.responderIDs) > 0 && c.allAnswered(answered) {
+				return result(responses, nrcErr)
+			}
+		case <-timer.C:
+			if receiving() {
+				// A response has started, the transport times out if the rest doesn't arrive
+				timer.Reset(uds.NCrTimeout)
+				continue
+			}
+			return result(responses, nrcErr)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// allAnswered reports whether every known responder has answered.
+func (c *Client) allAnswered(answered map[uint16]struct{}) bool {
+	for _, responderID := range c.responderIDs {
+		if _, ok := answered[responderID]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// result returns the positive responses, or the first negative response as an error if there are none.
+func result(responses map[uint16]*uds.Message, nrcErr error) (map[uint16]*uds.Message, error) {
+	if len(responses) > 0 {
+		return responses, nil
+	}
+	if nrcErr != nil {
+		return nil, nrcErr
+	}
+	return nil, ErrNoResponse
+}
+
+// receiving reports whether a multi frame response is being received from any ECU.
+func receiving() bool {
+	for responderID := uds.PhysicalResponseIDMin; responderID <= uds.PhysicalResponseIDMax; responderID++ {
+		if uds.IsReceiving(responderID) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package obd
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"slices"
+)
+
+// ReadDTCs returns the DTCs each ECU reports for mode, one of ModeShowStoredDTCs, ModeShowPendingDTCs or
+// ModeShowPermanentDTCs, keyed by the ID the ECU responded on. ECUs without DTCs are included with none.
+func ReadDTCs(ctx context.Context, c *Client, mode byte) (map[uint16][]uint16, error) {
+	if !slices.Contains([]byte{ModeShowStoredDTCs, ModeShowPendingDTCs, ModeShowPermanentDTCs}, mode) {
+		return nil, fmt.Errorf("mode 0x%02X doesn't report DTCs", mode)
+	}
+	responses, err := c.Request(ctx, mode)
+	if err != nil {
+		return nil, err
+	}
+	dtcs := make(map[uint16][]uint16, len(responses))
+	for responderID, resp := range responses {
+		// Data holds the number of DTCs followed by 2 bytes per DTC
+		if len(resp.Data) < 1 || len(resp.Data[1:]) != int(resp.Data[0])*2 {
+			return nil, fmt.Errorf("ecu 0x%03X: malformed %s response", responderID, Protocol.ServiceLabel(mode))
+		}
+		codes := []uint16{}
+		for i := 1; i+1 < len(resp.Data); i += 2 {
+			codes = append(codes, binary.BigEndian.Uint16(resp.Data[i:i+2]))
+		}
+		dtcs[responderID] = codes
+	}
+	return dtcs, nil
+}
+
+// ClearDTCs clears the stored, pending and freeze frame data of every ECU and turns off the MIL.
+// It returns the IDs of the ECUs that confirmed it.
+func ClearDTCs(ctx context.Context, c *Client) ([]uint16, error) {
+	responses, err := c.Request(ctx, ModeClearDTCs)
+	if err != nil {
+		return nil, err
+	}
+	var responderIDs []uint16
+	for responderID := range responses {
+		responderIDs = append(responderIDs, responderID)
+	}
+	slices.Sort(responderIDs)
+	return responderIDs, nil
+}
@@ -0,0 +1,114 @@
+package obd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"husk/uds"
+)
+
+// MonitorStatus is the decoded record of PIDMonitorStatus.
+type MonitorStatus struct {
+	MILOn    bool
+	DTCCount int
+}
+
+// ParseSupported returns the parameters set in a 4 byte support bitmask for the range after base, e.g.
+// PIDSupported01To20. more is true if the ECU also reports the range after this one.
+func ParseSupported(base byte, bitmask []byte) (supported []byte, more bool, err error) {
+	if len(bitmask) != 4 {
+		return nil, false, fmt.Errorf("support bitmask for 0x%02X has %d bytes not 4", base, len(bitmask))
+	}
+	// The most significant bit is base + 1 and the least significant bit is base + 0x20
+	for i := 0; i < 32; i++ {
+		if bitmask[i/8]&(0x80>>(i%8)) != 0 {
+			supported = append(supported, base+byte(i)+1)
+		}
+	}
+	if bitmask[3]&0x01 != 0 {
+		// The last bit is the next range bitmask, not data. After 0xE0 it would be PID 0x100, which wraps to 0x00
+		supported = supported[:len(supported)-1]
+	}
+	more = bitmask[3]&0x01 != 0 && base < 0xE0
+	return supported, more, nil
+}
+
+// SupportedPIDs discovers the mode 01 PIDs each ECU supports, keyed by the ID the ECU responded on.
+// The support bitmask PIDs themselves aren't included.
+func SupportedPIDs(ctx context.Context, c *Client) (map[uint16][]byte, error) {
+	supported := make(map[uint16][]byte)
+	// pending are the ECUs that reported support for the range being requested
+	var pending []uint16
+	for base := PIDSupported01To20; ; base += 0x20 {
+		responses, err := c.Request(ctx, ModeShowCurrentData, base)
+		// Every ECU supports the first range, an ECU that claimed a later one but doesn't answer just stops there
+		if err != nil && base == PIDSupported01To20 {
+			return nil, err
+		}
+		var next []uint16
+		for responderID, resp := range responses {
+			if base != PIDSupported01To20 && !slices.Contains(pending, responderID) {
+				continue
+			}
+			record, err := pidRecord(resp, base)
+			if err != nil {
+				return nil, fmt.Errorf("ecu 0x%03X: %w", responderID, err)
+			}
+			pids, more, err := ParseSupported(base, record)
+			if err != nil {
+				return nil, fmt.Errorf("ecu 0x%03X: %w", responderID, err)
+			}
+			supported[responderID] = append(supported[responderID], pids...)
+			if more {
+				next = append(next, responderID)
+			}
+		}
+		if len(next) == 0 {
+			return supported, nil
+		}
+		pending = next
+	}
+}
+
+// ReadPID returns the record of a mode 01 PID from every ECU that supports it, keyed by the ID the ECU responded on.
+func ReadPID(ctx context.Context, c *Client, pid byte) (map[uint16][]byte, error) {
+	responses, err := c.Request(ctx, ModeShowCurrentData, pid)
+	if err != nil {
+		return nil, err
+	}
+	records := make(map[uint16][]byte, len(responses))
+	for responderID, resp := range responses {
+		record, err := pidRecord(resp, pid)
+		if err != nil {
+			return nil, fmt.Errorf("ecu 0x%03X: %w", responderID, err)
+		}
+		records[responderID] = record
+	}
+	return records, nil
+}
+
+// ReadMonitorStatus returns the MIL status and stored DTC count of every ECU, keyed by the ID the ECU responded on.
+func ReadMonitorStatus(ctx context.Context, c *Client) (map[uint16]MonitorStatus, error) {
+	records, err := ReadPID(ctx, c, PIDMonitorStatus)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make(map[uint16]MonitorStatus, len(records))
+	for responderID, record := range records {
+		// The first byte holds the MIL in the high bit and the DTC count below it, the rest report the readiness tests
+		if len(record) != 4 {
+			return nil, fmt.Errorf("ecu 0x%03X: monitor status has %d bytes not 4", responderID, len(record))
+		}
+		statuses[responderID] = MonitorStatus{MILOn: record[0]&0x80 != 0, DTCCount: int(record[0] & 0x7F)}
+	}
+	return statuses, nil
+}
+
+// pidRecord returns the record following the echoed PID in a mode 01 response.
+func pidRecord(resp *uds.Message, pid byte) ([]byte, error) {
+	if len(resp.Data) < 1 || resp.Data[0] != pid {
+		return nil, fmt.Errorf("response isn't for PID 0x%02X", pid)
+	}
+	return resp.Data[1:], nil
+}
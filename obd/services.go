@@ -0,0 +1,71 @@
+package obd
+
+import (
+	"husk/uds"
+)
+
+// OBD-II (SAE J1979) modes, sent in place of a service ID
+const (
+	ModeShowCurrentData           byte = 0x01
+	ModeShowFreezeFrameData       byte = 0x02
+	ModeShowStoredDTCs            byte = 0x03
+	ModeClearDTCs                 byte = 0x04
+	ModeShowPendingDTCs           byte = 0x07
+	ModeRequestVehicleInformation byte = 0x09
+	ModeShowPermanentDTCs         byte = 0x0A
+)
+
+// Map of OBD-II modes to their names.
+var modeNames = map[byte]string{
+	ModeShowCurrentData:           "Show Current Data",
+	ModeShowFreezeFrameData:       "Show Freeze Frame Data",
+	ModeShowStoredDTCs:            "Show Stored DTCs",
+	ModeClearDTCs:                 "Clear DTCs",
+	ModeShowPendingDTCs:           "Show Pending DTCs",
+	ModeRequestVehicleInformation: "Request Vehicle Information",
+	ModeShowPermanentDTCs:         "Show Permanent DTCs",
+}
+
+// Mode 01 PIDs with a meaning beyond their definition
+const (
+	// PIDSupported01To20 is a bitmask of the supported PIDs 0x01 to 0x20, PID 0x20 reports the next range and so on
+	PIDSupported01To20 byte = 0x00
+	// PIDMonitorStatus holds the MIL status and the number of stored DTCs
+	PIDMonitorStatus byte = 0x01
+)
+
+// Mode 09 vehicle information types
+const (
+	// InfoTypeSupported is a bitmask of the supported information types 0x01 to 0x20
+	InfoTypeSupported byte = 0x00
+	// InfoTypeVIN is the 17 character VIN
+	InfoTypeVIN byte = 0x02
+	// InfoTypeCalibrationID holds one or more 16 character calibration ids
+	InfoTypeCalibrationID byte = 0x04
+	// InfoTypeCalibrationVerificationNumber holds a 4 byte checksum for each calibration id
+	InfoTypeCalibrationVerificationNumber byte = 0x06
+	// InfoTypeECUName is the 20 character name of the ECU
+	InfoTypeECUName byte = 0x0A
+)
+
+// Map of OBD-II parameters (for specific modes) to their names. Mode 01 PIDs are named by their definitions.
+var parameterNames = map[byte]map[byte]string{
+	ModeRequestVehicleInformation: {
+		InfoTypeSupported:                     "Supported Information Types",
+		InfoTypeVIN:                           "VIN",
+		InfoTypeCalibrationID:                 "Calibration ID",
+		InfoTypeCalibrationVerificationNumber: "Calibration Verification Number",
+		InfoTypeECUName:                       "ECU Name",
+	},
+}
+
+// Protocol is OBD-II on CAN (SAE J1979 modes over ISO 15765-4), which uses the NRCs of UDS.
+var Protocol = &uds.Protocol{
+	Name:         "OBD-II",
+	Services:     modeNames,
+	Subfunctions: parameterNames,
+	NRCs:         uds.ProtocolUDS.NRCs,
+	IdentifierServices: map[byte]uds.IdentifierSource{
+		ModeShowCurrentData: uds.SourceOBDPID,
+	},
+}
@@ -0,0 +1,120 @@
+package obd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// infoTypeItemLengths are the lengths of the data items of the known vehicle information types
+var infoTypeItemLengths = map[byte]int{
+	InfoTypeVIN:                           17,
+	InfoTypeCalibrationID:                 16,
+	InfoTypeCalibrationVerificationNumber: 4,
+	InfoTypeECUName:                       20,
+}
+
+// SupportedInfoTypes discovers the mode 09 information types each ECU supports, keyed by the ID the ECU responded on.
+func SupportedInfoTypes(ctx context.Context, c *Client) (map[uint16][]byte, error) {
+	responses, err := c.Request(ctx, ModeRequestVehicleInformation, InfoTypeSupported)
+	if err != nil {
+		return nil, err
+	}
+	supported := make(map[uint16][]byte, len(responses))
+	for responderID, resp := range responses {
+		// Data holds the echoed information type followed by the bitmask, which isn't counted as a data item
+		if len(resp.Data) < 1 || resp.Data[0] != InfoTypeSupported {
+			return nil, fmt.Errorf("ecu 0x%03X: response isn't for the supported information types", responderID)
+		}
+		infoTypes, _, err := ParseSupported(InfoTypeSupported, resp.Data[1:])
+		if err != nil {
+			return nil, fmt.Errorf("ecu 0x%03X: %w", responderID, err)
+		}
+		supported[responderID] = infoTypes
+	}
+	return supported, nil
+}
+
+// ReadVehicleInformation returns the data items of a mode 09 information type from every ECU that supports it,
+// keyed by the ID the ECU responded on. Use SupportedInfoTypes for the support bitmask.
+func ReadVehicleInformation(ctx context.Context, c *Client, infoType byte) (map[uint16][][]byte, error) {
+	if infoType%0x20 == 0 {
+		return nil, fmt.Errorf("information type 0x%02X is a support bitmask", infoType)
+	}
+	responses, err := c.Request(ctx, ModeRequestVehicleInformation, infoType)
+	if err != nil {
+		return nil, err
+	}
+	items := make(map[uint16][][]byte, len(responses))
+	for responderID, resp := range responses {
+		// Data holds the echoed information type and the number of data items followed by the items
+		if len(resp.Data) < 2 || resp.Data[0] != infoType {
+			return nil, fmt.Errorf("ecu 0x%03X: response isn't for information type 0x%02X", responderID, infoType)
+		}
+		count, data := int(resp.Data[1]), resp.Data[2:]
+		length, ok := infoTypeItemLengths[infoType]
+		if !ok {
+			// Items of unknown types can't be told apart
+			items[responderID] = [][]byte{data}
+			continue
+		}
+		if len(data) != count*length {
+			return nil, fmt.Errorf("ecu 0x%03X: %d bytes for %d items of information type 0x%02X", responderID, len(data), count, infoType)
+		}
+		for i := 0; i < len(data); i += length {
+			items[responderID] = append(items[responderID], data[i:i+length])
+		}
+	}
+	return items, nil
+}
+
+// ReadVIN returns the VIN reported by every ECU that knows it, keyed by the ID the ECU responded on.
+func ReadVIN(ctx context.Context, c *Client) (map[uint16]string, error) {
+	items, err := ReadVehicleInformation(ctx, c, InfoTypeVIN)
+	if err != nil {
+		return nil, err
+	}
+	vins := make(map[uint16]string, len(items))
+	for responderID, vin := range items {
+		if len(vin) == 1 {
+			vins[responderID] = trimPadding(vin[0])
+		}
+	}
+	return vins, nil
+}
+
+// ReadCalibrationIDs returns the calibration ids of every ECU, keyed by the ID the ECU responded on.
+func ReadCalibrationIDs(ctx context.Context, c *Client) (map[uint16][]string, error) {
+	items, err := ReadVehicleInformation(ctx, c, InfoTypeCalibrationID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[uint16][]string, len(items))
+	for responderID, calibrationIDs := range items {
+		for _, id := range calibrationIDs {
+			ids[responderID] = append(ids[responderID], trimPadding(id))
+		}
+	}
+	return ids, nil
+}
+
+// ReadCalibrationVerificationNumbers returns the checksum of each calibration id of every ECU, keyed by the ID
+// the ECU responded on.
+func ReadCalibrationVerificationNumbers(ctx context.Context, c *Client) (map[uint16][]string, error) {
+	items, err := ReadVehicleInformation(ctx, c, InfoTypeCalibrationVerificationNumber)
+	if err != nil {
+		return nil, err
+	}
+	cvns := make(map[uint16][]string, len(items))
+	for responderID, numbers := range items {
+		for _, cvn := range numbers {
+			cvns[responderID] = append(cvns[responderID], fmt.Sprintf("%X", cvn))
+		}
+	}
+	return cvns, nil
+}
+
+// trimPadding removes the padding after a text item, which is filled with 0x00 bytes.
+func trimPadding(item []byte) string {
+	return strings.TrimRight(string(item), "\x00 ")
+}
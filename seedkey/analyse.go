@@ -0,0 +1,87 @@
+package seedkey
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Candidate is a set of algorithm parameters that produces the captured key for every captured seed.
+type Candidate struct {
+	Family     string
+	Parameters string
+	Algorithm  Algorithm
+}
+
+// AlgorithmFamily searches for the parameters of a family of seed key algorithms.
+type AlgorithmFamily struct {
+	Name string
+	// Search returns every candidate in the family that satisfies all pairs
+	Search func(pairs []SeedKeyPair) []Candidate
+}
+
+// AlgorithmFamilies are the families Analyse searches
+var AlgorithmFamilies = []AlgorithmFamily{
+	{Name: "Multiplicative 16 bit", Search: searchMultiplicative16},
+}
+
+// Analyse searches every algorithm family for parameters that satisfy all pairs. Each candidate is verified
+// against all pairs again before it is returned. Pairs should all be for the same security level.
+func Analyse(pairs []SeedKeyPair) ([]Candidate, error) {
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("no accepted seed key pairs to analyse")
+	}
+	var candidates []Candidate
+	for _, family := range AlgorithmFamilies {
+		for _, candidate := range family.Search(pairs) {
+			if Verify(candidate.Algorithm, pairs) == nil {
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+	return candidates, nil
+}
+
+// Verify checks that algorithm produces the captured key for every pair.
+func Verify(algorithm Algorithm, pairs []SeedKeyPair) error {
+	for _, pair := range pairs {
+		key, err := algorithm(pair.Seed)
+		if err != nil {
+			return err
+		}
+		if string(key) != string(pair.Key) {
+			return fmt.Errorf("seed %X gave key %X but the ecu accepted %X", pair.Seed, key, pair.Key)
+		}
+	}
+	return nil
+}
+
+// searchMultiplicative16 searches for the magic number used by GenerateK01Key, key = magic * seed mod 2^16.
+func searchMultiplicative16(pairs []SeedKeyPair) []Candidate {
+	for _, pair := range pairs {
+		if len(pair.Seed) != 2 || len(pair.Key) != 2 {
+			return nil
+		}
+	}
+	var candidates []Candidate
+	// The search space is small enough to try every magic number
+	for magic := 0; magic <= 0xFFFF; magic++ {
+		algorithm := multiplicative16(uint16(magic))
+		if Verify(algorithm, pairs) == nil {
+			candidates = append(candidates, Candidate{
+				Family:     "Multiplicative 16 bit",
+				Parameters: fmt.Sprintf("magic number 0x%04X", magic),
+				Algorithm:  algorithm,
+			})
+		}
+	}
+	return candidates
+}
+
+func multiplicative16(magic uint16) Algorithm {
+	return func(seed []byte) ([]byte, error) {
+		if len(seed) != 2 {
+			return nil, fmt.Errorf("expected a 2 byte seed but got %d bytes", len(seed))
+		}
+		return binary.BigEndian.AppendUint16(nil, magic*binary.BigEndian.Uint16(seed)), nil
+	}
+}
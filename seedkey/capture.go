@@ -0,0 +1,163 @@
+package seedkey
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"husk/canbus"
+)
+
+const (
+	serviceSecurityAccess         byte = 0x27
+	serviceSecurityAccessResponse byte = 0x67
+)
+
+// SeedKeyPair is a seed sent by an ECU and the key it accepted for it.
+type SeedKeyPair struct {
+	Level SecurityLevel
+	Seed  []byte
+	Key   []byte
+}
+
+var (
+	// candump -l format: (1700000000.000000) can0 7E0#0227010000000000
+	candumpLogPattern = regexp.MustCompile(`^\(\S+\)\s+\S+\s+([0-9A-Fa-f]{1,8})#([0-9A-Fa-f]*)$`)
+	// candump default format: can0  7E0   [8]  02 27 01 00 00 00 00 00
+	candumpPattern = regexp.MustCompile(`^\S+\s+([0-9A-Fa-f]{1,8})\s+\[(\d)\]\s+((?:[0-9A-Fa-f]{2}\s*)*)$`)
+	// husk message log entries have the frame id and data on separate lines
+	messageLogIDPattern   = regexp.MustCompile(`^ID: 0x([0-9A-Fa-f]+)$`)
+	messageLogDataPattern = regexp.MustCompile(`^Data:((?: 0x[0-9A-Fa-f]{2})*)$`)
+)
+
+// ReadCaptureFrames reads CAN frames from a candump log, candump output or a husk CAN message log.
+// Lines that aren't frames are skipped.
+func ReadCaptureFrames(r io.Reader) ([]*canbus.CanFrame, error) {
+	var frames []*canbus.CanFrame
+	var messageLogID *uint16
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		var id, data string
+		if match := candumpLogPattern.FindStringSubmatch(line); match != nil {
+			id, data = match[1], match[2]
+		} else if match = candumpPattern.FindStringSubmatch(line); match != nil {
+			id, data = match[1], strings.Join(strings.Fields(match[3]), "")
+		} else if match = messageLogIDPattern.FindStringSubmatch(line); match != nil {
+			value, err := strconv.ParseUint(match[1], 16, 16)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid frame id: %v", lineNumber, err)
+			}
+			frameID := uint16(value)
+			messageLogID = &frameID
+			continue
+		} else if match = messageLogDataPattern.FindStringSubmatch(line); match != nil && messageLogID != nil {
+			id = strconv.FormatUint(uint64(*messageLogID), 16)
+			data = strings.ReplaceAll(strings.ReplaceAll(match[1], "0x", ""), " ", "")
+			messageLogID = nil
+		} else {
+			continue
+		}
+		frame, err := parseCaptureFrame(id, data)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		frames = append(frames, frame)
+	}
+	return frames, scanner.Err()
+}
+
+func parseCaptureFrame(id string, data string) (*canbus.CanFrame, error) {
+	value, err := strconv.ParseUint(id, 16, 16)
+	if err != nil || value > 0x7FF {
+		return nil, fmt.Errorf("unsupported frame id %s", id)
+	}
+	bytes, err := hex.DecodeString(data)
+	if err != nil || len(bytes) > 8 {
+		return nil, fmt.Errorf("invalid frame data %s", data)
+	}
+	frame := &canbus.CanFrame{ID: uint16(value), DLC: byte(len(bytes))}
+	copy(frame.Data[:], bytes)
+	return frame, nil
+}
+
+// ExtractSeedKeyPairs reassembles ISO-TP messages from frames and returns the seed/key pairs that the ECU
+// accepted. Keys the ECU rejected are left out.
+func ExtractSeedKeyPairs(frames []*canbus.CanFrame) []SeedKeyPair {
+	var pairs []SeedKeyPair
+	seeds := make(map[SecurityLevel][]byte)
+	keys := make(map[SecurityLevel][]byte)
+	for _, message := range reassembleMessages(frames) {
+		if len(message) < 2 {
+			continue
+		}
+		subfunction := message[1]
+		level := SecurityLevelFromSubfunction(subfunction)
+		isSeed := subfunction%2 == 1
+		switch message[0] {
+		case serviceSecurityAccessResponse:
+			if isSeed {
+				seeds[level] = message[2:]
+				delete(keys, level)
+				continue
+			}
+			// The key was accepted
+			seed, key := seeds[level], keys[level]
+			if len(seed) > 0 && len(key) > 0 {
+				pairs = append(pairs, SeedKeyPair{Level: level, Seed: seed, Key: key})
+			}
+			delete(seeds, level)
+			delete(keys, level)
+		case serviceSecurityAccess:
+			if !isSeed {
+				keys[level] = message[2:]
+			}
+		}
+	}
+	return pairs
+}
+
+// reassembleMessages joins single, first and consecutive frames into complete messages in the order they finished.
+// Flow control is assumed to have worked as frames in a capture can't be asked for again.
+func reassembleMessages(frames []*canbus.CanFrame) [][]byte {
+	var messages [][]byte
+	type partialMessage struct {
+		data   []byte
+		length int
+	}
+	partials := make(map[uint16]*partialMessage)
+	for _, frame := range frames {
+		if frame.DLC == 0 {
+			continue
+		}
+		switch frame.Data[0] >> 4 {
+		case 0x0:
+			length := int(frame.Data[0] & 0x0F)
+			if length == 0 || length >= int(frame.DLC) {
+				continue
+			}
+			messages = append(messages, append([]byte(nil), frame.Data[1:1+length]...))
+		case 0x1:
+			if frame.DLC != 8 {
+				continue
+			}
+			length := int(frame.Data[0]&0x0F)<<8 | int(frame.Data[1])
+			partials[frame.ID] = &partialMessage{data: append([]byte(nil), frame.Data[2:8]...), length: length}
+		case 0x2:
+			partial, ok := partials[frame.ID]
+			if !ok {
+				continue
+			}
+			partial.data = append(partial.data, frame.Data[1:frame.DLC]...)
+			if len(partial.data) >= partial.length {
+				messages = append(messages, partial.data[:partial.length])
+				delete(partials, frame.ID)
+			}
+		}
+	}
+	return messages
+}
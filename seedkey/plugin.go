@@ -0,0 +1,119 @@
+package seedkey
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"husk/logging"
+	"husk/services"
+)
+
+// PluginTimeout is how long a plugin has to return a key
+const PluginTimeout = 5 * time.Second
+
+// PluginRequest is written to a plugin's stdin as a single JSON object, e.g.
+// {"ecu":"K01","level":1,"seed":"1A2B"}
+type PluginRequest struct {
+	ECU   ECUType `json:"ecu"`
+	Level int     `json:"level"`
+	// Seed is hex encoded
+	Seed string `json:"seed"`
+}
+
+// PluginResponse is read from a plugin's stdout as a single JSON object, e.g. {"key":"3C4D"}.
+// A plugin that can't calculate a key sets Error instead.
+type PluginResponse struct {
+	// Key is hex encoded
+	Key   string `json:"key"`
+	Error string `json:"error,omitempty"`
+}
+
+// PluginAlgorithm returns an algorithm that runs the executable at path for every seed.
+// The executable is started with no arguments, sent a PluginRequest on stdin and must print a PluginResponse.
+func PluginAlgorithm(path string, ecuType ECUType, level SecurityLevel) Algorithm {
+	return func(seed []byte) ([]byte, error) {
+		l := services.Get(services.ServiceLogger).(*logging.Logger)
+		key, err := runPlugin(path, PluginRequest{
+			ECU:   ecuType,
+			Level: int(level),
+			Seed:  hex.EncodeToString(seed),
+		})
+		if err != nil {
+			err = fmt.Errorf("seed key plugin %s: %w", path, err)
+			l.WriteLog(fmt.Sprintf("Error %v", err), logging.LogLevelError)
+			return nil, err
+		}
+		return key, nil
+	}
+}
+
+func runPlugin(path string, request PluginRequest) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), PluginTimeout)
+	defer cancel()
+	input, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Don't wait for children of the plugin that are still holding stdout after it has been killed
+	cmd.WaitDelay = time.Second
+	err = cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("timed out after %s", PluginTimeout)
+	}
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%v: %s", err, message)
+		}
+		return nil, err
+	}
+	var response PluginResponse
+	err = json.Unmarshal(stdout.Bytes(), &response)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+	key, err := hex.DecodeString(response.Key)
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("invalid key %q", response.Key)
+	}
+	return key, nil
+}
+
+// RegisterPlugins registers plugins from a comma separated list of ecu:level=path entries,
+// e.g. K01:1=/opt/keys/k01 registers /opt/keys/k01 for K01 security level 1.
+func RegisterPlugins(specs string) error {
+	if specs == "" {
+		return nil
+	}
+	for _, spec := range strings.Split(specs, ",") {
+		target, path, ok := strings.Cut(strings.TrimSpace(spec), "=")
+		if !ok || path == "" {
+			return fmt.Errorf("invalid plugin %q, expected ecu:level=path", spec)
+		}
+		ecuType, levelString, ok := strings.Cut(target, ":")
+		if !ok || ecuType == "" {
+			return fmt.Errorf("invalid plugin %q, expected ecu:level=path", spec)
+		}
+		level, err := strconv.Atoi(levelString)
+		if err != nil || level <= 0 {
+			return fmt.Errorf("invalid security level in plugin %q", spec)
+		}
+		Register(ECUType(ecuType), SecurityLevel(level), PluginAlgorithm(path, ECUType(ecuType), SecurityLevel(level)))
+	}
+	return nil
+}
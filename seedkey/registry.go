@@ -0,0 +1,66 @@
+package seedkey
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ECUType identifies the ECU family an algorithm belongs to
+type ECUType string
+
+const (
+	ECUTypeK01 ECUType = "K01"
+	// ECUTypeOBD is any ECU diagnosed with the generic OBD-II services, it has no security algorithms
+	ECUTypeOBD ECUType = "OBD"
+)
+
+// Algorithm calculates the key for a seed sent by the ECU.
+type Algorithm func(seed []byte) ([]byte, error)
+
+type algorithmKey struct {
+	ecuType ECUType
+	level   SecurityLevel
+}
+
+var (
+	algorithms     = make(map[algorithmKey]Algorithm)
+	algorithmsLock sync.RWMutex
+)
+
+func init() {
+	// Level 1 is left out until its magic number is known
+	Register(ECUTypeK01, SecurityLevel2, k01Algorithm(SecurityLevel2))
+	Register(ECUTypeK01, SecurityLevel3, k01Algorithm(SecurityLevel3))
+}
+
+// Register adds the algorithm used to unlock a security level of an ECU family, replacing any existing one.
+func Register(ecuType ECUType, level SecurityLevel, algorithm Algorithm) {
+	algorithmsLock.Lock()
+	defer algorithmsLock.Unlock()
+	algorithms[algorithmKey{ecuType, level}] = algorithm
+}
+
+// Lookup returns the algorithm registered for a security level of an ECU family.
+func Lookup(ecuType ECUType, level SecurityLevel) (Algorithm, error) {
+	algorithmsLock.RLock()
+	defer algorithmsLock.RUnlock()
+	algorithm, ok := algorithms[algorithmKey{ecuType, level}]
+	if !ok {
+		return nil, fmt.Errorf("no seed key algorithm registered for %s level %d", ecuType, level)
+	}
+	return algorithm, nil
+}
+
+// k01Algorithm adapts GenerateK01Key to the Algorithm signature.
+func k01Algorithm(level SecurityLevel) Algorithm {
+	return func(seed []byte) ([]byte, error) {
+		if len(seed) != 2 {
+			return nil, fmt.Errorf("expected a 2 byte seed but got %d bytes", len(seed))
+		}
+		key, err := GenerateK01Key([2]byte{seed[0], seed[1]}, level)
+		if err != nil {
+			return nil, err
+		}
+		return key[:], nil
+	}
+}
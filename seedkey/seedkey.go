@@ -8,3 +8,14 @@ const (
 	SecurityLevel2
 	SecurityLevel3
 )
+
+// RequestSeedSubfunction returns the SecurityAccess request seed subfunction for the level.
+// The matching send key subfunction is always one higher.
+func (level SecurityLevel) RequestSeedSubfunction() byte {
+	return byte(level)*2 - 1
+}
+
+// SecurityLevelFromSubfunction returns the level for a SecurityAccess request seed or send key subfunction.
+func SecurityLevelFromSubfunction(subfunction byte) SecurityLevel {
+	return SecurityLevel((subfunction + 1) / 2)
+}
@@ -1,5 +1,7 @@
 package services
 
+import "sync"
+
 type ServiceName string
 
 const (
@@ -9,19 +11,28 @@ const (
 	ServiceLogger ServiceName = "logger"
 )
 
-var registry = make(map[ServiceName]interface{})
+var (
+	registryLock sync.RWMutex
+	registry     = make(map[ServiceName]interface{})
+)
 
 // Register a service by name
 func Register(name ServiceName, service interface{}) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
 	registry[name] = service
 }
 
 func Deregister(name ServiceName) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
 	delete(registry, name)
 }
 
 // Get retrieves a registered service
 func Get(name ServiceName) interface{} {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
 	service := registry[name]
 	return service
 }
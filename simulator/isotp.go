@@ -0,0 +1,256 @@
+package simulator
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"husk/canbus"
+	"husk/drivers"
+	"husk/uds"
+)
+
+// wait 1 second for frames from the tester before giving up on a transfer
+const frameWaitTimeout = 1 * time.Second
+
+// maxMessageLength is the largest message accepted from the tester
+const maxMessageLength = 0x10000
+
+// maxFlowControlWaitFrames is the number of FS=Wait frames accepted from the tester
+const maxFlowControlWaitFrames = 10
+
+var (
+	errorFCFrameTimeout       = errors.New("timeout while waiting for flow control frame from tester")
+	errorCFFrameTimeout       = errors.New("timeout while waiting for consecutive frames from tester")
+	errorUnexpectedFrameIndex = errors.New("unexpected frame index")
+	errorFrameChannelClosed   = errors.New("frame channel has been closed")
+	errorFCOverflow           = errors.New("tester reported overflow in flow control frame")
+	errorFCTooManyWaits       = errors.New("tester sent too many flow control wait frames")
+	errorMessageTooLarge      = errors.New("message from tester is larger than the max message length")
+)
+
+// isoTPEndpoint is a minimal ISO-TP implementation used by simulated ECUs.
+// It can't use the uds package transport as that always sends using the registered driver.
+type isoTPEndpoint struct {
+	driver    *drivers.VirtualDriver
+	frameChan chan *canbus.CanFrame
+	txID      uint16
+	rxID      uint16
+	// functionalID is the id requests addressed to every ECU are sent to, they are single frames only
+	functionalID uint16
+	// blockSize and separationTime are sent to the tester in flow control frames
+	blockSize      byte
+	separationTime byte
+}
+
+// newISOTPEndpoint subscribes to frames from the driver. Frames with an id other than rxID or functionalID are ignored.
+func newISOTPEndpoint(driver *drivers.VirtualDriver, txID uint16, rxID uint16, functionalID uint16, blockSize byte, separationTime byte) *isoTPEndpoint {
+	return &isoTPEndpoint{
+		driver:         driver,
+		frameChan:      driver.SubscribeReadFrames(),
+		txID:           txID,
+		rxID:           rxID,
+		functionalID:   functionalID,
+		blockSize:      blockSize,
+		separationTime: separationTime,
+	}
+}
+
+// read blocks until a complete message has been received from the tester. functional is true if
+// the message was addressed to every ECU.
+func (t *isoTPEndpoint) read(ctx context.Context) (data []byte, functional bool, err error) {
+	for {
+		frame, err := t.nextFrame(ctx, 0, true)
+		if err != nil {
+			return nil, false, err
+		}
+		functional = frame.ID == t.functionalID
+		pciFrameType := (frame.Data[0] & 0xF0) >> 4
+		switch pciFrameType {
+		case uds.PCIFrameTypeSF:
+			dataLength := frame.Data[0] & 0x0F
+			if dataLength == 0 || dataLength > 7 {
+				continue
+			}
+			data = make([]byte, dataLength)
+			copy(data, frame.Data[1:dataLength+1])
+			return data, functional, nil
+		case uds.PCIFrameTypeFF:
+			if functional {
+				continue
+			}
+			data, err = t.readMultiFrame(ctx, frame)
+			return data, false, err
+		default:
+			// Stray consecutive and flow control frames are ignored
+			continue
+		}
+	}
+}
+
+// readMultiFrame receives the consecutive frames following a first frame.
+func (t *isoTPEndpoint) readMultiFrame(ctx context.Context, firstFrame *canbus.CanFrame) ([]byte, error) {
+	dataLength := (int(firstFrame.Data[0]&0x0F) << 8) | int(firstFrame.Data[1])
+	dataStart := 2
+	if dataLength == 0 {
+		// Escape sequence, the length is in the next 4 bytes
+		dataLength = int(binary.BigEndian.Uint32(firstFrame.Data[2:6]))
+		dataStart = 6
+	}
+	if dataLength <= 7 || dataLength > maxMessageLength {
+		err := t.sendFrame(ctx, []byte{(uds.PCIFrameTypeFC << 4) | uds.FlowStatusOverflow, 0, 0})
+		if err != nil {
+			return nil, err
+		}
+		return nil, errorMessageTooLarge
+	}
+	data := make([]byte, dataLength)
+	bytesReceived := copy(data, firstFrame.Data[dataStart:8])
+	frameIndex := byte(1)
+	framesInBlock := 0
+	err := t.sendFlowControlFrame(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for bytesReceived < dataLength {
+		// Allow the next block once the current one has been received
+		if t.blockSize != 0 && framesInBlock == int(t.blockSize) {
+			err = t.sendFlowControlFrame(ctx)
+			if err != nil {
+				return nil, err
+			}
+			framesInBlock = 0
+		}
+		frame, err := t.nextFrame(ctx, frameWaitTimeout, false)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return nil, errorCFFrameTimeout
+			}
+			return nil, err
+		}
+		if (frame.Data[0]&0xF0)>>4 != uds.PCIFrameTypeCF {
+			continue
+		}
+		if frame.Data[0]&0x0F != frameIndex {
+			return nil, errorUnexpectedFrameIndex
+		}
+		bytesToCopy := min(dataLength-bytesReceived, 7)
+		copy(data[bytesReceived:], frame.Data[1:bytesToCopy+1])
+		bytesReceived += bytesToCopy
+		framesInBlock++
+		frameIndex = (frameIndex + 1) % 16
+	}
+	return data, nil
+}
+
+// sendFlowControlFrame tells the tester to continue sending consecutive frames.
+func (t *isoTPEndpoint) sendFlowControlFrame(ctx context.Context) error {
+	return t.sendFrame(ctx, []byte{(uds.PCIFrameTypeFC << 4) | uds.FlowStatusContinueToSend, t.blockSize, t.separationTime})
+}
+
+// write sends a complete message to the tester, splitting it into multiple frames if required.
+func (t *isoTPEndpoint) write(ctx context.Context, data []byte) error {
+	if len(data) <= 7 {
+		return t.sendFrame(ctx, append([]byte{uds.PCIFrameTypeSF | byte(len(data))}, data...))
+	}
+	firstFrame := []byte{(uds.PCIFrameTypeFF << 4) | byte((len(data)>>8)&0x0F), byte(len(data) & 0xFF)}
+	if len(data) > 0xFFF {
+		// Escape sequence for lengths that don't fit in 12 bits
+		firstFrame = binary.BigEndian.AppendUint32([]byte{uds.PCIFrameTypeFF << 4, 0}, uint32(len(data)))
+	}
+	bytesSent := 8 - len(firstFrame)
+	err := t.sendFrame(ctx, append(firstFrame, data[:bytesSent]...))
+	if err != nil {
+		return err
+	}
+	frameIndex := byte(1)
+	for bytesSent < len(data) {
+		blockSize, separationTime, err := t.waitForFlowControlFrame(ctx)
+		if err != nil {
+			return err
+		}
+		for framesSent := 0; bytesSent < len(data) && (blockSize == 0 || framesSent < int(blockSize)); framesSent++ {
+			time.Sleep(separationTimeToDuration(separationTime))
+			bytesToSend := min(len(data)-bytesSent, 7)
+			frame := append([]byte{(uds.PCIFrameTypeCF << 4) | frameIndex}, data[bytesSent:bytesSent+bytesToSend]...)
+			err = t.sendFrame(ctx, frame)
+			if err != nil {
+				return err
+			}
+			bytesSent += bytesToSend
+			frameIndex = (frameIndex + 1) % 16
+		}
+	}
+	return nil
+}
+
+// waitForFlowControlFrame waits for the tester to allow a block of consecutive frames to be sent.
+func (t *isoTPEndpoint) waitForFlowControlFrame(ctx context.Context) (blockSize byte, separationTime byte, err error) {
+	waitFrames := 0
+	for {
+		frame, err := t.nextFrame(ctx, frameWaitTimeout, false)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return 0, 0, errorFCFrameTimeout
+			}
+			return 0, 0, err
+		}
+		if (frame.Data[0]&0xF0)>>4 != uds.PCIFrameTypeFC {
+			continue
+		}
+		switch frame.Data[0] & 0x0F {
+		case uds.FlowStatusContinueToSend:
+			return frame.Data[1], frame.Data[2], nil
+		case uds.FlowStatusWait:
+			waitFrames++
+			if waitFrames > maxFlowControlWaitFrames {
+				return 0, 0, errorFCTooManyWaits
+			}
+		default:
+			return 0, 0, errorFCOverflow
+		}
+	}
+}
+
+// nextFrame returns the next frame sent to this endpoint, including functionally addressed frames if functional
+// is set. A timeout of 0 waits until the context is done.
+func (t *isoTPEndpoint) nextFrame(ctx context.Context, timeout time.Duration, functional bool) (*canbus.CanFrame, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	for {
+		select {
+		case frame, ok := <-t.frameChan:
+			if !ok {
+				return nil, errorFrameChannelClosed
+			}
+			if frame.ID != t.rxID && (!functional || frame.ID != t.functionalID) {
+				continue
+			}
+			return frame, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// sendFrame sends a single frame containing the given bytes.
+func (t *isoTPEndpoint) sendFrame(ctx context.Context, data []byte) error {
+	frame := &canbus.CanFrame{ID: t.txID, DLC: byte(len(data))}
+	copy(frame.Data[:], data)
+	return t.driver.SendFrame(ctx, frame)
+}
+
+// separationTimeToDuration converts an ISO-TP STmin byte to a duration.
+func separationTimeToDuration(separationTime byte) time.Duration {
+	if separationTime <= 0x7F {
+		return time.Duration(separationTime) * time.Millisecond
+	}
+	if separationTime >= 0xF1 && separationTime <= 0xF9 {
+		return time.Duration(100*(int(separationTime)-0xF0)) * time.Microsecond
+	}
+	return 0x7F * time.Millisecond
+}
@@ -0,0 +1,593 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"husk/drivers"
+	"husk/kwp"
+	"husk/logging"
+	"husk/obd"
+	"husk/seedkey"
+	"husk/services"
+	"husk/uds"
+)
+
+const (
+	// K01SimulatorName is the name of the bus endpoint used by the simulated K01 ECU
+	K01SimulatorName = "k01 simulator"
+	// K01ResponseDelay emulates the time the ECU takes to process a request
+	K01ResponseDelay = 10 * time.Millisecond
+	// K01MaxKeyAttempts is the number of invalid keys accepted before security access is locked
+	K01MaxKeyAttempts = 3
+	// K01LockoutDelay is how long security access stays locked after too many invalid keys
+	K01LockoutDelay = 10 * time.Second
+	// K01RomSize is the size of the simulated ROM
+	K01RomSize = 0x00100000
+	// K01MaxBlockLength is the TransferData length reported in RequestUpload and RequestDownload responses
+	K01MaxBlockLength = 0x802
+	// K01MaxReadMemorySize is the largest ReadMemoryByAddress request accepted
+	K01MaxReadMemorySize = 0x2000
+	// K01EraseDuration is how long erasing memory takes
+	K01EraseDuration = 3 * time.Second
+	// K01ResponsePendingInterval is the delay between response pending messages during long operations
+	K01ResponsePendingInterval = 1 * time.Second
+	// K01P2 and K01P2Extended are the timings reported in DiagnosticSessionControl responses
+	K01P2         = 50 * time.Millisecond
+	K01P2Extended = 5 * time.Second
+	// K01S3 is how long a non default session lasts without a request from the tester
+	K01S3 = 5 * time.Second
+	// suppressPositiveResponseBit is set in a subfunction when the tester doesn't want a positive response
+	suppressPositiveResponseBit byte = 0x80
+)
+
+// K01Config describes the simulated ECU.
+type K01Config struct {
+	HardwareId   string
+	SoftwareId   string
+	Model        string
+	VIN          string
+	Manufacturer string
+	Country      string
+	// DTCs are the stored error codes reported by ReadErrorsK01, which is also OBD-II mode 03
+	DTCs []uint16
+	// PendingDTCs have failed but not often enough to be confirmed, only ReadDTCInformation and OBD-II mode 07 report them
+	PendingDTCs []uint16
+	// FreezeFrame is the snapshot stored with every DTC
+	FreezeFrame K01Conditions
+	// Conditions are the live engine conditions read by ReadDataByIdentifier and KWP2000 ReadDataByLocalIdentifier
+	Conditions K01Conditions
+	// Settings are the values WriteDataByIdentifier changes
+	Settings K01Settings
+	// ResponseDelay defaults to K01ResponseDelay
+	ResponseDelay time.Duration
+	// RomStartAddress is the address of the first byte of Rom
+	RomStartAddress uint32
+	// Rom is the memory returned by ReadMemoryByAddress and RequestUpload
+	Rom []byte
+	// MemorySecurityLevel is the security level that must be unlocked to access memory
+	MemorySecurityLevel seedkey.SecurityLevel
+	// SettingsSecurityLevel is the security level that must be unlocked to write settings
+	SettingsSecurityLevel seedkey.SecurityLevel
+	// RoutineSecurityLevel is the security level that must be unlocked to run the throttle adaptation and injector
+	// routines and to drive the fuel pump, injector and ignition coil
+	RoutineSecurityLevel seedkey.SecurityLevel
+	// BlockSize and SeparationTime are sent in flow control frames when receiving multi frame requests
+	BlockSize      byte
+	SeparationTime byte
+}
+
+// K01 simulates a KTM/Husqvarna K01 ECU attached to a virtual bus.
+type K01 struct {
+	isRunning     int32 // Use int32 for atomic operations
+	config        K01Config
+	driver        *drivers.VirtualDriver
+	transport     *isoTPEndpoint
+	wg            sync.WaitGroup
+	cancelFunc    context.CancelFunc
+	lock          sync.Mutex
+	dtcs          []uint16
+	pendingDTCs   []uint16
+	conditions    K01Conditions
+	settings      K01Settings
+	rom           []byte
+	session       byte
+	seedLevel     seedkey.SecurityLevel
+	seed          [2]byte
+	unlockedLevel seedkey.SecurityLevel
+	keyAttempts   int
+	lockedUntil   time.Time
+	transfer      *k01Transfer
+	routine       *k01Routine
+	// actuators holds the control state of the actuators the tester is driving
+	actuators map[uint16][]byte
+	// communicationControl is the CommunicationControl type applied to normal communication
+	communicationControl byte
+	dtcSettingOff        bool
+	lastRequest          time.Time
+	// responsePending is set by handlers that take a long time to complete
+	responsePending time.Duration
+	// droppedResponses counts the responses to each service that are handled but not sent
+	droppedResponses map[byte]int
+}
+
+// DefaultK01Config returns the identification of a FE/FS 701 ECU that ScanK01 accepts.
+func DefaultK01Config() K01Config {
+	return K01Config{
+		HardwareId:            "613.41.031.300",
+		SoftwareId:            "KM2A0EU17H0631",
+		Model:                 "FE/FS 701",
+		VIN:                   "VBKHVA400JM000001",
+		Manufacturer:          "Husqvarna",
+		Country:               "AT",
+		ResponseDelay:         K01ResponseDelay,
+		RomStartAddress:       0x00000000,
+		Rom:                   generateRom(K01RomSize),
+		MemorySecurityLevel:   seedkey.SecurityLevel2,
+		SettingsSecurityLevel: seedkey.SecurityLevel2,
+		RoutineSecurityLevel:  seedkey.SecurityLevel2,
+		BlockSize:             8,
+		SeparationTime:        0x01,
+		FreezeFrame:           DefaultK01FreezeFrame(),
+		Conditions:            DefaultK01Conditions(),
+		Settings:              DefaultK01Settings(),
+	}
+}
+
+// generateRom returns repeatable pseudo random ROM contents.
+func generateRom(size int) []byte {
+	rom := make([]byte, size)
+	r := rand.New(rand.NewPCG(0x701, 0x690))
+	for i := range rom {
+		rom[i] = byte(r.Uint32())
+	}
+	return rom
+}
+
+// NewK01 creates a simulated K01 ECU on the given bus. It doesn't answer requests until started.
+func NewK01(bus *drivers.VirtualBus, config K01Config) *K01 {
+	if config.ResponseDelay == 0 {
+		config.ResponseDelay = K01ResponseDelay
+	}
+	return &K01{
+		config:           config,
+		driver:           drivers.NewVirtualDriver(bus, K01SimulatorName),
+		dtcs:             append([]uint16(nil), config.DTCs...),
+		pendingDTCs:      append([]uint16(nil), config.PendingDTCs...),
+		conditions:       config.Conditions,
+		settings:         config.Settings,
+		rom:              append([]byte(nil), config.Rom...),
+		session:          uds.SubfunctionDefaultSession,
+		actuators:        make(map[uint16][]byte),
+		droppedResponses: make(map[byte]int),
+	}
+}
+
+// Start attaches the simulator to the bus and begins answering requests.
+func (s *K01) Start(ctx context.Context) (*K01, error) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	// Create a cancellable context
+	ctx, s.cancelFunc = context.WithCancel(ctx)
+	// The simulator endpoint is started but never registered, the tester owns the driver service
+	_, err := s.driver.Start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.transport = newISOTPEndpoint(s.driver, uds.ECUID, uds.TesterID, uds.FunctionalID, s.config.BlockSize, s.config.SeparationTime)
+	atomic.StoreInt32(&s.isRunning, 1)
+	s.wg.Add(1)
+	go s.serve(ctx)
+	l.WriteLog(fmt.Sprintf("Simulated %s %s ECU running", s.config.Manufacturer, s.config.Model), logging.LogLevelSuccess)
+	return s, nil
+}
+
+// Cleanup detaches the simulator from the bus and stops answering requests.
+func (s *K01) Cleanup() {
+	if !atomic.CompareAndSwapInt32(&s.isRunning, 1, 0) {
+		// If isRunning was not 1, Cleanup has already been called
+		return
+	}
+	if s.cancelFunc != nil {
+		s.cancelFunc()
+	}
+	s.driver.Cleanup()
+	s.wg.Wait()
+}
+
+// SetDTCs replaces the stored error codes.
+func (s *K01) SetDTCs(dtcs []uint16) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.dtcs = append([]uint16(nil), dtcs...)
+}
+
+// DTCs returns the currently stored error codes.
+func (s *K01) DTCs() []uint16 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]uint16(nil), s.dtcs...)
+}
+
+// SetPendingDTCs replaces the pending error codes.
+func (s *K01) SetPendingDTCs(dtcs []uint16) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pendingDTCs = append([]uint16(nil), dtcs...)
+}
+
+// Rom returns the current contents of the simulated ROM.
+func (s *K01) Rom() []byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]byte(nil), s.rom...)
+}
+
+// DropResponses handles the next count requests to serviceID without sending the response, as if it was lost
+// on the bus.
+func (s *K01) DropResponses(serviceID byte, count int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.droppedResponses[serviceID] = count
+}
+
+// CommunicationControl returns the CommunicationControl type applied to normal communication, and whether
+// DTC setting is on.
+func (s *K01) CommunicationControl() (controlType byte, dtcSettingOn bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.communicationControl, !s.dtcSettingOff
+}
+
+// serve reads requests from the tester and answers them until the context is cancelled.
+func (s *K01) serve(ctx context.Context) {
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	defer s.wg.Done()
+	for {
+		request, functional, err := s.transport.read(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, errorFrameChannelClosed) {
+				return
+			}
+			l.WriteLog(fmt.Sprintf("Simulated ECU failed to read request: %v", err), logging.LogLevelWarning)
+			continue
+		}
+		response, pending := s.handleRequest(request)
+		if response == nil || (functional && suppressedFunctionalResponse(response)) {
+			continue
+		}
+		time.Sleep(s.config.ResponseDelay)
+		// Keep the tester waiting while a long operation completes
+		for pending > 0 {
+			err = s.transport.write(ctx, negativeResponse(request[0], uds.NRCRequestCorrectlyReceivedResponsePending))
+			if err != nil {
+				break
+			}
+			wait := min(pending, K01ResponsePendingInterval)
+			time.Sleep(wait)
+			pending -= wait
+		}
+		err = s.transport.write(ctx, response)
+		if err != nil && ctx.Err() == nil {
+			l.WriteLog(fmt.Sprintf("Simulated ECU failed to send response: %v", err), logging.LogLevelWarning)
+		}
+	}
+}
+
+// handleRequest returns the raw response to a request, or nil if no response should be sent.
+// If the response is pending the tester should be told to wait for that long before it is sent.
+func (s *K01) handleRequest(request []byte) (response []byte, pending time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.responsePending = 0
+	// Non default sessions end if the tester goes quiet
+	if s.session != uds.SubfunctionDefaultSession && time.Since(s.lastRequest) > K01S3 {
+		s.session = uds.SubfunctionDefaultSession
+		s.unlockedLevel = seedkey.SecurityLevelUnspecified
+		s.transfer = nil
+		s.routine = nil
+		clear(s.actuators)
+		s.communicationControl = uds.SubfunctionEnableRxAndTx
+		s.dtcSettingOff = false
+	}
+	s.lastRequest = time.Now()
+	response = s.dispatch(request)
+	if s.droppedResponses[request[0]] > 0 {
+		s.droppedResponses[request[0]]--
+		return nil, 0
+	}
+	return response, s.responsePending
+}
+
+// dispatch passes a request to the handler for its service.
+func (s *K01) dispatch(request []byte) []byte {
+	serviceId := request[0]
+	switch serviceId {
+	case uds.ServiceDiagnosticSessionControl:
+		return s.handleDiagnosticSessionControl(request)
+	case uds.ServiceECUReset:
+		return s.handleECUReset(request)
+	case uds.ServiceTesterPresent:
+		return s.handleTesterPresent(request)
+	case kwp.ServiceReadEcuIdentification:
+		return s.handleReadId(request)
+	case kwp.ServiceReadErrorsK01:
+		return s.handleReadErrors()
+	case kwp.ServiceClearErrorsK01:
+		s.dtcs = nil
+		s.pendingDTCs = nil
+		return positiveResponse(serviceId)
+	case uds.ServiceReadDTCInformation:
+		return s.handleReadDTCInformation(request)
+	case uds.ServiceReadDataByIdentifier:
+		return s.handleReadDataByIdentifier(request)
+	case uds.ServiceWriteDataByIdentifier:
+		return s.handleWriteDataByIdentifier(request)
+	case uds.ServiceInputOutputControlByIdentifier:
+		return s.handleInputOutputControlByIdentifier(request)
+	case kwp.ServiceReadDataByLocalIdentifier:
+		return s.handleReadDataByLocalIdentifier(request)
+	case obd.ModeShowCurrentData:
+		return s.handleShowCurrentData(request)
+	case obd.ModeShowPendingDTCs:
+		return handleShowDTCs(serviceId, s.pendingDTCs)
+	case obd.ModeShowPermanentDTCs:
+		// DTCs are cleared for good as soon as they are cleared
+		return handleShowDTCs(serviceId, nil)
+	case obd.ModeRequestVehicleInformation:
+		return s.handleRequestVehicleInformation(request)
+	case uds.ServiceSecurityAccess:
+		return s.handleSecurityAccess(request)
+	case uds.ServiceReadMemoryByAddress:
+		return s.handleReadMemoryByAddress(request)
+	case uds.ServiceRequestUpload, uds.ServiceRequestDownload:
+		return s.handleRequestTransfer(request)
+	case uds.ServiceTransferData:
+		return s.handleTransferData(request)
+	case uds.ServiceRequestTransferExit:
+		return s.handleRequestTransferExit()
+	case uds.ServiceRoutineControl:
+		return s.handleRoutineControl(request)
+	case uds.ServiceCommunicationControl:
+		return s.handleCommunicationControl(request)
+	case uds.ServiceControlDTCSetting:
+		return s.handleControlDTCSetting(request)
+	default:
+		return negativeResponse(serviceId, uds.NRCServiceNotSupported)
+	}
+}
+
+func (s *K01) handleDiagnosticSessionControl(request []byte) []byte {
+	if len(request) != 2 {
+		return negativeResponse(uds.ServiceDiagnosticSessionControl, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	session := request[1] &^ suppressPositiveResponseBit
+	switch session {
+	case uds.SubfunctionDefaultSession, uds.SubfunctionProgrammingSession, uds.SubfunctionExtendedDiagnosticSession:
+	default:
+		return negativeResponse(uds.ServiceDiagnosticSessionControl, uds.NRCSubFunctionNotSupported)
+	}
+	// Changing session always locks security access again
+	s.session = session
+	s.unlockedLevel = seedkey.SecurityLevelUnspecified
+	s.transfer = nil
+	s.routine = nil
+	clear(s.actuators)
+	if session == uds.SubfunctionDefaultSession {
+		// Returning to the default session restores normal communication and DTC setting
+		s.communicationControl = uds.SubfunctionEnableRxAndTx
+		s.dtcSettingOff = false
+	}
+	if request[1]&suppressPositiveResponseBit != 0 {
+		return nil
+	}
+	// P2 is reported in milliseconds and P2* in units of 10 milliseconds
+	p2 := uint16(K01P2 / time.Millisecond)
+	p2Extended := uint16(K01P2Extended / (10 * time.Millisecond))
+	return positiveResponse(uds.ServiceDiagnosticSessionControl, session, byte(p2>>8), byte(p2), byte(p2Extended>>8), byte(p2Extended))
+}
+
+func (s *K01) handleECUReset(request []byte) []byte {
+	if len(request) != 2 {
+		return negativeResponse(uds.ServiceECUReset, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	resetType := request[1] &^ suppressPositiveResponseBit
+	switch resetType {
+	case uds.SubfunctionHardReset, uds.SubfunctionKeyOffOnReset, uds.SubfunctionSoftReset:
+	default:
+		return negativeResponse(uds.ServiceECUReset, uds.NRCSubFunctionNotSupported)
+	}
+	// A reset returns the ECU to its power on state
+	s.session = uds.SubfunctionDefaultSession
+	s.unlockedLevel = seedkey.SecurityLevelUnspecified
+	s.seedLevel = seedkey.SecurityLevelUnspecified
+	s.transfer = nil
+	s.routine = nil
+	clear(s.actuators)
+	s.communicationControl = uds.SubfunctionEnableRxAndTx
+	s.dtcSettingOff = false
+	if request[1]&suppressPositiveResponseBit != 0 {
+		return nil
+	}
+	return positiveResponse(uds.ServiceECUReset, resetType)
+}
+
+func (s *K01) handleCommunicationControl(request []byte) []byte {
+	if len(request) != 3 {
+		return negativeResponse(uds.ServiceCommunicationControl, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	controlType := request[1] &^ suppressPositiveResponseBit
+	switch controlType {
+	case uds.SubfunctionEnableRxAndTx, uds.SubfunctionEnableRxAndDisableTx, uds.SubfunctionDisableRxAndEnableTx, uds.SubfunctionDisableRxAndTx:
+	default:
+		return negativeResponse(uds.ServiceCommunicationControl, uds.NRCSubFunctionNotSupported)
+	}
+	if s.session == uds.SubfunctionDefaultSession {
+		return negativeResponse(uds.ServiceCommunicationControl, uds.NRCServiceNotSupportedInActiveSession)
+	}
+	// Only normal communication is simulated, the ECU doesn't take part in network management
+	if request[2] != uds.CommunicationTypeNormal {
+		return negativeResponse(uds.ServiceCommunicationControl, uds.NRCRequestOutOfRange)
+	}
+	s.communicationControl = controlType
+	if request[1]&suppressPositiveResponseBit != 0 {
+		return nil
+	}
+	return positiveResponse(uds.ServiceCommunicationControl, controlType)
+}
+
+func (s *K01) handleControlDTCSetting(request []byte) []byte {
+	if len(request) < 2 {
+		return negativeResponse(uds.ServiceControlDTCSetting, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	settingType := request[1] &^ suppressPositiveResponseBit
+	switch settingType {
+	case uds.SubfunctionDTCSettingOn, uds.SubfunctionDTCSettingOff:
+	default:
+		return negativeResponse(uds.ServiceControlDTCSetting, uds.NRCSubFunctionNotSupported)
+	}
+	if s.session == uds.SubfunctionDefaultSession {
+		return negativeResponse(uds.ServiceControlDTCSetting, uds.NRCServiceNotSupportedInActiveSession)
+	}
+	s.dtcSettingOff = settingType == uds.SubfunctionDTCSettingOff
+	if request[1]&suppressPositiveResponseBit != 0 {
+		return nil
+	}
+	return positiveResponse(uds.ServiceControlDTCSetting, settingType)
+}
+
+func (s *K01) handleTesterPresent(request []byte) []byte {
+	if len(request) > 1 && request[1]&suppressPositiveResponseBit != 0 {
+		return nil
+	}
+	return positiveResponse(uds.ServiceTesterPresent, request[1:]...)
+}
+
+func (s *K01) handleReadId(request []byte) []byte {
+	if len(request) != 2 {
+		return negativeResponse(kwp.ServiceReadEcuIdentification, kwp.NRCSubFunctionNotSupportedInvalidFormat)
+	}
+	var value string
+	switch request[1] {
+	case kwp.IdentificationOptionVINK01:
+		value = s.config.VIN
+	case kwp.IdentificationOptionECUHardwareIdK01:
+		value = s.config.HardwareId
+	case kwp.IdentificationOptionECUSoftwareIdK01:
+		value = s.config.SoftwareId
+	case kwp.IdentificationOptionCountryK01:
+		value = s.config.Country
+	case kwp.IdentificationOptionManufacturerK01:
+		value = s.config.Manufacturer
+	case kwp.IdentificationOptionModelK01:
+		value = s.config.Model
+	default:
+		return negativeResponse(kwp.ServiceReadEcuIdentification, kwp.NRCSubFunctionNotSupportedInvalidFormat)
+	}
+	return positiveResponse(kwp.ServiceReadEcuIdentification, append([]byte{request[1]}, value...)...)
+}
+
+// handleReadErrors answers the K01 read errors service, which is also OBD-II mode 03.
+func (s *K01) handleReadErrors() []byte {
+	return handleShowDTCs(kwp.ServiceReadErrorsK01, s.dtcs)
+}
+
+func (s *K01) handleSecurityAccess(request []byte) []byte {
+	if len(request) < 2 {
+		return negativeResponse(uds.ServiceSecurityAccess, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	if s.session == uds.SubfunctionDefaultSession {
+		return negativeResponse(uds.ServiceSecurityAccess, uds.NRCServiceNotSupportedInActiveSession)
+	}
+	subfunction := request[1]
+	level := seedkey.SecurityLevelFromSubfunction(subfunction)
+	if _, err := seedkey.GenerateK01Key([2]byte{}, level); err != nil {
+		// Only levels with a known key algorithm are simulated
+		return negativeResponse(uds.ServiceSecurityAccess, uds.NRCSubFunctionNotSupported)
+	}
+	if time.Now().Before(s.lockedUntil) {
+		return negativeResponse(uds.ServiceSecurityAccess, uds.NRCRequiredTimeDelayNotExpired)
+	}
+	if subfunction == level.RequestSeedSubfunction() {
+		if len(request) != 2 {
+			return negativeResponse(uds.ServiceSecurityAccess, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+		}
+		// An already unlocked level is reported with a zero seed
+		if s.unlockedLevel == level {
+			return positiveResponse(uds.ServiceSecurityAccess, subfunction, 0x00, 0x00)
+		}
+		seed := uint16(rand.IntN(0xFFFF) + 1)
+		s.seedLevel = level
+		s.seed = [2]byte{byte(seed >> 8), byte(seed)}
+		return positiveResponse(uds.ServiceSecurityAccess, subfunction, s.seed[0], s.seed[1])
+	}
+	// Send key
+	if len(request) != 4 {
+		return negativeResponse(uds.ServiceSecurityAccess, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	if s.seedLevel != level {
+		return negativeResponse(uds.ServiceSecurityAccess, uds.NRCRequestSequenceError)
+	}
+	// A seed can only be used once
+	s.seedLevel = seedkey.SecurityLevelUnspecified
+	expectedKey, _ := seedkey.GenerateK01Key(s.seed, level)
+	if request[2] != expectedKey[0] || request[3] != expectedKey[1] {
+		s.keyAttempts++
+		if s.keyAttempts >= K01MaxKeyAttempts {
+			s.keyAttempts = 0
+			s.lockedUntil = time.Now().Add(K01LockoutDelay)
+			return negativeResponse(uds.ServiceSecurityAccess, uds.NRCExceededNumberOfAttempts)
+		}
+		return negativeResponse(uds.ServiceSecurityAccess, uds.NRCInvalidKey)
+	}
+	s.keyAttempts = 0
+	s.unlockedLevel = level
+	return positiveResponse(uds.ServiceSecurityAccess, subfunction)
+}
+
+// suppressedFunctionalResponse returns true if a negative response shouldn't be sent to a functional request.
+// ECUs stay quiet about requests they don't support so only the ECUs that do answer.
+func suppressedFunctionalResponse(response []byte) bool {
+	if len(response) < 3 || response[0] != uds.NegativeResponseByte {
+		return false
+	}
+	switch response[2] {
+	case uds.NRCServiceNotSupported, uds.NRCSubFunctionNotSupported, uds.NRCRequestOutOfRange,
+		uds.NRCSubFunctionNotSupportedInActiveSession, uds.NRCServiceNotSupportedInActiveSession:
+		return true
+	default:
+		return false
+	}
+}
+
+// positiveResponse builds a raw positive response for a service.
+func positiveResponse(serviceId byte, data ...byte) []byte {
+	return append([]byte{serviceId + uds.PositiveResponseServiceIdOffset}, data...)
+}
+
+// negativeResponse builds a raw negative response for a service.
+func negativeResponse(serviceId byte, nrc byte) []byte {
+	return []byte{uds.NegativeResponseByte, serviceId, nrc}
+}
+
+// ParseDTCs parses a comma separated list of DTCs such as "P0105,P1590" or "0105,1590".
+func ParseDTCs(in string) ([]uint16, error) {
+	var dtcs []uint16
+	for _, code := range strings.Split(in, ",") {
+		code = strings.TrimSpace(code)
+		if code == "" {
+			continue
+		}
+		dtc, err := uds.ParseDTC(code)
+		if err != nil {
+			return nil, err
+		}
+		dtcs = append(dtcs, dtc)
+	}
+	return dtcs, nil
+}
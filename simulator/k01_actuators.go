@@ -0,0 +1,66 @@
+package simulator
+
+import (
+	"encoding/binary"
+	"maps"
+
+	"husk/uds"
+)
+
+// k01ActuatorStateLengths are the lengths of the control states of the actuators the simulated ECU lets the tester drive
+var k01ActuatorStateLengths = map[uint16]int{
+	uds.DIDRadiatorFanK01:  1,
+	uds.DIDFuelPumpK01:     1,
+	uds.DIDInjectorK01:     2,
+	uds.DIDIgnitionCoilK01: 2,
+	uds.DIDExhaustValveK01: 1,
+}
+
+// Actuators returns the control state of every actuator the tester is driving.
+func (s *K01) Actuators() map[uint16][]byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return maps.Clone(s.actuators)
+}
+
+func (s *K01) handleInputOutputControlByIdentifier(request []byte) []byte {
+	// The request holds a 2 byte data identifier, the control parameter and the control state
+	if len(request) < 4 {
+		return negativeResponse(uds.ServiceInputOutputControlByIdentifier, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	if s.session == uds.SubfunctionDefaultSession {
+		return negativeResponse(uds.ServiceInputOutputControlByIdentifier, uds.NRCServiceNotSupportedInActiveSession)
+	}
+	id := binary.BigEndian.Uint16(request[1:3])
+	stateLength, ok := k01ActuatorStateLengths[id]
+	if !ok {
+		return negativeResponse(uds.ServiceInputOutputControlByIdentifier, uds.NRCRequestOutOfRange)
+	}
+	parameter, state := request[3], request[4:]
+	switch parameter {
+	case uds.IOControlReturnControlToECU:
+		if len(state) != 0 {
+			return negativeResponse(uds.ServiceInputOutputControlByIdentifier, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+		}
+		delete(s.actuators, id)
+		return positiveResponse(uds.ServiceInputOutputControlByIdentifier, request[1], request[2], parameter)
+	case uds.IOControlShortTermAdjustment:
+	default:
+		return negativeResponse(uds.ServiceInputOutputControlByIdentifier, uds.NRCRequestOutOfRange)
+	}
+	if len(state) != stateLength {
+		return negativeResponse(uds.ServiceInputOutputControlByIdentifier, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	// The fuel system and ignition can only be driven with the engine stopped after unlocking security access
+	switch id {
+	case uds.DIDFuelPumpK01, uds.DIDInjectorK01, uds.DIDIgnitionCoilK01:
+		if s.unlockedLevel != s.config.RoutineSecurityLevel {
+			return negativeResponse(uds.ServiceInputOutputControlByIdentifier, uds.NRCSecurityAccessDenied)
+		}
+		if s.conditions.EngineSpeed > 0 {
+			return negativeResponse(uds.ServiceInputOutputControlByIdentifier, uds.NRCConditionsNotCorrect)
+		}
+	}
+	s.actuators[id] = append([]byte(nil), state...)
+	return positiveResponse(uds.ServiceInputOutputControlByIdentifier, append([]byte{request[1], request[2], parameter}, state...)...)
+}
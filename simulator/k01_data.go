@@ -0,0 +1,164 @@
+package simulator
+
+import (
+	"encoding/binary"
+
+	"husk/kwp"
+	"husk/uds"
+)
+
+// k01FreezeFrameDIDs are the data identifiers stored in freeze frames in the order they are stored
+var k01FreezeFrameDIDs = []uint16{
+	uds.DIDEngineSpeedK01,
+	uds.DIDCoolantTemperatureK01,
+	uds.DIDThrottlePositionK01,
+	uds.DIDBatteryVoltageK01,
+}
+
+// K01Conditions are the engine conditions the simulated ECU reports.
+type K01Conditions struct {
+	EngineSpeed        float64 // rpm
+	CoolantTemperature float64 // °C
+	ThrottlePosition   float64 // %
+	BatteryVoltage     float64 // V
+}
+
+// DefaultK01Conditions returns the conditions of a warm idling engine.
+func DefaultK01Conditions() K01Conditions {
+	return K01Conditions{
+		EngineSpeed:        1450,
+		CoolantTemperature: 88,
+		ThrottlePosition:   0,
+		BatteryVoltage:     14.1,
+	}
+}
+
+// encodeDID returns the value of a data identifier using its OBD scaling.
+func (c K01Conditions) encodeDID(id uint16) ([]byte, bool) {
+	switch id {
+	case uds.DIDEngineSpeedK01:
+		return binary.BigEndian.AppendUint16(nil, uint16(c.EngineSpeed*4)), true
+	case uds.DIDCoolantTemperatureK01:
+		return []byte{byte(c.CoolantTemperature + 40)}, true
+	case uds.DIDThrottlePositionK01:
+		return []byte{byte(c.ThrottlePosition * 255 / 100)}, true
+	case uds.DIDBatteryVoltageK01:
+		return binary.BigEndian.AppendUint16(nil, uint16(c.BatteryVoltage*1000)), true
+	default:
+		return nil, false
+	}
+}
+
+// K01Settings are the values the simulated ECU lets the tester change with WriteDataByIdentifier.
+type K01Settings struct {
+	IdleSpeedTarget float64 // rpm
+	ServiceCounter  float64 // hours
+}
+
+// DefaultK01Settings returns the settings of a bike fresh from a service.
+func DefaultK01Settings() K01Settings {
+	return K01Settings{
+		IdleSpeedTarget: 1450,
+		ServiceCounter:  0,
+	}
+}
+
+// encodeDID returns the value of a setting data identifier.
+func (c K01Settings) encodeDID(id uint16) ([]byte, bool) {
+	switch id {
+	case uds.DIDIdleSpeedTargetK01:
+		return binary.BigEndian.AppendUint16(nil, uint16(c.IdleSpeedTarget)), true
+	case uds.DIDServiceCounterK01:
+		return binary.BigEndian.AppendUint16(nil, uint16(c.ServiceCounter*10)), true
+	default:
+		return nil, false
+	}
+}
+
+// decodeDID sets a setting from the value of its data identifier.
+func (c *K01Settings) decodeDID(id uint16, value []byte) bool {
+	if len(value) != 2 {
+		return false
+	}
+	raw := float64(binary.BigEndian.Uint16(value))
+	switch id {
+	case uds.DIDIdleSpeedTargetK01:
+		c.IdleSpeedTarget = raw
+	case uds.DIDServiceCounterK01:
+		c.ServiceCounter = raw / 10
+	default:
+		return false
+	}
+	return true
+}
+
+// Settings returns the current settings.
+func (s *K01) Settings() K01Settings {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.settings
+}
+
+// SetConditions replaces the live engine conditions.
+func (s *K01) SetConditions(conditions K01Conditions) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.conditions = conditions
+}
+
+func (s *K01) handleReadDataByIdentifier(request []byte) []byte {
+	// The request holds one or more 2 byte data identifiers
+	if len(request) < 3 || (len(request)-1)%2 != 0 {
+		return negativeResponse(uds.ServiceReadDataByIdentifier, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	var data []byte
+	for i := 1; i < len(request); i += 2 {
+		id := binary.BigEndian.Uint16(request[i : i+2])
+		value, ok := s.conditions.encodeDID(id)
+		if !ok {
+			value, ok = s.settings.encodeDID(id)
+		}
+		if !ok {
+			return negativeResponse(uds.ServiceReadDataByIdentifier, uds.NRCRequestOutOfRange)
+		}
+		data = binary.BigEndian.AppendUint16(data, id)
+		data = append(data, value...)
+	}
+	return positiveResponse(uds.ServiceReadDataByIdentifier, data...)
+}
+
+func (s *K01) handleWriteDataByIdentifier(request []byte) []byte {
+	// The request holds a 2 byte data identifier followed by the value
+	if len(request) < 4 {
+		return negativeResponse(uds.ServiceWriteDataByIdentifier, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	if s.session == uds.SubfunctionDefaultSession {
+		return negativeResponse(uds.ServiceWriteDataByIdentifier, uds.NRCServiceNotSupportedInActiveSession)
+	}
+	id := binary.BigEndian.Uint16(request[1:3])
+	if _, ok := s.settings.encodeDID(id); !ok {
+		return negativeResponse(uds.ServiceWriteDataByIdentifier, uds.NRCRequestOutOfRange)
+	}
+	if s.unlockedLevel != s.config.SettingsSecurityLevel {
+		return negativeResponse(uds.ServiceWriteDataByIdentifier, uds.NRCSecurityAccessDenied)
+	}
+	if !s.settings.decodeDID(id, request[3:]) {
+		return negativeResponse(uds.ServiceWriteDataByIdentifier, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	return positiveResponse(uds.ServiceWriteDataByIdentifier, request[1:3]...)
+}
+
+func (s *K01) handleReadDataByLocalIdentifier(request []byte) []byte {
+	if len(request) != 2 {
+		return negativeResponse(kwp.ServiceReadDataByLocalIdentifier, kwp.NRCSubFunctionNotSupportedInvalidFormat)
+	}
+	if request[1] != kwp.LocalIdentifierEngineDataK01 {
+		return negativeResponse(kwp.ServiceReadDataByLocalIdentifier, kwp.NRCRequestOutOfRange)
+	}
+	data := []byte{request[1]}
+	for _, id := range k01FreezeFrameDIDs {
+		value, _ := s.conditions.encodeDID(id)
+		data = append(data, value...)
+	}
+	return positiveResponse(kwp.ServiceReadDataByLocalIdentifier, data...)
+}
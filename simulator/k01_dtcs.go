@@ -0,0 +1,130 @@
+package simulator
+
+import (
+	"encoding/binary"
+	"slices"
+
+	"husk/uds"
+)
+
+const (
+	// k01DTCStatusAvailabilityMask is the set of DTC status bits the simulated ECU supports
+	k01DTCStatusAvailabilityMask = k01ConfirmedDTCStatus
+	k01ConfirmedDTCStatus        = byte(uds.DTCStatusTestFailed | uds.DTCStatusTestFailedThisOperationCycle |
+		uds.DTCStatusPending | uds.DTCStatusConfirmed | uds.DTCStatusTestFailedSinceLastClear |
+		uds.DTCStatusWarningIndicatorRequested)
+	k01PendingDTCStatus = byte(uds.DTCStatusTestFailedThisOperationCycle | uds.DTCStatusPending |
+		uds.DTCStatusTestFailedSinceLastClear)
+	// k01DTCFormatISO14229 is the DTC format identifier for 3 byte ISO 14229 DTCs
+	k01DTCFormatISO14229 byte = 0x01
+)
+
+// DefaultK01FreezeFrame returns the conditions of a warm engine under load.
+func DefaultK01FreezeFrame() K01Conditions {
+	return K01Conditions{
+		EngineSpeed:        4250,
+		CoolantTemperature: 96,
+		ThrottlePosition:   35,
+		BatteryVoltage:     13.8,
+	}
+}
+
+// encodeSnapshotRecord returns conditions as a snapshot record holding every freeze frame data identifier.
+func encodeSnapshotRecord(recordNumber byte, conditions K01Conditions) []byte {
+	record := []byte{recordNumber, byte(len(k01FreezeFrameDIDs))}
+	for _, id := range k01FreezeFrameDIDs {
+		value, _ := conditions.encodeDID(id)
+		record = binary.BigEndian.AppendUint16(record, id)
+		record = append(record, value...)
+	}
+	return record
+}
+
+func (s *K01) handleReadDTCInformation(request []byte) []byte {
+	if len(request) < 2 {
+		return negativeResponse(uds.ServiceReadDTCInformation, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	subfunction := request[1]
+	switch subfunction {
+	case uds.SubfunctionReportNumberOfDTCByStatusMask, uds.SubfunctionReportDTCByStatusMask:
+		if len(request) != 3 {
+			return negativeResponse(uds.ServiceReadDTCInformation, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+		}
+		mask := request[2]
+		var records []byte
+		count := 0
+		for _, dtc := range s.dtcRecords() {
+			if dtc.status&mask != 0 {
+				records = append(records, byte(dtc.code>>8), byte(dtc.code), 0x00, dtc.status)
+				count++
+			}
+		}
+		if subfunction == uds.SubfunctionReportNumberOfDTCByStatusMask {
+			return positiveResponse(uds.ServiceReadDTCInformation, subfunction, k01DTCStatusAvailabilityMask, k01DTCFormatISO14229, byte(count>>8), byte(count))
+		}
+		return positiveResponse(uds.ServiceReadDTCInformation, append([]byte{subfunction, k01DTCStatusAvailabilityMask}, records...)...)
+	case uds.SubfunctionReportSupportedDTC:
+		if len(request) != 2 {
+			return negativeResponse(uds.ServiceReadDTCInformation, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+		}
+		data := []byte{subfunction, k01DTCStatusAvailabilityMask}
+		for _, dtc := range s.dtcRecords() {
+			data = append(data, byte(dtc.code>>8), byte(dtc.code), 0x00, dtc.status)
+		}
+		return positiveResponse(uds.ServiceReadDTCInformation, data...)
+	case uds.SubfunctionReportDTCSnapshotRecordByDTCNumber, uds.SubfunctionReportDTCExtDataRecordByDTCNumber:
+		if len(request) != 6 {
+			return negativeResponse(uds.ServiceReadDTCInformation, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+		}
+		// Only DTCs with a failure type byte of 0 are stored
+		code := binary.BigEndian.Uint16(request[2:4])
+		index := slices.IndexFunc(s.dtcRecords(), func(dtc k01DTCRecord) bool { return dtc.code == code })
+		if index < 0 || request[4] != 0x00 {
+			return negativeResponse(uds.ServiceReadDTCInformation, uds.NRCRequestOutOfRange)
+		}
+		dtc := s.dtcRecords()[index]
+		data := []byte{subfunction, request[2], request[3], request[4], dtc.status}
+		recordNumber := request[5]
+		if subfunction == uds.SubfunctionReportDTCSnapshotRecordByDTCNumber {
+			if recordNumber != 0x01 && recordNumber != 0xFF {
+				return negativeResponse(uds.ServiceReadDTCInformation, uds.NRCRequestOutOfRange)
+			}
+			return positiveResponse(uds.ServiceReadDTCInformation, append(data, encodeSnapshotRecord(0x01, s.config.FreezeFrame)...)...)
+		}
+		// Pending DTCs have only failed once and confirmed DTCs haven't aged at all
+		occurrences := byte(1)
+		if dtc.status&byte(uds.DTCStatusConfirmed) != 0 {
+			occurrences = 3
+		}
+		switch recordNumber {
+		case uds.ExtDataRecordOccurrenceCounterK01:
+			data = append(data, recordNumber, occurrences)
+		case uds.ExtDataRecordAgingCounterK01:
+			data = append(data, recordNumber, 0)
+		case 0xFF:
+			data = append(data, uds.ExtDataRecordOccurrenceCounterK01, occurrences, uds.ExtDataRecordAgingCounterK01, 0)
+		default:
+			return negativeResponse(uds.ServiceReadDTCInformation, uds.NRCRequestOutOfRange)
+		}
+		return positiveResponse(uds.ServiceReadDTCInformation, data...)
+	default:
+		return negativeResponse(uds.ServiceReadDTCInformation, uds.NRCSubFunctionNotSupported)
+	}
+}
+
+type k01DTCRecord struct {
+	code   uint16
+	status byte
+}
+
+// dtcRecords returns the confirmed DTCs followed by the pending DTCs with their status.
+func (s *K01) dtcRecords() []k01DTCRecord {
+	var records []k01DTCRecord
+	for _, dtc := range s.dtcs {
+		records = append(records, k01DTCRecord{code: dtc, status: k01ConfirmedDTCStatus})
+	}
+	for _, dtc := range s.pendingDTCs {
+		records = append(records, k01DTCRecord{code: dtc, status: k01PendingDTCStatus})
+	}
+	return records
+}
@@ -0,0 +1,214 @@
+package simulator
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+
+	"husk/uds"
+)
+
+// k01Transfer tracks an active upload or download.
+type k01Transfer struct {
+	isDownload           bool
+	address              uint32
+	end                  uint32
+	blockSequenceCounter byte
+	lastBlock            []byte
+}
+
+func (s *K01) handleReadMemoryByAddress(request []byte) []byte {
+	if len(request) < 2 {
+		return negativeResponse(uds.ServiceReadMemoryByAddress, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	address, size, ok := parseAddressAndLength(request[1], request[2:])
+	if !ok {
+		return negativeResponse(uds.ServiceReadMemoryByAddress, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	if s.unlockedLevel != s.config.MemorySecurityLevel {
+		return negativeResponse(uds.ServiceReadMemoryByAddress, uds.NRCSecurityAccessDenied)
+	}
+	if size == 0 || size > K01MaxReadMemorySize || !s.inRom(address, size) {
+		return negativeResponse(uds.ServiceReadMemoryByAddress, uds.NRCRequestOutOfRange)
+	}
+	offset := address - s.config.RomStartAddress
+	return positiveResponse(uds.ServiceReadMemoryByAddress, s.rom[offset:offset+size]...)
+}
+
+// handleRequestTransfer starts an upload or download.
+func (s *K01) handleRequestTransfer(request []byte) []byte {
+	serviceId := request[0]
+	isDownload := serviceId == uds.ServiceRequestDownload
+	if len(request) < 3 {
+		return negativeResponse(serviceId, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	address, size, ok := parseAddressAndLength(request[2], request[3:])
+	if !ok || request[1] != uds.DataFormatUncompressedUnencrypted {
+		return negativeResponse(serviceId, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	if isDownload && s.session != uds.SubfunctionProgrammingSession {
+		return negativeResponse(serviceId, uds.NRCServiceNotSupportedInActiveSession)
+	}
+	if s.unlockedLevel != s.config.MemorySecurityLevel {
+		return negativeResponse(serviceId, uds.NRCSecurityAccessDenied)
+	}
+	if s.transfer != nil {
+		return negativeResponse(serviceId, uds.NRCConditionsNotCorrect)
+	}
+	if size == 0 || !s.inRom(address, size) {
+		return negativeResponse(serviceId, uds.NRCRequestOutOfRange)
+	}
+	s.transfer = &k01Transfer{isDownload: isDownload, address: address, end: address + size}
+	// Length format identifier 0x20 means the max block length is 2 bytes long
+	return positiveResponse(serviceId, 0x20, byte(K01MaxBlockLength>>8), byte(K01MaxBlockLength&0xFF))
+}
+
+func (s *K01) handleTransferData(request []byte) []byte {
+	if len(request) < 2 {
+		return negativeResponse(uds.ServiceTransferData, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	if s.transfer == nil {
+		return negativeResponse(uds.ServiceTransferData, uds.NRCRequestSequenceError)
+	}
+	blockSequenceCounter := request[1]
+	// A repeated block sequence counter means the tester missed the response to the last block
+	if s.transfer.lastBlock != nil && blockSequenceCounter == s.transfer.blockSequenceCounter {
+		if s.transfer.isDownload {
+			return positiveResponse(uds.ServiceTransferData, blockSequenceCounter)
+		}
+		return positiveResponse(uds.ServiceTransferData, append([]byte{blockSequenceCounter}, s.transfer.lastBlock...)...)
+	}
+	if blockSequenceCounter != s.transfer.blockSequenceCounter+1 {
+		return negativeResponse(uds.ServiceTransferData, uds.NRCWrongBlockSequenceCounter)
+	}
+	remaining := s.transfer.end - s.transfer.address
+	if remaining == 0 {
+		return negativeResponse(uds.ServiceTransferData, uds.NRCRequestSequenceError)
+	}
+	offset := s.transfer.address - s.config.RomStartAddress
+	if s.transfer.isDownload {
+		data := request[2:]
+		if len(data) == 0 || len(data) > K01MaxBlockLength-2 || uint32(len(data)) > remaining {
+			return negativeResponse(uds.ServiceTransferData, uds.NRCRequestOutOfRange)
+		}
+		// Flash can only be programmed after it has been erased
+		for _, b := range s.rom[offset : offset+uint32(len(data))] {
+			if b != 0xFF {
+				return negativeResponse(uds.ServiceTransferData, uds.NRCGeneralProgrammingFailure)
+			}
+		}
+		copy(s.rom[offset:], data)
+		s.transfer.lastBlock = data
+		s.transfer.blockSequenceCounter = blockSequenceCounter
+		s.transfer.address += uint32(len(data))
+		return positiveResponse(uds.ServiceTransferData, blockSequenceCounter)
+	}
+	if len(request) != 2 {
+		return negativeResponse(uds.ServiceTransferData, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	size := min(remaining, K01MaxBlockLength-2)
+	s.transfer.lastBlock = s.rom[offset : offset+size]
+	s.transfer.blockSequenceCounter = blockSequenceCounter
+	s.transfer.address += size
+	return positiveResponse(uds.ServiceTransferData, append([]byte{blockSequenceCounter}, s.transfer.lastBlock...)...)
+}
+
+func (s *K01) handleRequestTransferExit() []byte {
+	if s.transfer == nil {
+		return negativeResponse(uds.ServiceRequestTransferExit, uds.NRCRequestSequenceError)
+	}
+	s.transfer = nil
+	return positiveResponse(uds.ServiceRequestTransferExit)
+}
+
+func (s *K01) handleRoutineControl(request []byte) []byte {
+	if len(request) < 4 {
+		return negativeResponse(uds.ServiceRoutineControl, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	subfunction := request[1]
+	routineId := binary.BigEndian.Uint16(request[2:4])
+	switch routineId {
+	case uds.RoutineEraseMemory, uds.RoutineCheckMemoryK01:
+	default:
+		return s.handleDiagnosticRoutine(request)
+	}
+	// The memory routines complete before responding so they can only be started
+	if subfunction != uds.SubfunctionStartRoutine {
+		return negativeResponse(uds.ServiceRoutineControl, uds.NRCSubFunctionNotSupported)
+	}
+	if routineId == uds.RoutineEraseMemory {
+		return s.handleEraseMemory(request)
+	}
+	return s.handleCheckMemory(request)
+}
+
+func (s *K01) handleEraseMemory(request []byte) []byte {
+	if len(request) < 5 {
+		return negativeResponse(uds.ServiceRoutineControl, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	address, size, ok := parseAddressAndLength(request[4], request[5:])
+	if !ok {
+		return negativeResponse(uds.ServiceRoutineControl, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	if s.session != uds.SubfunctionProgrammingSession {
+		return negativeResponse(uds.ServiceRoutineControl, uds.NRCServiceNotSupportedInActiveSession)
+	}
+	if s.unlockedLevel != s.config.MemorySecurityLevel {
+		return negativeResponse(uds.ServiceRoutineControl, uds.NRCSecurityAccessDenied)
+	}
+	if !s.inRom(address, size) {
+		return negativeResponse(uds.ServiceRoutineControl, uds.NRCRequestOutOfRange)
+	}
+	offset := address - s.config.RomStartAddress
+	for i := range s.rom[offset : offset+size] {
+		s.rom[offset+uint32(i)] = 0xFF
+	}
+	// Erasing flash is slow, the tester is told to wait with response pending
+	s.responsePending = K01EraseDuration
+	return positiveResponse(uds.ServiceRoutineControl, request[1], request[2], request[3], uds.RoutineStatusCorrect)
+}
+
+func (s *K01) handleCheckMemory(request []byte) []byte {
+	if len(request) < 5 {
+		return negativeResponse(uds.ServiceRoutineControl, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	// The expected CRC32 follows the address and size
+	format := request[4]
+	addressAndSizeLength := int(format&0x0F) + int(format>>4)
+	if len(request) != 5+addressAndSizeLength+4 {
+		return negativeResponse(uds.ServiceRoutineControl, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	address, size, ok := parseAddressAndLength(format, request[5:5+addressAndSizeLength])
+	if !ok || !s.inRom(address, size) {
+		return negativeResponse(uds.ServiceRoutineControl, uds.NRCRequestOutOfRange)
+	}
+	offset := address - s.config.RomStartAddress
+	expected := binary.BigEndian.Uint32(request[5+addressAndSizeLength:])
+	status := uds.RoutineStatusCorrect
+	if crc32.ChecksumIEEE(s.rom[offset:offset+size]) != expected {
+		status = uds.RoutineStatusIncorrect
+	}
+	return positiveResponse(uds.ServiceRoutineControl, request[1], request[2], request[3], status)
+}
+
+// inRom reports whether size bytes starting at address are inside the simulated ROM.
+func (s *K01) inRom(address uint32, size uint32) bool {
+	start := uint64(s.config.RomStartAddress)
+	end := start + uint64(len(s.rom))
+	return uint64(address) >= start && uint64(address)+uint64(size) <= end
+}
+
+// parseAddressAndLength decodes a memory address and size using an address and length format identifier.
+func parseAddressAndLength(format byte, data []byte) (address uint32, size uint32, ok bool) {
+	addressLength := int(format & 0x0F)
+	sizeLength := int(format >> 4)
+	if addressLength == 0 || addressLength > 4 || sizeLength == 0 || sizeLength > 4 || len(data) != addressLength+sizeLength {
+		return 0, 0, false
+	}
+	for _, b := range data[:addressLength] {
+		address = address<<8 | uint32(b)
+	}
+	for _, b := range data[addressLength:] {
+		size = size<<8 | uint32(b)
+	}
+	return address, size, true
+}
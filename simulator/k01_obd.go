@@ -0,0 +1,131 @@
+package simulator
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"slices"
+
+	"husk/obd"
+	"husk/uds"
+)
+
+// OBD-II PIDs the simulated ECU answers besides the support bitmasks
+const (
+	obdPIDOBDStandardK01 byte = 0x1C
+	// obdStandardEOBDK01 is reported for the OBD standard, Euro 4 bikes are certified to EOBD
+	obdStandardEOBDK01 byte = 0x06
+)
+
+// k01OBDPIDs are the mode 01 PIDs the simulated ECU supports, including the bitmasks reporting the next range.
+// The live data PIDs are the low byte of the data identifiers they are mapped to.
+var k01OBDPIDs = []byte{
+	obd.PIDMonitorStatus,
+	byte(uds.DIDCoolantTemperatureK01 & 0xFF),
+	byte(uds.DIDEngineSpeedK01 & 0xFF),
+	byte(uds.DIDThrottlePositionK01 & 0xFF),
+	obdPIDOBDStandardK01,
+	0x20,
+	0x40,
+	byte(uds.DIDBatteryVoltageK01 & 0xFF),
+}
+
+// k01OBDInfoTypes are the mode 09 information types the simulated ECU supports
+var k01OBDInfoTypes = []byte{
+	obd.InfoTypeVIN,
+	obd.InfoTypeCalibrationID,
+	obd.InfoTypeCalibrationVerificationNumber,
+}
+
+// supportBitmask returns the 4 byte bitmask of the parameters in the range after base.
+func supportBitmask(base byte, supported []byte) []byte {
+	bitmask := make([]byte, 4)
+	for _, parameter := range supported {
+		if parameter > base && int(parameter) <= int(base)+0x20 {
+			bit := parameter - base - 1
+			bitmask[bit/8] |= 0x80 >> (bit % 8)
+		}
+	}
+	return bitmask
+}
+
+// handleShowCurrentData answers a mode 01 request for up to 6 PIDs with the record of each supported PID.
+func (s *K01) handleShowCurrentData(request []byte) []byte {
+	pids := request[1:]
+	if len(pids) == 0 || len(pids) > 6 {
+		return negativeResponse(obd.ModeShowCurrentData, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	var data []byte
+	for _, pid := range pids {
+		record, ok := s.pidRecord(pid)
+		if ok {
+			data = append(append(data, pid), record...)
+		}
+	}
+	// ECUs stay quiet on functional requests for PIDs they don't support
+	if len(data) == 0 {
+		return negativeResponse(obd.ModeShowCurrentData, uds.NRCRequestOutOfRange)
+	}
+	return positiveResponse(obd.ModeShowCurrentData, data...)
+}
+
+// pidRecord returns the record of a mode 01 PID.
+func (s *K01) pidRecord(pid byte) ([]byte, bool) {
+	switch {
+	case pid%0x20 == 0:
+		return supportBitmask(pid, k01OBDPIDs), slices.Contains(k01OBDPIDs, pid) || pid == obd.PIDSupported01To20
+	case pid == obd.PIDMonitorStatus:
+		// The MIL is on while any DTC is stored, no readiness tests are reported
+		status := byte(min(len(s.dtcs), 0x7F))
+		if len(s.dtcs) > 0 {
+			status |= 0x80
+		}
+		return []byte{status, 0x00, 0x00, 0x00}, true
+	case pid == obdPIDOBDStandardK01:
+		return []byte{obdStandardEOBDK01}, true
+	default:
+		return s.conditions.encodeDID(0xF400 | uint16(pid))
+	}
+}
+
+// handleShowDTCs answers a mode 03, 07 or 0A request with the number of DTCs followed by 2 bytes per DTC.
+func handleShowDTCs(mode byte, dtcs []uint16) []byte {
+	data := []byte{byte(len(dtcs))}
+	for _, dtc := range dtcs {
+		data = binary.BigEndian.AppendUint16(data, dtc)
+	}
+	return positiveResponse(mode, data...)
+}
+
+// handleRequestVehicleInformation answers a mode 09 request with the number of data items followed by the items.
+func (s *K01) handleRequestVehicleInformation(request []byte) []byte {
+	if len(request) != 2 {
+		return negativeResponse(obd.ModeRequestVehicleInformation, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+	}
+	infoType := request[1]
+	var items [][]byte
+	switch infoType {
+	case obd.InfoTypeSupported:
+		// The support bitmask isn't a data item so there is no count before it
+		return positiveResponse(obd.ModeRequestVehicleInformation, append([]byte{infoType}, supportBitmask(infoType, k01OBDInfoTypes)...)...)
+	case obd.InfoTypeVIN:
+		items = [][]byte{padItem(s.config.VIN, 17)}
+	case obd.InfoTypeCalibrationID:
+		items = [][]byte{padItem(s.config.SoftwareId, 16)}
+	case obd.InfoTypeCalibrationVerificationNumber:
+		items = [][]byte{binary.BigEndian.AppendUint32(nil, crc32.ChecksumIEEE(s.rom))}
+	default:
+		return negativeResponse(obd.ModeRequestVehicleInformation, uds.NRCRequestOutOfRange)
+	}
+	data := []byte{infoType, byte(len(items))}
+	for _, item := range items {
+		data = append(data, item...)
+	}
+	return positiveResponse(obd.ModeRequestVehicleInformation, data...)
+}
+
+// padItem returns text padded with 0x00 bytes, or cut, to length.
+func padItem(text string, length int) []byte {
+	item := make([]byte, length)
+	copy(item, text)
+	return item
+}
@@ -0,0 +1,109 @@
+package simulator
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+
+	"husk/uds"
+)
+
+const (
+	// K01ThrottleAdaptationDuration is how long relearning the closed throttle position takes
+	K01ThrottleAdaptationDuration = 2 * time.Second
+	// K01InjectorPulseInterval is the time between injector test pulses
+	K01InjectorPulseInterval = 100 * time.Millisecond
+	// K01LearnedThrottleClosedPosition is the closed throttle position reported after adaptation, in %
+	K01LearnedThrottleClosedPosition = 4.7
+)
+
+// k01Routine is a diagnostic routine that is running or has finished.
+type k01Routine struct {
+	id       uint16
+	started  time.Time
+	duration time.Duration
+	// results are reported once the routine has finished
+	results []byte
+}
+
+// handleDiagnosticRoutine starts, stops and reports the results of the routines that take a while to run.
+func (s *K01) handleDiagnosticRoutine(request []byte) []byte {
+	subfunction := request[1] &^ suppressPositiveResponseBit
+	routineId := binary.BigEndian.Uint16(request[2:4])
+	switch routineId {
+	case uds.RoutineThrottleAdaptationResetK01, uds.RoutineInjectorTestK01, uds.RoutineFuelPumpPrimeK01:
+	default:
+		return negativeResponse(uds.ServiceRoutineControl, uds.NRCRequestOutOfRange)
+	}
+	if s.session == uds.SubfunctionDefaultSession {
+		return negativeResponse(uds.ServiceRoutineControl, uds.NRCServiceNotSupportedInActiveSession)
+	}
+	switch subfunction {
+	case uds.SubfunctionStartRoutine:
+		return s.startDiagnosticRoutine(routineId, request)
+	case uds.SubfunctionStopRoutine:
+		if s.routine == nil || s.routine.id != routineId {
+			return negativeResponse(uds.ServiceRoutineControl, uds.NRCRequestSequenceError)
+		}
+		s.routine = nil
+		return positiveResponse(uds.ServiceRoutineControl, request[1], request[2], request[3], uds.RoutineStatusCorrect)
+	case uds.SubfunctionRequestRoutineResults:
+		if s.routine == nil || s.routine.id != routineId {
+			return negativeResponse(uds.ServiceRoutineControl, uds.NRCRequestSequenceError)
+		}
+		if time.Since(s.routine.started) < s.routine.duration {
+			return positiveResponse(uds.ServiceRoutineControl, request[1], request[2], request[3], uds.RoutineStatusInProgress)
+		}
+		data := append([]byte{request[1], request[2], request[3], uds.RoutineStatusCorrect}, s.routine.results...)
+		return positiveResponse(uds.ServiceRoutineControl, data...)
+	default:
+		return negativeResponse(uds.ServiceRoutineControl, uds.NRCSubFunctionNotSupported)
+	}
+}
+
+func (s *K01) startDiagnosticRoutine(routineId uint16, request []byte) []byte {
+	options := request[4:]
+	routine := &k01Routine{id: routineId, started: time.Now()}
+	switch routineId {
+	case uds.RoutineThrottleAdaptationResetK01:
+		if len(options) != 0 {
+			return negativeResponse(uds.ServiceRoutineControl, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+		}
+		if s.unlockedLevel != s.config.RoutineSecurityLevel {
+			return negativeResponse(uds.ServiceRoutineControl, uds.NRCSecurityAccessDenied)
+		}
+		routine.duration = K01ThrottleAdaptationDuration
+		routine.results = []byte{byte(math.Round(K01LearnedThrottleClosedPosition * 255 / 100))}
+	case uds.RoutineInjectorTestK01:
+		// The pulse width is in units of 0.01 ms followed by the number of pulses
+		if len(options) != 3 {
+			return negativeResponse(uds.ServiceRoutineControl, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+		}
+		if s.unlockedLevel != s.config.RoutineSecurityLevel {
+			return negativeResponse(uds.ServiceRoutineControl, uds.NRCSecurityAccessDenied)
+		}
+		pulses := options[2]
+		if binary.BigEndian.Uint16(options[0:2]) == 0 || pulses == 0 {
+			return negativeResponse(uds.ServiceRoutineControl, uds.NRCRequestOutOfRange)
+		}
+		routine.duration = time.Duration(pulses) * K01InjectorPulseInterval
+		routine.results = []byte{pulses}
+	case uds.RoutineFuelPumpPrimeK01:
+		if len(options) != 1 {
+			return negativeResponse(uds.ServiceRoutineControl, uds.NRCIncorrectMessageLengthOrInvalidFormat)
+		}
+		if options[0] == 0 || options[0] > 10 {
+			return negativeResponse(uds.ServiceRoutineControl, uds.NRCRequestOutOfRange)
+		}
+		routine.duration = time.Duration(options[0]) * time.Second
+	}
+	// The actuators are only driven with the engine stopped and one routine at a time
+	if s.conditions.EngineSpeed > 0 {
+		return negativeResponse(uds.ServiceRoutineControl, uds.NRCConditionsNotCorrect)
+	}
+	if s.routine != nil && time.Since(s.routine.started) < s.routine.duration {
+		return negativeResponse(uds.ServiceRoutineControl, uds.NRCConditionsNotCorrect)
+	}
+	s.routine = routine
+	return positiveResponse(uds.ServiceRoutineControl, request[1], request[2], request[3], uds.RoutineStatusInProgress)
+}
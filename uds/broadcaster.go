@@ -32,8 +32,11 @@ func (b *MessageBroadcaster) Subscribe() chan *Message {
 // Unsubscribe removes a subscriber.
 func (b *MessageBroadcaster) Unsubscribe(ch chan *Message) {
 	b.lock.Lock()
-	delete(b.subscribers, ch)
-	close(ch)
+	// The channel has already been closed if the broadcaster was cleaned up
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
 	b.lock.Unlock()
 }
 
@@ -0,0 +1,168 @@
+package uds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"husk/logging"
+	"husk/services"
+)
+
+const (
+	// DefaultP2 is the default time the ECU has to start its response (P2server_max)
+	DefaultP2 = 50 * time.Millisecond
+	// DefaultP2Extended is the default time the ECU has to respond after a response pending NRC (P2*server_max)
+	DefaultP2Extended = 5000 * time.Millisecond
+	// P2NetworkDelay is added to P2 and P2* to cover adapter and bus latency
+	P2NetworkDelay = 500 * time.Millisecond
+	// DefaultBusyRetries is the number of times a request is resent after a busy repeat request NRC
+	DefaultBusyRetries = 3
+	// SuppressedNetworkDelay is added to P2 when only a negative response can follow a request, it is kept short
+	// since the client is held while waiting and suppressed requests like tester present are sent periodically
+	SuppressedNetworkDelay = 50 * time.Millisecond
+	// BusyRetryDelay is how long to wait before resending a request the ECU was too busy to handle
+	BusyRetryDelay = 200 * time.Millisecond
+)
+
+// suppressPositiveResponseBit is set in a subfunction when the ECU shouldn't send a positive response
+const suppressPositiveResponseBit byte = 0x80
+
+var (
+	ErrResponseTimeout = errors.New("timeout waiting for response from ecu")
+	ErrClientClosed    = errors.New("message broadcaster has been cleaned up")
+)
+
+// Requester sends a request and returns the response, it is implemented by Client and SessionManager.
+type Requester interface {
+	Request(ctx context.Context, req *Message) (*Message, error)
+}
+
+// Client sends requests to an ECU and waits for the matching responses.
+// Only one request is outstanding at a time so responses can be matched by service id.
+type Client struct {
+	testerID    uint16
+	broadcaster *MessageBroadcaster
+	lock        sync.Mutex
+	timingLock  sync.RWMutex
+	p2          time.Duration
+	p2Extended  time.Duration
+	busyRetries int
+}
+
+// NewClient creates a client sending from testerID. Responses are read from broadcaster.
+func NewClient(testerID uint16, broadcaster *MessageBroadcaster) *Client {
+	return &Client{
+		testerID:    testerID,
+		broadcaster: broadcaster,
+		p2:          DefaultP2,
+		p2Extended:  DefaultP2Extended,
+		busyRetries: DefaultBusyRetries,
+	}
+}
+
+// SetTiming sets the P2 and P2* times, usually from a DiagnosticSessionControl response.
+func (c *Client) SetTiming(p2 time.Duration, p2Extended time.Duration) {
+	c.timingLock.Lock()
+	defer c.timingLock.Unlock()
+	c.p2 = p2
+	c.p2Extended = p2Extended
+}
+
+// Timing returns the P2 and P2* times currently in use.
+func (c *Client) Timing() (p2 time.Duration, p2Extended time.Duration) {
+	c.timingLock.RLock()
+	defer c.timingLock.RUnlock()
+	return c.p2, c.p2Extended
+}
+
+// Request sends req and waits for the response from the ECU. Response pending NRCs extend the wait to P2* and
+// busy repeat request NRCs resend the request. A negative response is returned along with an *NRCError.
+// Requests with the suppress positive response bit set return nil if the ECU doesn't send a negative response
+// within P2 and SuppressedNetworkDelay.
+func (c *Client) Request(ctx context.Context, req *Message) (*Message, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	// Subscribe before sending so a fast response can't be missed
+	messageChan := c.broadcaster.Subscribe()
+	defer c.broadcaster.Unsubscribe(messageChan)
+	for attempt := 0; ; attempt++ {
+		err := req.Send(ctx)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.waitForResponse(ctx, messageChan, req)
+		if err != nil {
+			return resp, err
+		}
+		if resp == nil {
+			// The positive response was suppressed
+			return nil, nil
+		}
+		if *resp.IsPositive {
+			return resp, nil
+		}
+		if *resp.NRC == NRCBusyRepeatRequest && attempt < c.busyRetries {
+			l := services.Get(services.ServiceLogger).(*logging.Logger)
+			l.WriteLog(fmt.Sprintf("ECU busy, resending %s request", req.ServiceLabel()), logging.LogLevelWarning)
+			select {
+			case <-time.After(BusyRetryDelay):
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		return resp, NewNRCError(resp)
+	}
+}
+
+// waitForResponse waits for the final response to req, skipping any response pending NRCs.
+func (c *Client) waitForResponse(ctx context.Context, messageChan chan *Message, req *Message) (*Message, error) {
+	p2, p2Extended := c.Timing()
+	suppressed := req.suppressesPositiveResponse()
+	responderID := ResponderID(c.testerID)
+	timeout := p2 + P2NetworkDelay
+	if suppressed {
+		timeout = p2 + SuppressedNetworkDelay
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case message, ok := <-messageChan:
+			if !ok {
+				return nil, ErrClientClosed
+			}
+			// IsPositive should never be nil on a response
+			if !message.IsResponse || message.IsPositive == nil {
+				continue
+			}
+			if message.SenderID != responderID || message.ServiceID != req.ServiceID {
+				continue
+			}
+			if !*message.IsPositive && message.NRC == nil {
+				continue
+			}
+			if !*message.IsPositive && *message.NRC == NRCRequestCorrectlyReceivedResponsePending {
+				// The ECU needs more time, it has until P2* to respond
+				timer.Reset(p2Extended + P2NetworkDelay)
+				continue
+			}
+			return message, nil
+		case <-timer.C:
+			if IsReceiving(responderID) {
+				// The response has started, the transport times out if the rest doesn't arrive
+				timer.Reset(NCrTimeout)
+				continue
+			}
+			if suppressed {
+				return nil, nil
+			}
+			return nil, ErrResponseTimeout
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
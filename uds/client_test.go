@@ -0,0 +1,46 @@
+package uds
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRequestSuppressesPositiveResponseOnlyForUDSSubfunctions(t *testing.T) {
+	ctx, _ := setupVirtualBus(t)
+	broadcaster := NewUDSMessageBroadcaster()
+	t.Cleanup(broadcaster.Cleanup)
+	c := NewClient(TesterID, broadcaster)
+	c.SetTiming(20*time.Millisecond, DefaultP2Extended)
+	otherProtocol := &Protocol{Name: "KWP"}
+	tests := []struct {
+		name     string
+		protocol *Protocol
+		service  byte
+		// suppressed requests don't wait for a late positive response
+		suppressed bool
+	}{
+		{name: "UDS session", service: ServiceDiagnosticSessionControl, suppressed: true},
+		{name: "UDS without suppressible subfunction", service: ServiceReadDTCInformation},
+		{name: "other protocol session", protocol: otherProtocol, service: ServiceDiagnosticSessionControl},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subfunction := byte(0x81)
+			req := &Message{SenderID: TesterID, ServiceID: test.service, Subfunction: &subfunction, Protocol: test.protocol}
+			// The response arrives after the suppressed response window but well within P2
+			go func() {
+				time.Sleep(20*time.Millisecond + 2*SuppressedNetworkDelay)
+				broadcaster.Broadcast(RawDataToMessage(ECUID, []byte{test.service + PositiveResponseServiceIdOffset, subfunction}, true))
+			}()
+			resp, err := c.Request(ctx, req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (resp == nil) != test.suppressed {
+				t.Fatalf("response %v, suppressed %v", resp, test.suppressed)
+			}
+			// Let a late response pass before the next request
+			time.Sleep(2 * SuppressedNetworkDelay)
+		})
+	}
+}
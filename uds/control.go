@@ -0,0 +1,176 @@
+package uds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CommunicationType values for CommunicationControl, selecting which messages are enabled or disabled
+const (
+	CommunicationTypeNormal                     byte = 0x01
+	CommunicationTypeNetworkManagement          byte = 0x02
+	CommunicationTypeNormalAndNetworkManagement byte = 0x03
+)
+
+// FunctionalTesterPresentInterval is how often every ECU is sent a TesterPresent while the bus is prepared for
+// programming, it must be shorter than S3Timeout or the other ECUs enable their communication again
+const FunctionalTesterPresentInterval = 2 * time.Second
+
+// NewECUResetRequest creates an ECUReset request.
+func NewECUResetRequest(senderID uint16, resetType byte) *Message {
+	return &Message{
+		SenderID:    senderID,
+		ServiceID:   ServiceECUReset,
+		Subfunction: &resetType,
+	}
+}
+
+// ECUReset resets the ECU, which restarts in the default session with security access locked.
+func ECUReset(ctx context.Context, r Requester, senderID uint16, resetType byte) error {
+	_, err := r.Request(ctx, NewECUResetRequest(senderID, resetType))
+	return err
+}
+
+// NewCommunicationControlRequest creates a CommunicationControl request.
+func NewCommunicationControlRequest(senderID uint16, controlType byte, communicationType byte) *Message {
+	return &Message{
+		SenderID:    senderID,
+		ServiceID:   ServiceCommunicationControl,
+		Subfunction: &controlType,
+		Data:        []byte{communicationType},
+	}
+}
+
+// CommunicationControl enables or disables the transmission and reception of communicationType messages.
+// Diagnostic messages are never disabled.
+func CommunicationControl(ctx context.Context, r Requester, senderID uint16, controlType byte, communicationType byte) error {
+	_, err := r.Request(ctx, NewCommunicationControlRequest(senderID, controlType, communicationType))
+	return err
+}
+
+// NewControlDTCSettingRequest creates a ControlDTCSetting request.
+func NewControlDTCSettingRequest(senderID uint16, settingType byte) *Message {
+	return &Message{
+		SenderID:    senderID,
+		ServiceID:   ServiceControlDTCSetting,
+		Subfunction: &settingType,
+	}
+}
+
+// ControlDTCSetting starts or stops the ECU updating the status of its DTCs.
+func ControlDTCSetting(ctx context.Context, r Requester, senderID uint16, settingType byte) error {
+	_, err := r.Request(ctx, NewControlDTCSettingRequest(senderID, settingType))
+	return err
+}
+
+// SendFunctional sends req to every ECU on the bus with the positive response suppressed. Responses aren't
+// waited for since any number of ECUs may answer, or none at all.
+func SendFunctional(ctx context.Context, req *Message) error {
+	functional := *req
+	functional.SenderID = FunctionalID
+	if req.Subfunction != nil {
+		subfunction := *req.Subfunction | suppressPositiveResponseBit
+		functional.Subfunction = &subfunction
+	}
+	// Functional requests can't use flow control, every ECU would answer the first frame
+	if len(functional.ToRawData()) > 7 {
+		return fmt.Errorf("%s request is too long to send functionally", req.ServiceLabel())
+	}
+	return functional.Send(ctx)
+}
+
+// ProgrammingPreparation holds the other ECUs on the bus quiet while an ECU is programmed. They are kept in the
+// extended session with normal communication and DTC setting disabled until Restore is called.
+type ProgrammingPreparation struct {
+	requester   Requester
+	senderID    uint16
+	cancelFunc  context.CancelFunc
+	done        chan struct{}
+	restoreOnce sync.Once
+	restoreErr  error
+}
+
+// PrepareForProgramming sends the programming preamble to every ECU: the extended session, DTC setting off and
+// normal communication disabled. r and senderID address the ECU about to be programmed, which is reset by Restore.
+// If any step fails the bus is restored before the error is returned.
+func PrepareForProgramming(ctx context.Context, r Requester, senderID uint16) (*ProgrammingPreparation, error) {
+	keepAliveCtx, cancelFunc := context.WithCancel(context.WithoutCancel(ctx))
+	p := &ProgrammingPreparation{
+		requester:  r,
+		senderID:   senderID,
+		cancelFunc: cancelFunc,
+		done:       make(chan struct{}),
+	}
+	requests := []*Message{
+		// The other services are only accepted outside the default session
+		NewDiagnosticSessionControlRequest(FunctionalID, SubfunctionExtendedDiagnosticSession),
+		NewControlDTCSettingRequest(FunctionalID, SubfunctionDTCSettingOff),
+		NewCommunicationControlRequest(FunctionalID, SubfunctionDisableRxAndTx, CommunicationTypeNormal),
+	}
+	go p.keepAlive(keepAliveCtx)
+	for _, req := range requests {
+		err := SendFunctional(ctx, req)
+		if err != nil {
+			restoreErr := p.restore(ctx, 0)
+			return nil, errors.Join(fmt.Errorf("failed to send %s: %w", req.ServiceLabel(), err), restoreErr)
+		}
+	}
+	return p, nil
+}
+
+// Restore enables DTC setting and normal communication again, resets the programmed ECU with resetType and
+// returns every ECU to the default session. It is sent even if ctx is done and only the first call has any effect.
+func (p *ProgrammingPreparation) Restore(ctx context.Context, resetType byte) error {
+	return p.restore(ctx, resetType)
+}
+
+// restore undoes the preparation, a resetType of 0 doesn't reset the programmed ECU.
+func (p *ProgrammingPreparation) restore(ctx context.Context, resetType byte) error {
+	p.restoreOnce.Do(func() {
+		ctx = context.WithoutCancel(ctx)
+		var errs []error
+		requests := []*Message{
+			NewControlDTCSettingRequest(FunctionalID, SubfunctionDTCSettingOn),
+			NewCommunicationControlRequest(FunctionalID, SubfunctionEnableRxAndTx, CommunicationTypeNormal),
+		}
+		for _, req := range requests {
+			err := SendFunctional(ctx, req)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to send %s: %w", req.ServiceLabel(), err))
+			}
+		}
+		p.cancelFunc()
+		<-p.done
+		if resetType != 0 {
+			err := ECUReset(ctx, p.requester, p.senderID, resetType)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to reset ECU: %w", err))
+			}
+		}
+		// Leaving the extended session also restores anything an ECU missed
+		err := SendFunctional(ctx, NewDiagnosticSessionControlRequest(FunctionalID, SubfunctionDefaultSession))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to return ECUs to the default session: %w", err))
+		}
+		p.restoreErr = errors.Join(errs...)
+	})
+	return p.restoreErr
+}
+
+// keepAlive stops the other ECUs leaving the extended session, which would enable their communication again.
+func (p *ProgrammingPreparation) keepAlive(ctx context.Context) {
+	defer close(p.done)
+	ticker := time.NewTicker(FunctionalTesterPresentInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = SendFunctional(ctx, NewTesterPresentRequest(FunctionalID))
+		}
+	}
+}
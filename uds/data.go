@@ -0,0 +1,61 @@
+package uds
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+)
+
+// NewReadDataByIdentifierRequest creates a ReadDataByIdentifier request for one or more data identifiers.
+func NewReadDataByIdentifierRequest(senderID uint16, ids ...uint16) *Message {
+	var data []byte
+	for _, id := range ids {
+		data = binary.BigEndian.AppendUint16(data, id)
+	}
+	return &Message{
+		SenderID:  senderID,
+		ServiceID: ServiceReadDataByIdentifier,
+		Data:      data,
+	}
+}
+
+// ReadDataByIdentifier returns the value of a data identifier.
+func ReadDataByIdentifier(ctx context.Context, r Requester, senderID uint16, id uint16) ([]byte, error) {
+	resp, err := r.Request(ctx, NewReadDataByIdentifierRequest(senderID, id))
+	if err != nil {
+		return nil, err
+	}
+	// Data holds the echoed data identifier followed by its value
+	if len(resp.Data) < 2 {
+		return nil, fmt.Errorf("read data by identifier response too short")
+	}
+	if responseID := binary.BigEndian.Uint16(resp.Data[:2]); responseID != id {
+		return nil, fmt.Errorf("response is for data identifier 0x%04X not 0x%04X", responseID, id)
+	}
+	return resp.Data[2:], nil
+}
+
+// NewWriteDataByIdentifierRequest creates a WriteDataByIdentifier request setting a data identifier to value.
+func NewWriteDataByIdentifierRequest(senderID uint16, id uint16, value []byte) *Message {
+	return &Message{
+		SenderID:  senderID,
+		ServiceID: ServiceWriteDataByIdentifier,
+		Data:      append(binary.BigEndian.AppendUint16(nil, id), value...),
+	}
+}
+
+// WriteDataByIdentifier sets the value of a data identifier.
+func WriteDataByIdentifier(ctx context.Context, r Requester, senderID uint16, id uint16, value []byte) error {
+	resp, err := r.Request(ctx, NewWriteDataByIdentifierRequest(senderID, id, value))
+	if err != nil {
+		return err
+	}
+	// Data holds the echoed data identifier
+	if len(resp.Data) < 2 {
+		return fmt.Errorf("write data by identifier response too short")
+	}
+	if responseID := binary.BigEndian.Uint16(resp.Data[:2]); responseID != id {
+		return fmt.Errorf("response is for data identifier 0x%04X not 0x%04X", responseID, id)
+	}
+	return nil
+}
@@ -0,0 +1,625 @@
+package uds
+
+import (
+	"embed"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"husk/seedkey"
+)
+
+// DefinitionsDirectory is the directory beside the executable that identifier definition files are loaded from
+const DefinitionsDirectory = "definitions"
+
+// IdentifierSource is the service an identifier is read with
+type IdentifierSource string
+
+const (
+	// SourceDataIdentifier is a 2 byte data identifier read with ReadDataByIdentifier
+	SourceDataIdentifier IdentifierSource = "data"
+	// SourceLocalIdentifier is a 1 byte local identifier read with KWP2000 ReadDataByLocalIdentifier
+	SourceLocalIdentifier IdentifierSource = "local"
+	// SourceECUIdentification is a 1 byte identification option read with KWP2000 ReadEcuIdentification
+	SourceECUIdentification IdentifierSource = "ecuId"
+	// SourceOBDPID is a 1 byte OBD-II parameter id read with mode 01 ShowCurrentData
+	SourceOBDPID IdentifierSource = "pid"
+)
+
+// DataType is how the bytes of a field are interpreted
+type DataType string
+
+const (
+	// DataTypeUnsigned is an unsigned integer, the default
+	DataTypeUnsigned DataType = "unsigned"
+	// DataTypeSigned is a two's complement integer
+	DataTypeSigned DataType = "signed"
+	// DataTypeFloat is a 4 or 8 byte IEEE 754 float
+	DataTypeFloat DataType = "float"
+	// DataTypeASCII is text, trailing padding is removed
+	DataTypeASCII DataType = "ascii"
+	// DataTypeBytes is shown as hex
+	DataTypeBytes DataType = "bytes"
+)
+
+// ByteOrder is the endianness of a numeric field
+type ByteOrder string
+
+const (
+	// ByteOrderBigEndian is the default
+	ByteOrderBigEndian    ByteOrder = "big"
+	ByteOrderLittleEndian ByteOrder = "little"
+)
+
+// EnumTable names the raw values of a field. JSON keys are decimal or 0x prefixed hex.
+type EnumTable map[int64]string
+
+func (e *EnumTable) UnmarshalJSON(data []byte) error {
+	var names map[string]string
+	err := json.Unmarshal(data, &names)
+	if err != nil {
+		return err
+	}
+	*e = make(EnumTable, len(names))
+	for key, name := range names {
+		value, err := strconv.ParseInt(key, 0, 64)
+		if err != nil {
+			return fmt.Errorf("invalid enum value %q", key)
+		}
+		(*e)[value] = name
+	}
+	return nil
+}
+
+// Field describes how to decode one value in an identifier's record.
+type Field struct {
+	Name      string    `json:"name"`
+	Unit      string    `json:"unit,omitempty"`
+	Type      DataType  `json:"type,omitempty"`
+	ByteOrder ByteOrder `json:"byteOrder,omitempty"`
+	// Position is the offset of the value in the record
+	Position int `json:"position,omitempty"`
+	// Length is the number of bytes in the value, 0 reads to the end of the record
+	Length int `json:"length,omitempty"`
+	// Numeric values are raw * Scale + Offset, a Scale of 0 is treated as 1
+	Scale    float64 `json:"scale,omitempty"`
+	Offset   float64 `json:"offset,omitempty"`
+	Decimals int     `json:"decimals,omitempty"`
+	// Enum names raw values, named values are shown by name
+	Enum EnumTable `json:"enum,omitempty"`
+}
+
+// IsNumeric returns true if the field decodes to a number.
+func (f Field) IsNumeric() bool {
+	return f.Type != DataTypeASCII && f.Type != DataTypeBytes
+}
+
+// Decode returns the value of the field in record.
+func (f Field) Decode(record []byte) (DecodedValue, error) {
+	end := f.Position + f.Length
+	if f.Length == 0 {
+		end = len(record)
+	}
+	if f.Position < 0 || f.Position > end || end > len(record) {
+		return DecodedValue{}, fmt.Errorf("%s needs %d bytes but the record has %d", f.Name, end, len(record))
+	}
+	data := record[f.Position:end]
+	value := DecodedValue{Field: f}
+	switch f.Type {
+	case DataTypeASCII:
+		value.Text = decodeASCII(data)
+		return value, nil
+	case DataTypeBytes:
+		value.Text = strings.ToUpper(hex.EncodeToString(data))
+		return value, nil
+	}
+
+	// Numeric values are read as big endian
+	if f.ByteOrder == ByteOrderLittleEndian {
+		data = slices.Clone(data)
+		slices.Reverse(data)
+	}
+	if len(data) == 0 || len(data) > 8 {
+		return DecodedValue{}, fmt.Errorf("%s can't be a %d byte number", f.Name, len(data))
+	}
+	var raw uint64
+	for _, b := range data {
+		raw = raw<<8 | uint64(b)
+	}
+	var number float64
+	switch f.Type {
+	case DataTypeUnsigned, "":
+		number = float64(raw)
+	case DataTypeSigned:
+		// Sign extend from the length of the value
+		shift := 64 - 8*len(data)
+		number = float64(int64(raw<<shift) >> shift)
+	case DataTypeFloat:
+		switch len(data) {
+		case 4:
+			number = float64(math.Float32frombits(uint32(raw)))
+		case 8:
+			number = math.Float64frombits(raw)
+		default:
+			return DecodedValue{}, fmt.Errorf("%s can't be a %d byte float", f.Name, len(data))
+		}
+	default:
+		return DecodedValue{}, fmt.Errorf("%s has unknown type %q", f.Name, f.Type)
+	}
+	if name, ok := f.Enum[int64(raw)]; ok {
+		value.Text = name
+	}
+	scale := f.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	value.Value = number*scale + f.Offset
+	return value, nil
+}
+
+// Encode writes value into the field's bytes in record, which must be long enough to hold the field.
+func (f Field) Encode(record []byte, value float64) error {
+	if !f.IsNumeric() {
+		return fmt.Errorf("%s isn't a number", f.Name)
+	}
+	if f.Length <= 0 || f.Length > 8 {
+		return fmt.Errorf("%s can't be a %d byte number", f.Name, f.Length)
+	}
+	if f.Position < 0 || f.Position+f.Length > len(record) {
+		return fmt.Errorf("%s needs %d bytes but the record has %d", f.Name, f.Position+f.Length, len(record))
+	}
+	scale := f.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	number := (value - f.Offset) / scale
+	bits := 8 * f.Length
+	var raw uint64
+	switch f.Type {
+	case DataTypeUnsigned, "":
+		number = math.Round(number)
+		if number < 0 || number > math.Ldexp(1, bits)-1 {
+			return fmt.Errorf("%v is out of range for %s", value, f.Name)
+		}
+		raw = uint64(number)
+	case DataTypeSigned:
+		number = math.Round(number)
+		if number < -math.Ldexp(1, bits-1) || number > math.Ldexp(1, bits-1)-1 {
+			return fmt.Errorf("%v is out of range for %s", value, f.Name)
+		}
+		raw = uint64(int64(number))
+	case DataTypeFloat:
+		switch f.Length {
+		case 4:
+			raw = uint64(math.Float32bits(float32(number)))
+		case 8:
+			raw = math.Float64bits(number)
+		default:
+			return fmt.Errorf("%s can't be a %d byte float", f.Name, f.Length)
+		}
+	default:
+		return fmt.Errorf("%s has unknown type %q", f.Name, f.Type)
+	}
+	data := record[f.Position : f.Position+f.Length]
+	for i := len(data) - 1; i >= 0; i-- {
+		data[i] = byte(raw)
+		raw >>= 8
+	}
+	if f.ByteOrder == ByteOrderLittleEndian {
+		slices.Reverse(data)
+	}
+	return nil
+}
+
+// decodeASCII returns the printable characters of data without trailing padding.
+func decodeASCII(data []byte) string {
+	var text strings.Builder
+	for _, b := range data {
+		if unicode.IsPrint(rune(b)) {
+			text.WriteByte(b)
+		}
+	}
+	return strings.TrimRight(text.String(), " ")
+}
+
+// DecodedValue is a field value in engineering units.
+type DecodedValue struct {
+	Field
+	Value float64
+	// Text is set for text fields and named enum values
+	Text string
+	// Raw is set instead of Value when the value couldn't be decoded
+	Raw []byte
+}
+
+// String returns the name, value and unit, e.g. "Engine Speed: 4250 rpm".
+func (v DecodedValue) String() string {
+	return fmt.Sprintf("%s: %s", v.Name, v.FormattedValue())
+}
+
+// FormattedValue returns the value and unit, e.g. "4250 rpm".
+func (v DecodedValue) FormattedValue() string {
+	switch {
+	case v.Raw != nil:
+		return fmt.Sprintf("0x%X", v.Raw)
+	case v.Text != "" || !v.IsNumeric():
+		return v.Text
+	case v.Unit == "":
+		return fmt.Sprintf("%.*f", v.Decimals, v.Value)
+	default:
+		return fmt.Sprintf("%.*f %s", v.Decimals, v.Value, v.Unit)
+	}
+}
+
+// Identifier describes the record read from a data identifier, local identifier or identification option.
+type Identifier struct {
+	Source IdentifierSource
+	ID     uint16
+	Name   string
+	Fields []Field
+	// Writable data identifiers can be changed with WriteDataByIdentifier after unlocking SecurityLevel
+	Writable      bool
+	SecurityLevel seedkey.SecurityLevel
+}
+
+func (i *Identifier) UnmarshalJSON(data []byte) error {
+	var definition struct {
+		Source IdentifierSource `json:"source"`
+		ID     string           `json:"id"`
+		Name   string           `json:"name"`
+		Fields []Field          `json:"fields"`
+		// Writable and SecurityLevel only apply to data identifiers
+		Writable      bool                  `json:"writable"`
+		SecurityLevel seedkey.SecurityLevel `json:"securityLevel"`
+	}
+	err := json.Unmarshal(data, &definition)
+	if err != nil {
+		return err
+	}
+	id, err := strconv.ParseUint(definition.ID, 0, 16)
+	if err != nil {
+		return fmt.Errorf("invalid id %q in %s", definition.ID, definition.Name)
+	}
+	switch definition.Source {
+	case SourceDataIdentifier:
+	case SourceLocalIdentifier, SourceECUIdentification, SourceOBDPID:
+		if id > 0xFF {
+			return fmt.Errorf("%s id 0x%X is longer than 1 byte", definition.Name, id)
+		}
+	default:
+		return fmt.Errorf("unknown source %q in %s", definition.Source, definition.Name)
+	}
+	// A single field identifier doesn't need to repeat its name
+	for j := range definition.Fields {
+		if definition.Fields[j].Name == "" {
+			definition.Fields[j].Name = definition.Name
+		}
+	}
+	if definition.Writable && definition.Source != SourceDataIdentifier {
+		return fmt.Errorf("%s can't be writable, only data identifiers can be written", definition.Name)
+	}
+	*i = Identifier{
+		Source:        definition.Source,
+		ID:            uint16(id),
+		Name:          definition.Name,
+		Fields:        definition.Fields,
+		Writable:      definition.Writable,
+		SecurityLevel: definition.SecurityLevel,
+	}
+	return nil
+}
+
+// Length returns the number of bytes in the record, or 0 if a field reads to the end of the record.
+func (i Identifier) Length() int {
+	length := 0
+	for _, field := range i.Fields {
+		if field.Length == 0 {
+			return 0
+		}
+		length = max(length, field.Position+field.Length)
+	}
+	return length
+}
+
+// Decode returns the value of every field in record.
+func (i Identifier) Decode(record []byte) ([]DecodedValue, error) {
+	values := make([]DecodedValue, 0, len(i.Fields))
+	for _, field := range i.Fields {
+		value, err := field.Decode(record)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// Definitions are the identifiers, routines and actuators of an ECU family.
+type Definitions struct {
+	ECU         seedkey.ECUType `json:"ecu"`
+	Identifiers []Identifier    `json:"identifiers"`
+	Routines    []Routine       `json:"routines"`
+	Actuators   []Actuator      `json:"actuators"`
+}
+
+// Lookup returns the identifier read from source with id.
+func (d *Definitions) Lookup(source IdentifierSource, id uint16) (Identifier, bool) {
+	for _, identifier := range d.Identifiers {
+		if identifier.Source == source && identifier.ID == id {
+			return identifier, true
+		}
+	}
+	return Identifier{}, false
+}
+
+// LookupField returns the identifier read from source with a field called name, along with the field.
+func (d *Definitions) LookupField(source IdentifierSource, name string) (Identifier, Field, bool) {
+	for _, identifier := range d.BySource(source) {
+		for _, field := range identifier.Fields {
+			if strings.EqualFold(field.Name, name) {
+				return identifier, field, true
+			}
+		}
+	}
+	return Identifier{}, Field{}, false
+}
+
+// LookupRoutine returns the routine with id.
+func (d *Definitions) LookupRoutine(id uint16) (Routine, bool) {
+	for _, routine := range d.Routines {
+		if routine.ID == id {
+			return routine, true
+		}
+	}
+	return Routine{}, false
+}
+
+// LookupActuator returns the actuator with id.
+func (d *Definitions) LookupActuator(id uint16) (Actuator, bool) {
+	for _, actuator := range d.Actuators {
+		if actuator.ID == id {
+			return actuator, true
+		}
+	}
+	return Actuator{}, false
+}
+
+// BySource returns the identifiers read from source.
+func (d *Definitions) BySource(source IdentifierSource) []Identifier {
+	var identifiers []Identifier
+	for _, identifier := range d.Identifiers {
+		if identifier.Source == source {
+			identifiers = append(identifiers, identifier)
+		}
+	}
+	return identifiers
+}
+
+// DataIdentifierLengths returns the length of every fixed length data identifier, as needed to read snapshot records.
+func (d *Definitions) DataIdentifierLengths() map[uint16]int {
+	lengths := make(map[uint16]int)
+	for _, identifier := range d.BySource(SourceDataIdentifier) {
+		if length := identifier.Length(); length > 0 {
+			lengths[identifier.ID] = length
+		}
+	}
+	return lengths
+}
+
+// DecodeDataIdentifiers decodes every value, values without a definition or that can't be decoded are returned raw.
+func (d *Definitions) DecodeDataIdentifiers(values []DataIdentifierValue) []DecodedValue {
+	var decoded []DecodedValue
+	for _, value := range values {
+		identifier, ok := d.Lookup(SourceDataIdentifier, value.ID)
+		if ok {
+			fields, err := identifier.Decode(value.Data)
+			if err == nil {
+				decoded = append(decoded, fields...)
+				continue
+			}
+		}
+		name := identifier.Name
+		if name == "" {
+			name = fmt.Sprintf("DID 0x%04X", value.ID)
+		}
+		decoded = append(decoded, DecodedValue{Field: Field{Name: name}, Raw: value.Data})
+	}
+	return decoded
+}
+
+//go:embed definitions/*.json
+var defaultDefinitions embed.FS
+
+var (
+	definitionsLock    sync.RWMutex
+	definitions        = make(map[seedkey.ECUType]*Definitions)
+	messageDefinitions = make(map[uint16]seedkey.ECUType)
+)
+
+var errorMissingECU = errors.New("definitions must name their ecu")
+
+func init() {
+	paths, err := fs.Glob(defaultDefinitions, "definitions/*.json")
+	if err != nil {
+		panic(err)
+	}
+	for _, path := range paths {
+		data, err := defaultDefinitions.ReadFile(path)
+		if err == nil {
+			err = parseDefinitions(data)
+		}
+		if err != nil {
+			panic(fmt.Sprintf("invalid built in definitions %s: %v", path, err))
+		}
+	}
+}
+
+// RegisterDefinitions adds identifiers, routines and actuators to an ECU family, replacing any with the same source and id.
+func RegisterDefinitions(d Definitions) {
+	definitionsLock.Lock()
+	defer definitionsLock.Unlock()
+	existing, ok := definitions[d.ECU]
+	if !ok {
+		existing = &Definitions{ECU: d.ECU}
+		definitions[d.ECU] = existing
+	}
+	for _, identifier := range d.Identifiers {
+		index := slices.IndexFunc(existing.Identifiers, func(i Identifier) bool {
+			return i.Source == identifier.Source && i.ID == identifier.ID
+		})
+		if index >= 0 {
+			existing.Identifiers[index] = identifier
+		} else {
+			existing.Identifiers = append(existing.Identifiers, identifier)
+		}
+	}
+	for _, routine := range d.Routines {
+		index := slices.IndexFunc(existing.Routines, func(r Routine) bool {
+			return r.ID == routine.ID
+		})
+		if index >= 0 {
+			existing.Routines[index] = routine
+		} else {
+			existing.Routines = append(existing.Routines, routine)
+		}
+	}
+	for _, actuator := range d.Actuators {
+		index := slices.IndexFunc(existing.Actuators, func(a Actuator) bool {
+			return a.ID == actuator.ID
+		})
+		if index >= 0 {
+			existing.Actuators[index] = actuator
+		} else {
+			existing.Actuators = append(existing.Actuators, actuator)
+		}
+	}
+}
+
+// LookupDefinitions returns the identifiers, routines and actuators of an ECU family, which are empty if none have been registered.
+func LookupDefinitions(ecuType seedkey.ECUType) *Definitions {
+	definitionsLock.RLock()
+	defer definitionsLock.RUnlock()
+	d, ok := definitions[ecuType]
+	if !ok {
+		return &Definitions{ECU: ecuType}
+	}
+	// Copy so later registrations don't race with readers
+	return &Definitions{
+		ECU:         d.ECU,
+		Identifiers: slices.Clone(d.Identifiers),
+		Routines:    slices.Clone(d.Routines),
+		Actuators:   slices.Clone(d.Actuators),
+	}
+}
+
+// LoadDefinitions loads a JSON definition file, e.g.
+//
+//	{"ecu": "K01", "identifiers": [{"source": "data", "id": "0xF40C", "name": "Engine Speed",
+//	  "fields": [{"unit": "rpm", "length": 2, "scale": 0.25}]}]}
+func LoadDefinitions(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	err = parseDefinitions(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// LoadShippedDefinitions loads every .json file in the definitions directory beside the executable.
+func LoadShippedDefinitions() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	paths, err := filepath.Glob(filepath.Join(filepath.Dir(executable), DefinitionsDirectory, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		err = LoadDefinitions(path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseDefinitions(data []byte) error {
+	var d Definitions
+	err := json.Unmarshal(data, &d)
+	if err != nil {
+		return err
+	}
+	if d.ECU == "" {
+		return errorMissingECU
+	}
+	RegisterDefinitions(d)
+	return nil
+}
+
+// ConfigureDefinitions sets the ECU family used to label and decode messages sent from testerID
+// and from the matching responder.
+func ConfigureDefinitions(testerID uint16, ecuType seedkey.ECUType) {
+	definitionsLock.Lock()
+	defer definitionsLock.Unlock()
+	messageDefinitions[testerID] = ecuType
+}
+
+// Identifier returns the definition of the identifier a message reads, if there is one.
+func (m *Message) Identifier() (identifier Identifier, record []byte, ok bool) {
+	testerID := m.SenderID
+	if m.IsResponse {
+		testerID = RequesterID(m.SenderID)
+	}
+	definitionsLock.RLock()
+	ecuType, ok := messageDefinitions[testerID]
+	definitionsLock.RUnlock()
+	if !ok {
+		return Identifier{}, nil, false
+	}
+	source, ok := m.protocol().IdentifierServices[m.ServiceID]
+	if !ok {
+		return Identifier{}, nil, false
+	}
+	var id uint16
+	switch {
+	case source == SourceDataIdentifier && len(m.Data) >= 2:
+		id, record = binary.BigEndian.Uint16(m.Data[:2]), m.Data[2:]
+	case source != SourceDataIdentifier && len(m.Data) >= 1:
+		id, record = uint16(m.Data[0]), m.Data[1:]
+	default:
+		return Identifier{}, nil, false
+	}
+	identifier, ok = LookupDefinitions(ecuType).Lookup(source, id)
+	return identifier, record, ok
+}
+
+// DecodedValues returns the decoded fields of a positive response to a read of a defined identifier.
+func (m *Message) DecodedValues() []DecodedValue {
+	if !m.IsResponse || m.IsPositive == nil || !*m.IsPositive {
+		return nil
+	}
+	identifier, record, ok := m.Identifier()
+	if !ok {
+		return nil
+	}
+	values, err := identifier.Decode(record)
+	if err != nil {
+		return nil
+	}
+	return values
+}
@@ -0,0 +1,262 @@
+package uds
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// DTCStatus holds the 8 DTC status bits defined by ISO 14229
+type DTCStatus byte
+
+const (
+	DTCStatusTestFailed                         DTCStatus = 0x01
+	DTCStatusTestFailedThisOperationCycle       DTCStatus = 0x02
+	DTCStatusPending                            DTCStatus = 0x04
+	DTCStatusConfirmed                          DTCStatus = 0x08
+	DTCStatusTestNotCompletedSinceLastClear     DTCStatus = 0x10
+	DTCStatusTestFailedSinceLastClear           DTCStatus = 0x20
+	DTCStatusTestNotCompletedThisOperationCycle DTCStatus = 0x40
+	DTCStatusWarningIndicatorRequested          DTCStatus = 0x80
+	// DTCStatusMaskAll matches every DTC the ECU has a status for
+	DTCStatusMaskAll DTCStatus = 0xFF
+)
+
+var dtcStatusNames = []struct {
+	status DTCStatus
+	name   string
+}{
+	{DTCStatusTestFailed, "Test Failed"},
+	{DTCStatusTestFailedThisOperationCycle, "Test Failed This Operation Cycle"},
+	{DTCStatusPending, "Pending"},
+	{DTCStatusConfirmed, "Confirmed"},
+	{DTCStatusTestNotCompletedSinceLastClear, "Test Not Completed Since Last Clear"},
+	{DTCStatusTestFailedSinceLastClear, "Test Failed Since Last Clear"},
+	{DTCStatusTestNotCompletedThisOperationCycle, "Test Not Completed This Operation Cycle"},
+	{DTCStatusWarningIndicatorRequested, "MIL On"},
+}
+
+func (s DTCStatus) TestFailed() bool {
+	return s&DTCStatusTestFailed != 0
+}
+
+func (s DTCStatus) TestFailedThisOperationCycle() bool {
+	return s&DTCStatusTestFailedThisOperationCycle != 0
+}
+
+func (s DTCStatus) Pending() bool {
+	return s&DTCStatusPending != 0
+}
+
+func (s DTCStatus) Confirmed() bool {
+	return s&DTCStatusConfirmed != 0
+}
+
+func (s DTCStatus) TestNotCompletedSinceLastClear() bool {
+	return s&DTCStatusTestNotCompletedSinceLastClear != 0
+}
+
+func (s DTCStatus) TestFailedSinceLastClear() bool {
+	return s&DTCStatusTestFailedSinceLastClear != 0
+}
+
+func (s DTCStatus) TestNotCompletedThisOperationCycle() bool {
+	return s&DTCStatusTestNotCompletedThisOperationCycle != 0
+}
+
+func (s DTCStatus) WarningIndicatorRequested() bool {
+	return s&DTCStatusWarningIndicatorRequested != 0
+}
+
+// String returns the names of the set status bits.
+func (s DTCStatus) String() string {
+	var names []string
+	for _, status := range dtcStatusNames {
+		if s&status.status != 0 {
+			names = append(names, status.name)
+		}
+	}
+	if len(names) == 0 {
+		return "N/A"
+	}
+	return strings.Join(names, ", ")
+}
+
+// DTC is a diagnostic trouble code along with its status and any records read for it.
+type DTC struct {
+	// Code is the 3 byte DTC, the high 2 bytes are the SAE J2012 code and the low byte is the failure type
+	Code         uint32
+	Status       DTCStatus
+	Snapshots    []DTCSnapshotRecord
+	ExtendedData []DTCExtendedDataRecord
+}
+
+// DTCSnapshotRecord holds the data identifiers the ECU stored when a DTC was set, also called a freeze frame.
+type DTCSnapshotRecord struct {
+	Number byte
+	Values []DataIdentifierValue
+}
+
+// DataIdentifierValue is the raw value of a data identifier.
+type DataIdentifierValue struct {
+	ID   uint16
+	Data []byte
+}
+
+// DTCExtendedDataRecord holds ECU specific data about a DTC such as occurrence and aging counters.
+type DTCExtendedDataRecord struct {
+	Number byte
+	Data   []byte
+}
+
+// NewReadDTCInformationRequest creates a ReadDTCInformation request.
+func NewReadDTCInformationRequest(senderID uint16, subfunction byte, data ...byte) *Message {
+	return &Message{
+		SenderID:    senderID,
+		ServiceID:   ServiceReadDTCInformation,
+		Subfunction: &subfunction,
+		Data:        data,
+	}
+}
+
+// ReadNumberOfDTCByStatusMask returns the number of DTCs with any of the status bits in mask set.
+func ReadNumberOfDTCByStatusMask(ctx context.Context, r Requester, senderID uint16, mask DTCStatus) (int, error) {
+	resp, err := r.Request(ctx, NewReadDTCInformationRequest(senderID, SubfunctionReportNumberOfDTCByStatusMask, byte(mask)))
+	if err != nil {
+		return 0, err
+	}
+	// Data holds the echoed subfunction, status availability mask, DTC format identifier and a 2 byte count
+	if len(resp.Data) != 5 {
+		return 0, fmt.Errorf("invalid number of DTC response length %d", len(resp.Data))
+	}
+	return int(binary.BigEndian.Uint16(resp.Data[3:5])), nil
+}
+
+// ReadDTCByStatusMask returns the DTCs with any of the status bits in mask set.
+func ReadDTCByStatusMask(ctx context.Context, r Requester, senderID uint16, mask DTCStatus) ([]DTC, error) {
+	resp, err := r.Request(ctx, NewReadDTCInformationRequest(senderID, SubfunctionReportDTCByStatusMask, byte(mask)))
+	if err != nil {
+		return nil, err
+	}
+	return parseDTCList(resp)
+}
+
+// ReadSupportedDTCs returns every DTC the ECU supports, whatever its status.
+func ReadSupportedDTCs(ctx context.Context, r Requester, senderID uint16) ([]DTC, error) {
+	resp, err := r.Request(ctx, NewReadDTCInformationRequest(senderID, SubfunctionReportSupportedDTC))
+	if err != nil {
+		return nil, err
+	}
+	return parseDTCList(resp)
+}
+
+// ReadDTCSnapshotRecords returns the snapshot records stored for a DTC, 0xFF reads every record.
+// The response doesn't say how long each data identifier is so didLengths must hold the length of each one,
+// only the final value of a response can have an unknown length.
+func ReadDTCSnapshotRecords(ctx context.Context, r Requester, senderID uint16, code uint32, recordNumber byte, didLengths map[uint16]int) ([]DTCSnapshotRecord, error) {
+	data := append(dtcBytes(code), recordNumber)
+	resp, err := r.Request(ctx, NewReadDTCInformationRequest(senderID, SubfunctionReportDTCSnapshotRecordByDTCNumber, data...))
+	if err != nil {
+		return nil, err
+	}
+	// Data holds the echoed subfunction, the DTC and its status followed by the records
+	records, err := checkDTCRecordResponse(resp, code)
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []DTCSnapshotRecord
+	for len(records) > 0 {
+		if len(records) < 2 {
+			return nil, fmt.Errorf("truncated snapshot record")
+		}
+		snapshot := DTCSnapshotRecord{Number: records[0]}
+		count := int(records[1])
+		records = records[2:]
+		for i := 0; i < count; i++ {
+			if len(records) < 2 {
+				return nil, fmt.Errorf("truncated snapshot record 0x%02X", snapshot.Number)
+			}
+			id := binary.BigEndian.Uint16(records[:2])
+			records = records[2:]
+			length, ok := didLengths[id]
+			if !ok {
+				// Only the last value can be read without knowing its length
+				if i != count-1 {
+					return nil, fmt.Errorf("unknown length for data identifier 0x%04X", id)
+				}
+				length = len(records)
+			}
+			if len(records) < length {
+				return nil, fmt.Errorf("truncated value for data identifier 0x%04X", id)
+			}
+			snapshot.Values = append(snapshot.Values, DataIdentifierValue{ID: id, Data: records[:length]})
+			records = records[length:]
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+// ReadDTCExtendedData returns the extended data records stored for a DTC, 0xFF reads every record.
+// When reading every record recordLengths must hold the length of each record except the last.
+func ReadDTCExtendedData(ctx context.Context, r Requester, senderID uint16, code uint32, recordNumber byte, recordLengths map[byte]int) ([]DTCExtendedDataRecord, error) {
+	data := append(dtcBytes(code), recordNumber)
+	resp, err := r.Request(ctx, NewReadDTCInformationRequest(senderID, SubfunctionReportDTCExtDataRecordByDTCNumber, data...))
+	if err != nil {
+		return nil, err
+	}
+	records, err := checkDTCRecordResponse(resp, code)
+	if err != nil {
+		return nil, err
+	}
+	var extendedData []DTCExtendedDataRecord
+	for len(records) > 0 {
+		record := DTCExtendedDataRecord{Number: records[0]}
+		records = records[1:]
+		length, ok := recordLengths[record.Number]
+		if !ok {
+			length = len(records)
+		}
+		if len(records) < length {
+			return nil, fmt.Errorf("truncated extended data record 0x%02X", record.Number)
+		}
+		record.Data = records[:length]
+		records = records[length:]
+		extendedData = append(extendedData, record)
+	}
+	return extendedData, nil
+}
+
+// parseDTCList decodes the DTC and status pairs in a report DTC by status mask or report supported DTC response.
+func parseDTCList(resp *Message) ([]DTC, error) {
+	// Data holds the echoed subfunction and status availability mask followed by 4 bytes per DTC
+	if len(resp.Data) < 2 || (len(resp.Data)-2)%4 != 0 {
+		return nil, fmt.Errorf("invalid DTC list response length %d", len(resp.Data))
+	}
+	availability := DTCStatus(resp.Data[1])
+	var dtcs []DTC
+	for i := 2; i < len(resp.Data); i += 4 {
+		dtcs = append(dtcs, DTC{
+			Code:   uint32(resp.Data[i])<<16 | uint32(resp.Data[i+1])<<8 | uint32(resp.Data[i+2]),
+			Status: DTCStatus(resp.Data[i+3]) & availability,
+		})
+	}
+	return dtcs, nil
+}
+
+// checkDTCRecordResponse checks a record response is for code and returns the records.
+func checkDTCRecordResponse(resp *Message, code uint32) ([]byte, error) {
+	if len(resp.Data) < 5 {
+		return nil, fmt.Errorf("DTC record response too short")
+	}
+	responseCode := uint32(resp.Data[1])<<16 | uint32(resp.Data[2])<<8 | uint32(resp.Data[3])
+	if responseCode != code {
+		return nil, fmt.Errorf("response is for DTC %06X not %06X", responseCode, code)
+	}
+	return resp.Data[5:], nil
+}
+
+func dtcBytes(code uint32) []byte {
+	return []byte{byte(code >> 16), byte(code >> 8), byte(code)}
+}
@@ -1,75 +1,190 @@
 package uds
 
 import (
+	_ "embed"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"husk/seedkey"
+)
+
+// DTCFamilyGeneric holds the descriptions used for every ECU type, ECU type specific descriptions take precedence
+const DTCFamilyGeneric seedkey.ECUType = "generic"
+
+// DTCDatabaseName is the name, without extension, of the DTC database loaded from beside the executable
+const DTCDatabaseName = "dtcs"
+
+// dtcLetters are the SAE J2012 system letters selected by the 2 high bits of a DTC
+var dtcLetters = [4]byte{'P', 'C', 'B', 'U'}
+
+//go:embed dtcs.json
+var defaultDTCDatabase []byte
+
+var (
+	dtcLock         sync.RWMutex
+	dtcDescriptions = make(map[seedkey.ECUType]map[uint16]string)
 )
 
-var dtcMap = map[string]string{
-	"0105": "Manifold Absolute Pressure/Barometric Pressure Circuit Malfunction",
-	"0110": "Intake Air Temperature Circuit Malfunction",
-	"0115": "Engine Coolant Temperature Circuit Malfunction",
-	"0120": "Throttle Pedal Position Sensor/Switch A Circuit Malfunction",
-	"0220": "Throttle/Pedal Position Sensor/Switch B Circuit Malfunction",
-	"0708": "Transmission Range Sensor Circuit High Input",
-	"1590": "SideStand Sensor Error",
-	"1632": "Module Supply Voltage Out Of Range",
-	"1685": "Metering Oil Pump Malfunction",
-	"2120": "Throttle/Pedal Pos Sensor/Switch D Circuit",
-	"2125": "Throttle/Pedal Pos Sensor/Switch E Circuit",
-	"2226": "Barometric Pressure Circuit",
-	"2803": "Transmission Range Sensor B Circuit High",
-
-	// Common DTC Codes
-	"0001": "Fuel Volume Regulator Control Circuit/Open",
-	"0002": "Fuel Volume Regulator Control Circuit Range/Performance",
-	"0003": "Fuel Volume Regulator Control Circuit Low",
-	"0004": "Fuel Volume Regulator Control Circuit High",
-	"0100": "Mass or Volume Air Flow Circuit Malfunction",
-	"0101": "Mass or Volume Air Flow Circuit Range/Performance Problem",
-	"0102": "Mass or Volume Air Flow Circuit Low Input",
-	"0103": "Mass or Volume Air Flow Circuit High Input",
-	"0112": "Intake Air Temperature Sensor 1 Circuit Low Input",
-	"0113": "Intake Air Temperature Sensor 1 Circuit High Input",
-	"0201": "Injector Circuit Malfunction - Cylinder 1",
-	"0202": "Injector Circuit Malfunction - Cylinder 2",
-	"0300": "Random/Multiple Cylinder Misfire Detected",
-	"0301": "Cylinder 1 Misfire Detected",
-	"0302": "Cylinder 2 Misfire Detected",
-	"0303": "Cylinder 3 Misfire Detected",
-	"0304": "Cylinder 4 Misfire Detected",
-	"0401": "Exhaust Gas Recirculation (EGR) Flow Insufficient Detected",
-	"0402": "Exhaust Gas Recirculation (EGR) Flow Excessive Detected",
-	"0420": "Catalyst System Efficiency Below Threshold (Bank 1)",
-	"0430": "Catalyst System Efficiency Below Threshold (Bank 2)",
-	"0440": "Evaporative Emission Control System Malfunction",
-	"0441": "Evaporative Emission Control System Incorrect Purge Flow",
-	"0442": "Evaporative Emission Control System Leak Detected (small leak)",
-	"0446": "Evaporative Emission Control System Vent Control Circuit Malfunction",
-	"0500": "Vehicle Speed Sensor Malfunction",
-	"0562": "System Voltage Low",
-	"0563": "System Voltage High",
-	"0600": "Serial Communication Link Malfunction",
-	"0705": "Transmission Range Sensor Circuit Malfunction (PRNDL Input)",
-	"0715": "Input/Turbine Speed Sensor Circuit Malfunction",
-	"0720": "Output Speed Sensor Circuit Malfunction",
-	"0730": "Incorrect Gear Ratio",
-	"0740": "Torque Converter Clutch Circuit Malfunction",
-	"0750": "Shift Solenoid A Malfunction",
-	"0755": "Shift Solenoid B Malfunction",
-	"0760": "Shift Solenoid C Malfunction",
-	"0765": "Shift Solenoid D Malfunction",
-	"0850": "Park/Neutral Position (PNP) Switch Circuit Malfunction",
-	"1100": "Engine Coolant Temperature Sensor 1 Circuit Range/Performance",
-	"1120": "Throttle Position Sensor/Switch Circuit Malfunction",
-	"1130": "Throttle Position Sensor Circuit Malfunction",
-	"1237": "Fuel Pump Secondary Circuit Malfunction",
-	"1402": "EGR System - Insufficient Flow Detected",
-	"1500": "Vehicle Speed Sensor A Malfunction",
+func init() {
+	err := parseDTCDatabaseJSON(defaultDTCDatabase)
+	if err != nil {
+		panic(fmt.Sprintf("invalid built in DTC database: %v", err))
+	}
+}
+
+// FormatDTC formats the SAE J2012 part of a DTC, e.g. 0x0105 is P0105 and 0xC155 is U0155.
+func FormatDTC(code uint16) string {
+	return fmt.Sprintf("%c%04X", dtcLetters[code>>14], code&0x3FFF)
+}
+
+// ParseDTC parses a DTC such as P0105. A code without a system letter is read as 4 hex digits.
+func ParseDTC(in string) (uint16, error) {
+	in = strings.ToUpper(strings.TrimSpace(in))
+	if len(in) == 4 {
+		code, err := strconv.ParseUint(in, 16, 16)
+		if err != nil {
+			return 0, fmt.Errorf("invalid DTC %q", in)
+		}
+		return uint16(code), nil
+	}
+	if len(in) != 5 {
+		return 0, fmt.Errorf("invalid DTC %q", in)
+	}
+	system := strings.IndexByte(string(dtcLetters[:]), in[0])
+	// The first digit only has 2 bits
+	if system < 0 || in[1] < '0' || in[1] > '3' {
+		return 0, fmt.Errorf("invalid DTC %q", in)
+	}
+	code, err := strconv.ParseUint(in[1:], 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid DTC %q", in)
+	}
+	return uint16(system)<<14 | uint16(code), nil
+}
+
+// GetDTCLabel returns the formatted DTC followed by its description for ecuType if there is one.
+func GetDTCLabel(ecuType seedkey.ECUType, code uint16) string {
+	if description, ok := DTCDescription(ecuType, code); ok {
+		return fmt.Sprintf("%s: %s", FormatDTC(code), description)
+	}
+	return FormatDTC(code)
+}
+
+// DTCDescription returns the description of a DTC for ecuType, falling back to the generic description.
+func DTCDescription(ecuType seedkey.ECUType, code uint16) (string, bool) {
+	dtcLock.RLock()
+	defer dtcLock.RUnlock()
+	if description, ok := dtcDescriptions[ecuType][code]; ok {
+		return description, true
+	}
+	description, ok := dtcDescriptions[DTCFamilyGeneric][code]
+	return description, ok
+}
+
+// RegisterDTCDescription adds or replaces the description of a DTC for ecuType.
+func RegisterDTCDescription(ecuType seedkey.ECUType, code uint16, description string) {
+	dtcLock.Lock()
+	defer dtcLock.Unlock()
+	if dtcDescriptions[ecuType] == nil {
+		dtcDescriptions[ecuType] = make(map[uint16]string)
+	}
+	dtcDescriptions[ecuType][code] = description
+}
+
+// LoadDTCDatabase adds the descriptions in a .json or .csv file, replacing any already loaded for the same DTC.
+//
+// JSON files map an ECU type to DTC descriptions:
+//
+//	{"generic": {"P0105": "..."}, "K01": {"P1590": "..."}}
+//
+// CSV files have an ecu,code,description header followed by one DTC per row.
+func LoadDTCDatabase(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = parseDTCDatabaseJSON(data)
+	case ".csv":
+		err = parseDTCDatabaseCSV(strings.NewReader(string(data)))
+	default:
+		return fmt.Errorf("unsupported DTC database %s, expected .json or .csv", path)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// LoadShippedDTCDatabase loads dtcs.json and dtcs.csv from the directory of the executable if they exist.
+func LoadShippedDTCDatabase() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	for _, extension := range []string{".json", ".csv"} {
+		err = LoadDTCDatabase(filepath.Join(filepath.Dir(executable), DTCDatabaseName+extension))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseDTCDatabaseJSON(data []byte) error {
+	var database map[seedkey.ECUType]map[string]string
+	err := json.Unmarshal(data, &database)
+	if err != nil {
+		return err
+	}
+	for ecuType, descriptions := range database {
+		for dtc, description := range descriptions {
+			code, err := ParseDTC(dtc)
+			if err != nil {
+				return err
+			}
+			RegisterDTCDescription(ecuType, code, description)
+		}
+	}
+	return nil
 }
 
-func GetDTCLabel(dtcCode string) string {
-	if label, exists := dtcMap[dtcCode]; exists {
-		return fmt.Sprintf("%s: %s", dtcCode, label)
+func parseDTCDatabaseCSV(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(header[0], "ecu") || !strings.EqualFold(header[1], "code") {
+		return fmt.Errorf("expected an ecu,code,description header")
+	}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		code, err := ParseDTC(record[1])
+		if err != nil {
+			return err
+		}
+		ecuType := seedkey.ECUType(record[0])
+		if ecuType == "" {
+			ecuType = DTCFamilyGeneric
+		}
+		RegisterDTCDescription(ecuType, code, record[2])
 	}
-	return dtcCode
 }
@@ -0,0 +1,241 @@
+package uds
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"husk/seedkey"
+)
+
+// InputOutputControlParameter values
+const (
+	IOControlReturnControlToECU  byte = 0x00
+	IOControlResetToDefault      byte = 0x01
+	IOControlFreezeCurrentState  byte = 0x02
+	IOControlShortTermAdjustment byte = 0x03
+)
+
+const (
+	// DefaultActuatorMaxDuration limits actuator tests when their definition doesn't set a maximum duration
+	DefaultActuatorMaxDuration = 10 * time.Second
+	// ReturnControlRetries is the number of times a failed returnControlToECU is resent
+	ReturnControlRetries = 3
+	// actuatorSessionCheckInterval is how often a running test checks the session hasn't been lost
+	actuatorSessionCheckInterval = 100 * time.Millisecond
+)
+
+// ErrActuatorTestSession is returned when an actuator test is started outside the extended session
+// or without the security level it needs.
+var ErrActuatorTestSession = errors.New("actuator tests need the extended session with their security level unlocked")
+
+// NewInputOutputControlByIdentifierRequest creates an InputOutputControlByIdentifier request.
+func NewInputOutputControlByIdentifierRequest(senderID uint16, id uint16, parameter byte, controlState []byte) *Message {
+	data := binary.BigEndian.AppendUint16(nil, id)
+	data = append(data, parameter)
+	return &Message{
+		SenderID:  senderID,
+		ServiceID: ServiceInputOutputControlByIdentifier,
+		Data:      append(data, controlState...),
+	}
+}
+
+// InputOutputControlByIdentifier sends an InputOutputControlByIdentifier request and returns the control status record.
+func InputOutputControlByIdentifier(ctx context.Context, r Requester, senderID uint16, id uint16, parameter byte, controlState []byte) ([]byte, error) {
+	resp, err := r.Request(ctx, NewInputOutputControlByIdentifierRequest(senderID, id, parameter, controlState))
+	if err != nil {
+		return nil, err
+	}
+	// Data holds the echoed data identifier and control parameter followed by the control status record
+	if len(resp.Data) < 3 {
+		return nil, fmt.Errorf("input output control response too short")
+	}
+	if responseID := binary.BigEndian.Uint16(resp.Data[:2]); responseID != id {
+		return nil, fmt.Errorf("response is for data identifier 0x%04X not 0x%04X", responseID, id)
+	}
+	return resp.Data[3:], nil
+}
+
+// Actuator is an output an ECU lets the tester drive with InputOutputControlByIdentifier.
+type Actuator struct {
+	ID          uint16
+	Name        string
+	Description string
+	// SecurityLevel must be unlocked in the extended session before the actuator is driven
+	SecurityLevel seedkey.SecurityLevel
+	// Parameters are encoded into the control state sent with shortTermAdjustment
+	Parameters []Parameter
+	// MaxDuration is the longest a test may drive the actuator before control is returned to the ECU
+	MaxDuration time.Duration
+}
+
+func (a *Actuator) UnmarshalJSON(data []byte) error {
+	var definition struct {
+		ID            string                `json:"id"`
+		Name          string                `json:"name"`
+		Description   string                `json:"description"`
+		SecurityLevel seedkey.SecurityLevel `json:"securityLevel"`
+		Parameters    []Parameter           `json:"parameters"`
+		MaxDuration   string                `json:"maxDuration"`
+	}
+	err := json.Unmarshal(data, &definition)
+	if err != nil {
+		return err
+	}
+	id, err := strconv.ParseUint(definition.ID, 0, 16)
+	if err != nil {
+		return fmt.Errorf("invalid id %q in %s", definition.ID, definition.Name)
+	}
+	maxDuration := DefaultActuatorMaxDuration
+	if definition.MaxDuration != "" {
+		maxDuration, err = time.ParseDuration(definition.MaxDuration)
+		if err != nil || maxDuration <= 0 {
+			return fmt.Errorf("invalid max duration %q in %s", definition.MaxDuration, definition.Name)
+		}
+	}
+	err = validateParameters(definition.Name, definition.Parameters)
+	if err != nil {
+		return err
+	}
+	*a = Actuator{
+		ID:            uint16(id),
+		Name:          definition.Name,
+		Description:   definition.Description,
+		SecurityLevel: definition.SecurityLevel,
+		Parameters:    definition.Parameters,
+		MaxDuration:   maxDuration,
+	}
+	return nil
+}
+
+// EncodeParameters returns the control state holding values, by parameter name. Parameters without a value use their default.
+func (a Actuator) EncodeParameters(values map[string]float64) ([]byte, error) {
+	return encodeParameters(a.Name, a.Parameters, values)
+}
+
+// ActuatorTest drives an actuator until its duration passes, it is stopped, its context is done or the session
+// is lost, then returns control to the ECU.
+type ActuatorTest struct {
+	Actuator Actuator
+	sessions *SessionManager
+	senderID uint16
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	// err is why the test ended early, or the error returning control to the ECU
+	err error
+}
+
+// StartActuatorTest drives actuator with the control state holding values for duration, which is limited to the
+// actuator's maximum duration, 0 running it for the maximum. The session manager must already be in the extended
+// session with the actuator's security level unlocked.
+func StartActuatorTest(ctx context.Context, m *SessionManager, senderID uint16, actuator Actuator, values map[string]float64, duration time.Duration) (*ActuatorTest, error) {
+	controlState, err := actuator.EncodeParameters(values)
+	if err != nil {
+		return nil, err
+	}
+	if !actuatorSessionValid(m, actuator) {
+		return nil, ErrActuatorTestSession
+	}
+	maxDuration := actuator.MaxDuration
+	if maxDuration <= 0 {
+		maxDuration = DefaultActuatorMaxDuration
+	}
+	if duration <= 0 || duration > maxDuration {
+		duration = maxDuration
+	}
+	t := &ActuatorTest{
+		Actuator: actuator,
+		sessions: m,
+		senderID: senderID,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	_, err = InputOutputControlByIdentifier(ctx, m, senderID, actuator.ID, IOControlShortTermAdjustment, controlState)
+	if err != nil {
+		// The ECU may have acted on the request even if the response was lost
+		_ = t.returnControl(ctx)
+		return nil, err
+	}
+	go t.run(ctx, duration)
+	return t, nil
+}
+
+// Stop ends the test early and waits for control to be returned to the ECU. It returns the error
+// returning control, if any.
+func (t *ActuatorTest) Stop() error {
+	t.stopOnce.Do(func() { close(t.stop) })
+	<-t.done
+	return t.Err()
+}
+
+// Done is closed once control has been returned to the ECU.
+func (t *ActuatorTest) Done() <-chan struct{} {
+	return t.done
+}
+
+// Err returns why the test ended early or the error returning control to the ECU, once Done is closed.
+func (t *ActuatorTest) Err() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
+
+func (t *ActuatorTest) run(ctx context.Context, duration time.Duration) {
+	defer close(t.done)
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	ticker := time.NewTicker(actuatorSessionCheckInterval)
+	defer ticker.Stop()
+	var reason error
+wait:
+	for {
+		select {
+		case <-timer.C:
+			break wait
+		case <-t.stop:
+			break wait
+		case <-ctx.Done():
+			reason = ctx.Err()
+			break wait
+		case <-ticker.C:
+			// The ECU ends every actuator test when it leaves the session, make sure we notice
+			if !actuatorSessionValid(t.sessions, t.Actuator) {
+				reason = ErrActuatorTestSession
+				break wait
+			}
+		}
+	}
+	err := t.returnControl(ctx)
+	t.err = errors.Join(reason, err)
+}
+
+// returnControl hands the actuator back to the ECU, retrying if the request fails. It is sent even
+// if ctx is done since that is when it matters most.
+func (t *ActuatorTest) returnControl(ctx context.Context) (err error) {
+	ctx = context.WithoutCancel(ctx)
+	for attempt := 0; attempt <= ReturnControlRetries; attempt++ {
+		_, err = InputOutputControlByIdentifier(ctx, t.sessions, t.senderID, t.Actuator.ID, IOControlReturnControlToECU, nil)
+		if err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to return control of %s to the ECU: %w", t.Actuator.Name, err)
+}
+
+// actuatorSessionValid returns true if the session manager is in the extended session with the
+// actuator's security level unlocked.
+func actuatorSessionValid(m *SessionManager, actuator Actuator) bool {
+	if m.Session() != SubfunctionExtendedDiagnosticSession {
+		return false
+	}
+	return actuator.SecurityLevel == seedkey.SecurityLevelUnspecified || m.SecurityLevel() == actuator.SecurityLevel
+}
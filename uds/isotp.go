@@ -0,0 +1,102 @@
+package uds
+
+import (
+	"sync"
+)
+
+// ISOTPConfig configures the ISO 15765-2 transport used to talk to an ECU.
+type ISOTPConfig struct {
+	// BlockSize is the number of consecutive frames the ECU may send before waiting for another flow control frame.
+	// 0 lets the ECU send every consecutive frame without waiting.
+	BlockSize byte
+	// SeparationTime is the minimum time between consecutive frames sent by the ECU (STmin)
+	SeparationTime byte
+	// Padding fills every frame sent to 8 bytes with PaddingByte
+	Padding     bool
+	PaddingByte byte
+	// MaxMessageLength is the largest message accepted, larger first frames are answered with an overflow
+	MaxMessageLength uint32
+}
+
+const (
+	// DefaultMaxMessageLength allows ROM uploads larger than a 12 bit first frame length
+	DefaultMaxMessageLength uint32 = 1 << 20
+	// maxFirstFrameLength is the largest length that fits in a first frame without the escape sequence
+	maxFirstFrameLength = 0xFFF
+)
+
+// Physical response IDs defined by ISO 15765-4
+const (
+	PhysicalResponseIDMin uint16 = 0x7E8
+	PhysicalResponseIDMax uint16 = 0x7EF
+)
+
+var (
+	transportConfigs     = make(map[uint16]ISOTPConfig)
+	transportConfigsLock sync.RWMutex
+	// receptions holds the responder IDs a multi frame message is currently being received from
+	receptions     = make(map[uint16]struct{})
+	receptionsLock sync.RWMutex
+)
+
+// DefaultISOTPConfig returns the transport configuration used for ECUs that haven't configured their own.
+func DefaultISOTPConfig() ISOTPConfig {
+	return ISOTPConfig{
+		BlockSize:        0,
+		SeparationTime:   0x0A,
+		MaxMessageLength: DefaultMaxMessageLength,
+	}
+}
+
+// ConfigureTransport sets the transport configuration used when sending from testerID and when
+// receiving messages from the matching responder.
+func ConfigureTransport(testerID uint16, config ISOTPConfig) {
+	transportConfigsLock.Lock()
+	defer transportConfigsLock.Unlock()
+	transportConfigs[testerID] = config
+}
+
+// getTransportConfig returns the transport configuration for testerID.
+func getTransportConfig(testerID uint16) ISOTPConfig {
+	transportConfigsLock.RLock()
+	defer transportConfigsLock.RUnlock()
+	if config, ok := transportConfigs[testerID]; ok {
+		return config
+	}
+	return DefaultISOTPConfig()
+}
+
+// ResponderID returns the CAN ID a physically addressed request sent from senderID is answered on.
+// ISO 15765-4 pairs request IDs 0x7E0-0x7E7 with response IDs 0x7E8-0x7EF.
+func ResponderID(senderID uint16) uint16 {
+	return senderID + 8
+}
+
+// RequesterID returns the CAN ID the tester uses to talk to the ECU responding on responderID.
+func RequesterID(responderID uint16) uint16 {
+	return responderID - 8
+}
+
+// isPhysicalResponseID reports whether id is a physical response ID, only these get flow control frames.
+func isPhysicalResponseID(id uint16) bool {
+	return id >= PhysicalResponseIDMin && id <= PhysicalResponseIDMax
+}
+
+// setReceiving marks a multi frame reception from responderID as started or finished.
+func setReceiving(responderID uint16, receiving bool) {
+	receptionsLock.Lock()
+	defer receptionsLock.Unlock()
+	if receiving {
+		receptions[responderID] = struct{}{}
+		return
+	}
+	delete(receptions, responderID)
+}
+
+// IsReceiving reports whether a multi frame message is currently being received from responderID.
+func IsReceiving(responderID uint16) bool {
+	receptionsLock.RLock()
+	defer receptionsLock.RUnlock()
+	_, ok := receptions[responderID]
+	return ok
+}
@@ -1,20 +1,67 @@
 package uds
 
-// Service IDs
+// Routine Ids
 
 const (
-	ServiceReadIdK01      byte = 0x1A
-	ServiceReadErrorsK01  byte = 0x03
-	ServiceClearErrorsK01 byte = 0x04
+	// RoutineCheckMemoryK01 compares the CRC32 of a memory region with the expected CRC32 passed in the request
+	RoutineCheckMemoryK01 uint16 = 0x0202
+	// RoutineThrottleAdaptationResetK01 relearns the closed throttle position and reports it
+	RoutineThrottleAdaptationResetK01 uint16 = 0x0203
+	// RoutineInjectorTestK01 fires the injector a number of times with a pulse width in units of 0.01 ms
+	RoutineInjectorTestK01 uint16 = 0x0204
+	// RoutineFuelPumpPrimeK01 runs the fuel pump for a number of seconds
+	RoutineFuelPumpPrimeK01 uint16 = 0x0205
 )
 
-// ReadId Subfunctions
+// Data Identifiers
 
+// Data identifiers read live and stored in freeze frames, these are the OBD PIDs mapped to 0xF4xx by ISO 14229
 const (
-	SubfunctionReadVINK01           byte = 0x01
-	SubfunctionReadECUHardwareIdK01 byte = 0x02
-	SubfunctionReadECUSoftwareIdK01 byte = 0x05
-	SubfunctionReadCountryK01       byte = 0x06
-	SubfunctionReadManufacturerK01  byte = 0x07
-	SubfunctionReadModelK01         byte = 0x08
+	DIDCoolantTemperatureK01 uint16 = 0xF405
+	DIDEngineSpeedK01        uint16 = 0xF40C
+	DIDThrottlePositionK01   uint16 = 0xF411
+	DIDBatteryVoltageK01     uint16 = 0xF442
 )
+
+// Data identifiers holding settings that can be changed with WriteDataByIdentifier
+const (
+	// DIDIdleSpeedTargetK01 is the idle speed in rpm
+	DIDIdleSpeedTargetK01 uint16 = 0x0100
+	// DIDServiceCounterK01 is the engine hours since the last service in units of 0.1 hours
+	DIDServiceCounterK01 uint16 = 0x0101
+)
+
+// Data identifiers of the actuators driven with InputOutputControlByIdentifier
+const (
+	// DIDRadiatorFanK01 is the fan duty cycle
+	DIDRadiatorFanK01 uint16 = 0x0300
+	// DIDFuelPumpK01 is on (1) or off (0)
+	DIDFuelPumpK01 uint16 = 0x0301
+	// DIDInjectorK01 is the injector pulse width in units of 0.01 ms, fired once per 100 ms
+	DIDInjectorK01 uint16 = 0x0302
+	// DIDIgnitionCoilK01 is the coil dwell time in units of 0.01 ms, fired once per 100 ms
+	DIDIgnitionCoilK01 uint16 = 0x0303
+	// DIDExhaustValveK01 is the exhaust flap position
+	DIDExhaustValveK01 uint16 = 0x0304
+)
+
+// DTC extended data records
+
+const (
+	// ExtDataRecordOccurrenceCounterK01 counts the operation cycles the DTC failed in
+	ExtDataRecordOccurrenceCounterK01 byte = 0x01
+	// ExtDataRecordAgingCounterK01 counts the operation cycles since the DTC last failed
+	ExtDataRecordAgingCounterK01 byte = 0x02
+)
+
+// ExtDataRecordLengthsK01 are the lengths of the DTC extended data records
+var ExtDataRecordLengthsK01 = map[byte]int{
+	ExtDataRecordOccurrenceCounterK01: 1,
+	ExtDataRecordAgingCounterK01:      1,
+}
+
+// ExtDataRecordNamesK01 are the names of the DTC extended data records
+var ExtDataRecordNamesK01 = map[byte]string{
+	ExtDataRecordOccurrenceCounterK01: "Occurrence Counter",
+	ExtDataRecordAgingCounterK01:      "Aging Counter",
+}
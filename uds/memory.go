@@ -0,0 +1,83 @@
+package uds
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// AddressAndLengthFormat4x2 identifies a 4 byte memory address followed by a 2 byte memory size
+	AddressAndLengthFormat4x2 byte = 0x24
+	// AddressAndLengthFormat4x4 identifies a 4 byte memory address followed by a 4 byte memory size
+	AddressAndLengthFormat4x4 byte = 0x44
+	// DataFormatUncompressedUnencrypted is the data format identifier for plain transfers
+	DataFormatUncompressedUnencrypted byte = 0x00
+)
+
+// NewReadMemoryByAddressRequest creates a ReadMemoryByAddress request for size bytes starting at address.
+func NewReadMemoryByAddressRequest(senderID uint16, address uint32, size uint16) *Message {
+	data := []byte{AddressAndLengthFormat4x2}
+	data = binary.BigEndian.AppendUint32(data, address)
+	data = binary.BigEndian.AppendUint16(data, size)
+	return &Message{
+		SenderID:  senderID,
+		ServiceID: ServiceReadMemoryByAddress,
+		Data:      data,
+	}
+}
+
+// NewRequestUploadRequest creates a RequestUpload request for size bytes starting at address.
+func NewRequestUploadRequest(senderID uint16, address uint32, size uint32) *Message {
+	return newTransferRequest(senderID, ServiceRequestUpload, address, size)
+}
+
+// NewRequestDownloadRequest creates a RequestDownload request for size bytes starting at address.
+func NewRequestDownloadRequest(senderID uint16, address uint32, size uint32) *Message {
+	return newTransferRequest(senderID, ServiceRequestDownload, address, size)
+}
+
+func newTransferRequest(senderID uint16, serviceID byte, address uint32, size uint32) *Message {
+	data := []byte{DataFormatUncompressedUnencrypted, AddressAndLengthFormat4x4}
+	data = binary.BigEndian.AppendUint32(data, address)
+	data = binary.BigEndian.AppendUint32(data, size)
+	return &Message{
+		SenderID:  senderID,
+		ServiceID: serviceID,
+		Data:      data,
+	}
+}
+
+// NewTransferDataRequest creates a TransferData request. Data is empty for uploads.
+func NewTransferDataRequest(senderID uint16, blockSequenceCounter byte, data []byte) *Message {
+	return &Message{
+		SenderID:  senderID,
+		ServiceID: ServiceTransferData,
+		Data:      append([]byte{blockSequenceCounter}, data...),
+	}
+}
+
+// NewRequestTransferExitRequest creates a RequestTransferExit request.
+func NewRequestTransferExitRequest(senderID uint16) *Message {
+	return &Message{
+		SenderID:  senderID,
+		ServiceID: ServiceRequestTransferExit,
+	}
+}
+
+// ParseMaxNumberOfBlockLength returns the maximum TransferData message length from a
+// positive RequestUpload or RequestDownload response. The length includes the service id and block sequence counter.
+func ParseMaxNumberOfBlockLength(resp *Message) (uint32, error) {
+	if len(resp.Data) < 2 {
+		return 0, fmt.Errorf("transfer response too short: %d bytes", len(resp.Data))
+	}
+	// The upper nibble of the length format identifier is the number of bytes used for the length
+	lengthSize := int(resp.Data[0] >> 4)
+	if lengthSize == 0 || lengthSize > 4 || len(resp.Data) < 1+lengthSize {
+		return 0, fmt.Errorf("invalid length format identifier: 0x%02X", resp.Data[0])
+	}
+	var maxLength uint32
+	for _, b := range resp.Data[1 : 1+lengthSize] {
+		maxLength = maxLength<<8 | uint32(b)
+	}
+	return maxLength, nil
+}
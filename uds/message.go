@@ -26,6 +26,8 @@ type Message struct {
 	IsResponse bool
 	// IsPositive indicates if the message was successful
 	IsPositive *bool
+	// Protocol labels the message, nil is UDS
+	Protocol *Protocol
 }
 
 // RawDataToMessage creates a new UDSMessage instance by deducing the service ID, subfunction, and NRC from a byte array.
@@ -40,7 +42,9 @@ func RawDataToMessage(senderID uint16, rawData []byte, isResponse bool) *Message
 	var nrc *byte         // Optional NRC for negative responses
 
 	var data []byte
+	var protocol *Protocol
 	if isResponse {
+		protocol = responseProtocol(senderID)
 		// This is a response
 		// Was response positive or negative?
 		isPositive = rawData[0] != NegativeResponseByte
@@ -58,6 +62,7 @@ func RawDataToMessage(senderID uint16, rawData []byte, isResponse bool) *Message
 		}
 	} else {
 		// This is a request
+		protocol = configuredProtocol(senderID)
 		serviceId = rawData[0] // Service id is the first byte of request
 		if len(rawData) > 1 {
 			subfunction = &rawData[1] // Subfunction is the second byte of request
@@ -72,6 +77,7 @@ func RawDataToMessage(senderID uint16, rawData []byte, isResponse bool) *Message
 		Data:        data,
 		IsPositive:  &isPositive,
 		IsResponse:  isResponse,
+		Protocol:    protocol,
 	}
 }
 
@@ -117,6 +123,8 @@ func (m *Message) SenderLabel() string {
 		return "ECU"
 	case TesterID:
 		return "Tester"
+	case FunctionalID:
+		return "Functional"
 	default:
 		return fmt.Sprintf("0x%03X", m.SenderID)
 	}
@@ -126,7 +134,10 @@ func (m *Message) Send(ctx context.Context) error {
 	l := services.Get(services.ServiceLogger).(*logging.Logger)
 
 	if m.ServiceID != ServiceTesterPresent {
-		l.WriteMessage("UDS: "+m.String(), logging.MessageTypeUDSWrite)
+		l.WriteMessage(m.ProtocolLabel()+": "+m.String(), logging.MessageTypeUDSWrite)
+	}
+	if !m.IsResponse {
+		noteRequestProtocol(m)
 	}
 
 	rawData := m.ToRawData()
@@ -140,24 +151,12 @@ func (m *Message) Send(ctx context.Context) error {
 		return nil
 	}
 	// Multi frame message
-	// Send First Frame (FF)
-	err := sendFirstFrame(ctx, m.SenderID, dataLength, rawData)
-	if err != nil {
-		return err
-	}
-	// Wait for Flow Control Frame from ECU (FC)
-	separationTime, err := waitForFlowControlFrame(ctx)
-	if err != nil {
-		return err
-	}
-	// Wait for separation time from FC frame
-	sleepForSeparationTime(separationTime)
-	// Send the consecutive frames
-	err = sendConsecutiveFrames(ctx, m.SenderID, rawData, separationTime)
-	if err != nil {
-		return err
-	}
-	return nil
+	return sendMultiFrame(ctx, m.SenderID, rawData)
+}
+
+// ProtocolLabel returns the name of the protocol the message belongs to.
+func (m *Message) ProtocolLabel() string {
+	return m.protocol().Name
 }
 
 func (m *Message) String() string {
@@ -172,7 +171,11 @@ func (m *Message) String() string {
 		return fmt.Sprintf("Request:\nId: %s\nService: %s\nSubfunction: %s\nASCII: %s\nData: %s", m.SenderLabel(), m.ServiceLabel(), m.SubfunctionLabel(), m.ASCIIRepresentation(), dataStr)
 	}
 	if *m.IsPositive {
-		return fmt.Sprintf("Response:\nId: %s\nService: %s\nSubfunction: %s\nASCII: %s\nData: %s", m.SenderLabel(), m.ServiceLabel(), m.SubfunctionLabel(), m.ASCIIRepresentation(), dataStr)
+		response := fmt.Sprintf("Response:\nId: %s\nService: %s\nSubfunction: %s\nASCII: %s\nData: %s", m.SenderLabel(), m.ServiceLabel(), m.SubfunctionLabel(), m.ASCIIRepresentation(), dataStr)
+		for _, value := range m.DecodedValues() {
+			response += "\n" + value.String()
+		}
+		return response
 	}
 	return fmt.Sprintf("NEGATIVE Response:\nId: %s\nService: %s\nNRC: %s", m.SenderLabel(), m.ServiceLabel(), m.NRCLabel())
 }
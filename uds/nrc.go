@@ -1,6 +1,7 @@
 package uds
 
 import (
+	"errors"
 	"fmt"
 )
 
@@ -94,9 +95,85 @@ func (m *Message) NRCLabel() string {
 	if m.NRC == nil {
 		return "N/A"
 	}
-	// Lookup the NRC name
-	if nrcName, ok := nrcNames[*m.NRC]; ok {
-		return nrcName
+	return m.protocol().NRCLabel(*m.NRC)
+}
+
+// NRCLabel returns the name of a UDS NRC.
+func NRCLabel(nrc byte) string {
+	return ProtocolUDS.NRCLabel(nrc)
+}
+
+// NRCError is the error returned for a negative response. Use errors.Is with the ErrNRC values to check
+// the NRC, or errors.As to get the service id.
+type NRCError struct {
+	// ServiceID is the service that was rejected, 0 matches any service when used as an errors.Is target
+	ServiceID byte
+	NRC       byte
+	// protocol labels the error, nil is UDS
+	protocol *Protocol
+}
+
+// Common NRCs for use with errors.Is
+var (
+	ErrNRCGeneralReject                          = &NRCError{NRC: NRCGeneralReject}
+	ErrNRCServiceNotSupported                    = &NRCError{NRC: NRCServiceNotSupported}
+	ErrNRCSubFunctionNotSupported                = &NRCError{NRC: NRCSubFunctionNotSupported}
+	ErrNRCIncorrectMessageLengthOrInvalidFormat  = &NRCError{NRC: NRCIncorrectMessageLengthOrInvalidFormat}
+	ErrNRCBusyRepeatRequest                      = &NRCError{NRC: NRCBusyRepeatRequest}
+	ErrNRCConditionsNotCorrect                   = &NRCError{NRC: NRCConditionsNotCorrect}
+	ErrNRCRequestSequenceError                   = &NRCError{NRC: NRCRequestSequenceError}
+	ErrNRCRequestOutOfRange                      = &NRCError{NRC: NRCRequestOutOfRange}
+	ErrNRCSecurityAccessDenied                   = &NRCError{NRC: NRCSecurityAccessDenied}
+	ErrNRCInvalidKey                             = &NRCError{NRC: NRCInvalidKey}
+	ErrNRCExceededNumberOfAttempts               = &NRCError{NRC: NRCExceededNumberOfAttempts}
+	ErrNRCRequiredTimeDelayNotExpired            = &NRCError{NRC: NRCRequiredTimeDelayNotExpired}
+	ErrNRCUploadDownloadNotAccepted              = &NRCError{NRC: NRCUploadDownloadNotAccepted}
+	ErrNRCGeneralProgrammingFailure              = &NRCError{NRC: NRCGeneralProgrammingFailure}
+	ErrNRCWrongBlockSequenceCounter              = &NRCError{NRC: NRCWrongBlockSequenceCounter}
+	ErrNRCSubFunctionNotSupportedInActiveSession = &NRCError{NRC: NRCSubFunctionNotSupportedInActiveSession}
+	ErrNRCServiceNotSupportedInActiveSession     = &NRCError{NRC: NRCServiceNotSupportedInActiveSession}
+)
+
+// NewNRCError creates the error for a negative response message.
+func NewNRCError(resp *Message) *NRCError {
+	err := &NRCError{ServiceID: resp.ServiceID, protocol: resp.Protocol}
+	if resp.NRC != nil {
+		err.NRC = *resp.NRC
 	}
-	return fmt.Sprintf("0x%02X", *m.NRC)
+	return err
+}
+
+func (e *NRCError) Error() string {
+	protocol := e.protocol
+	if protocol == nil {
+		protocol = ProtocolUDS
+	}
+	label := protocol.NRCLabel(e.NRC)
+	if e.ServiceID == 0 {
+		return fmt.Sprintf("negative response: %s", label)
+	}
+	return fmt.Sprintf("negative response to %s: %s", protocol.ServiceLabel(e.ServiceID), label)
+}
+
+// Is matches NRC errors with the same NRC, and the same service unless the target service id is 0.
+func (e *NRCError) Is(target error) bool {
+	t, ok := target.(*NRCError)
+	if !ok {
+		return false
+	}
+	return t.NRC == e.NRC && (t.ServiceID == 0 || t.ServiceID == e.ServiceID)
+}
+
+// IsSecurityError reports whether err is a negative response asking for, or rejecting, security access.
+func IsSecurityError(err error) bool {
+	return errors.Is(err, ErrNRCSecurityAccessDenied) ||
+		errors.Is(err, ErrNRCInvalidKey) ||
+		errors.Is(err, ErrNRCExceededNumberOfAttempts) ||
+		errors.Is(err, ErrNRCRequiredTimeDelayNotExpired)
+}
+
+// IsSessionError reports whether err is a negative response caused by being in the wrong diagnostic session.
+func IsSessionError(err error) bool {
+	return errors.Is(err, ErrNRCServiceNotSupportedInActiveSession) ||
+		errors.Is(err, ErrNRCSubFunctionNotSupportedInActiveSession)
 }
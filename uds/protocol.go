@@ -0,0 +1,142 @@
+package uds
+
+import (
+	"fmt"
+	"maps"
+	"sync"
+)
+
+// Protocol names the services, subfunctions and NRCs of a diagnostic protocol carried over the transport.
+// Requests are labelled with the protocol they were built for and responses with the protocol of the last
+// request sent to the ECU.
+type Protocol struct {
+	// Name prefixes the messages of the protocol in the message log
+	Name         string
+	Services     map[byte]string
+	Subfunctions map[byte]map[byte]string
+	NRCs         map[byte]string
+	// IdentifierServices are the services that read identifiers, their responses are decoded with the definitions
+	IdentifierServices map[byte]IdentifierSource
+	// SuppressibleServices are the services whose subfunction has a suppress positive response bit, in other
+	// services and protocols the top bit of the byte after the service id is just data
+	SuppressibleServices map[byte]bool
+}
+
+// ProtocolUDS is ISO 14229, the protocol used for messages that don't name one.
+var ProtocolUDS = &Protocol{
+	Name:         "UDS",
+	Services:     serviceIDNames,
+	Subfunctions: subfunctionNames,
+	NRCs:         nrcNames,
+	IdentifierServices: map[byte]IdentifierSource{
+		ServiceReadDataByIdentifier: SourceDataIdentifier,
+	},
+	SuppressibleServices: map[byte]bool{
+		ServiceDiagnosticSessionControl: true,
+		ServiceECUReset:                 true,
+		ServiceSecurityAccess:           true,
+		ServiceCommunicationControl:     true,
+		ServiceRoutineControl:           true,
+		ServiceTesterPresent:            true,
+		ServiceControlDTCSetting:        true,
+	},
+}
+
+var (
+	protocolsLock sync.RWMutex
+	// protocols are the protocols configured for each tester id
+	protocols = make(map[uint16]*Protocol)
+	// requestProtocols are the protocols of the last request sent to each responder id
+	requestProtocols = make(map[uint16]*Protocol)
+)
+
+// Extend returns a copy of the protocol that also names services, e.g. the manufacturer specific services of an ECU.
+func (p *Protocol) Extend(services map[byte]string) *Protocol {
+	extended := *p
+	extended.Services = maps.Clone(p.Services)
+	maps.Copy(extended.Services, services)
+	return &extended
+}
+
+// ServiceLabel returns the name of a service.
+func (p *Protocol) ServiceLabel(serviceID byte) string {
+	if serviceName, ok := p.Services[serviceID]; ok {
+		return serviceName
+	}
+	return fmt.Sprintf("0x%02X", serviceID)
+}
+
+// SubfunctionLabel returns the name of a subfunction of a service.
+func (p *Protocol) SubfunctionLabel(serviceID byte, subfunction byte) string {
+	if subMap, exists := p.Subfunctions[serviceID]; exists {
+		if subName, found := subMap[subfunction]; found {
+			return subName
+		}
+	}
+	return fmt.Sprintf("0x%02X", subfunction)
+}
+
+// NRCLabel returns the name of an NRC.
+func (p *Protocol) NRCLabel(nrc byte) string {
+	if nrcName, ok := p.NRCs[nrc]; ok {
+		return nrcName
+	}
+	return fmt.Sprintf("0x%02X", nrc)
+}
+
+// ConfigureProtocol sets the protocol an ECU speaks natively. It labels requests from testerID that don't
+// name a protocol, such as manual frames, and responses from the matching responder before any request.
+func ConfigureProtocol(testerID uint16, protocol *Protocol) {
+	protocolsLock.Lock()
+	defer protocolsLock.Unlock()
+	protocols[testerID] = protocol
+}
+
+// configuredProtocol returns the protocol configured for testerID, UDS if none is.
+func configuredProtocol(testerID uint16) *Protocol {
+	protocolsLock.RLock()
+	defer protocolsLock.RUnlock()
+	if protocol, ok := protocols[testerID]; ok {
+		return protocol
+	}
+	return ProtocolUDS
+}
+
+// noteRequestProtocol records the protocol of a request so the response can be labelled with it.
+func noteRequestProtocol(req *Message) {
+	protocolsLock.Lock()
+	defer protocolsLock.Unlock()
+	if req.SenderID != FunctionalID {
+		requestProtocols[ResponderID(req.SenderID)] = req.protocol()
+		return
+	}
+	// Functional requests may be answered by any ECU
+	for responderID := PhysicalResponseIDMin; responderID <= PhysicalResponseIDMax; responderID++ {
+		requestProtocols[responderID] = req.protocol()
+	}
+}
+
+// responseProtocol returns the protocol of the last request sent to responderID, or the protocol configured
+// for it if it hasn't been sent one.
+func responseProtocol(responderID uint16) *Protocol {
+	protocolsLock.RLock()
+	protocol, ok := requestProtocols[responderID]
+	protocolsLock.RUnlock()
+	if ok {
+		return protocol
+	}
+	return configuredProtocol(RequesterID(responderID))
+}
+
+// protocol returns the protocol of the message, UDS if it doesn't name one.
+func (m *Message) protocol() *Protocol {
+	if m.Protocol != nil {
+		return m.Protocol
+	}
+	return ProtocolUDS
+}
+
+// suppressesPositiveResponse returns true if the message is a request that asks the ECU not to send a positive response.
+func (m *Message) suppressesPositiveResponse() bool {
+	return m.Subfunction != nil && *m.Subfunction&suppressPositiveResponseBit != 0 && m.protocol().SuppressibleServices[m.ServiceID]
+}
@@ -0,0 +1,257 @@
+package uds
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"husk/seedkey"
+)
+
+// Routine identifiers defined by ISO 14229
+const (
+	RoutineEraseMemory                  uint16 = 0xFF00
+	RoutineCheckProgrammingDependencies uint16 = 0xFF01
+)
+
+// Routine status values returned in routine control responses
+const (
+	RoutineStatusCorrect   byte = 0x00
+	RoutineStatusIncorrect byte = 0x01
+	// RoutineStatusInProgress is returned until a routine that takes a while has finished
+	RoutineStatusInProgress byte = 0x02
+)
+
+const (
+	// DefaultRoutineTimeout is how long a routine is polled for when its definition doesn't set a timeout
+	DefaultRoutineTimeout = 10 * time.Second
+	// RoutinePollInterval is the delay between RequestRoutineResults requests
+	RoutinePollInterval = 250 * time.Millisecond
+)
+
+// NewRoutineControlRequest creates a RoutineControl request for a routine with optional routine control options.
+func NewRoutineControlRequest(senderID uint16, subfunction byte, routineId uint16, options []byte) *Message {
+	data := binary.BigEndian.AppendUint16(nil, routineId)
+	return &Message{
+		SenderID:    senderID,
+		ServiceID:   ServiceRoutineControl,
+		Subfunction: &subfunction,
+		Data:        append(data, options...),
+	}
+}
+
+// RoutineControl sends a RoutineControl request and returns the routine status record from the response.
+func RoutineControl(ctx context.Context, r Requester, senderID uint16, subfunction byte, routineId uint16, options []byte) ([]byte, error) {
+	resp, err := r.Request(ctx, NewRoutineControlRequest(senderID, subfunction, routineId, options))
+	if err != nil {
+		return nil, err
+	}
+	// Data holds the echoed subfunction and routine id followed by the routine status record
+	if len(resp.Data) < 3 {
+		return nil, fmt.Errorf("routine control response too short")
+	}
+	if responseID := binary.BigEndian.Uint16(resp.Data[1:3]); responseID != routineId {
+		return nil, fmt.Errorf("response is for routine 0x%04X not 0x%04X", responseID, routineId)
+	}
+	return resp.Data[3:], nil
+}
+
+// Parameter is an input to a routine or actuator test, encoded into the routine control options or control state.
+type Parameter struct {
+	Field
+	// Default is used when a value isn't given
+	Default float64 `json:"default"`
+	// Min and Max limit the values accepted, if set
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+}
+
+// Routine describes a routine an ECU can run, its input parameters and the results it reports.
+type Routine struct {
+	ID          uint16
+	Name        string
+	Description string
+	// SecurityLevel must be unlocked in the extended session before the routine is started
+	SecurityLevel seedkey.SecurityLevel
+	Parameters    []Parameter
+	// Results are decoded from the routine status record after the status byte
+	Results []Field
+	// Poll routines report RoutineStatusInProgress when started and are polled with RequestRoutineResults
+	// until they finish or Timeout passes
+	Poll    bool
+	Timeout time.Duration
+}
+
+func (r *Routine) UnmarshalJSON(data []byte) error {
+	var definition struct {
+		ID            string                `json:"id"`
+		Name          string                `json:"name"`
+		Description   string                `json:"description"`
+		SecurityLevel seedkey.SecurityLevel `json:"securityLevel"`
+		Parameters    []Parameter           `json:"parameters"`
+		Results       []Field               `json:"results"`
+		Poll          bool                  `json:"poll"`
+		Timeout       string                `json:"timeout"`
+	}
+	err := json.Unmarshal(data, &definition)
+	if err != nil {
+		return err
+	}
+	id, err := strconv.ParseUint(definition.ID, 0, 16)
+	if err != nil {
+		return fmt.Errorf("invalid id %q in %s", definition.ID, definition.Name)
+	}
+	timeout := DefaultRoutineTimeout
+	if definition.Timeout != "" {
+		timeout, err = time.ParseDuration(definition.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout %q in %s", definition.Timeout, definition.Name)
+		}
+	}
+	err = validateParameters(definition.Name, definition.Parameters)
+	if err != nil {
+		return err
+	}
+	*r = Routine{
+		ID:            uint16(id),
+		Name:          definition.Name,
+		Description:   definition.Description,
+		SecurityLevel: definition.SecurityLevel,
+		Parameters:    definition.Parameters,
+		Results:       definition.Results,
+		Poll:          definition.Poll,
+		Timeout:       timeout,
+	}
+	return nil
+}
+
+func validateParameters(name string, parameters []Parameter) error {
+	for _, parameter := range parameters {
+		if parameter.Name == "" || !parameter.IsNumeric() || parameter.Length == 0 {
+			return fmt.Errorf("parameters of %s must be named fixed length numbers", name)
+		}
+	}
+	return nil
+}
+
+// EncodeParameters returns the routine control options holding values, by parameter name. Parameters
+// without a value use their default.
+func (r Routine) EncodeParameters(values map[string]float64) ([]byte, error) {
+	return encodeParameters(r.Name, r.Parameters, values)
+}
+
+func encodeParameters(name string, parameters []Parameter, values map[string]float64) ([]byte, error) {
+	length := 0
+	for _, parameter := range parameters {
+		length = max(length, parameter.Position+parameter.Length)
+	}
+	for valueName := range values {
+		if !slices.ContainsFunc(parameters, func(p Parameter) bool { return strings.EqualFold(p.Name, valueName) }) {
+			return nil, fmt.Errorf("%s has no parameter %s", name, valueName)
+		}
+	}
+	options := make([]byte, length)
+	for _, parameter := range parameters {
+		value := parameter.Default
+		for valueName, v := range values {
+			if strings.EqualFold(valueName, parameter.Name) {
+				value = v
+			}
+		}
+		if (parameter.Min != nil && value < *parameter.Min) || (parameter.Max != nil && value > *parameter.Max) {
+			return nil, fmt.Errorf("%v is out of range for %s", value, parameter.Name)
+		}
+		err := parameter.Encode(options, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return options, nil
+}
+
+// RoutineResult is the outcome of a routine.
+type RoutineResult struct {
+	Routine Routine
+	Status  byte
+	Values  []DecodedValue
+	// Record is the routine status record after the status byte
+	Record []byte
+}
+
+// Succeeded returns true if the routine reported it completed correctly.
+func (r *RoutineResult) Succeeded() bool {
+	return r.Status == RoutineStatusCorrect
+}
+
+// String returns the routine name, status and any results, one per line.
+func (r *RoutineResult) String() string {
+	status := "Completed"
+	if !r.Succeeded() {
+		status = fmt.Sprintf("Failed (status 0x%02X)", r.Status)
+	}
+	result := fmt.Sprintf("%s: %s", r.Routine.Name, status)
+	for _, value := range r.Values {
+		result += fmt.Sprintf("\n%s", value)
+	}
+	return result
+}
+
+// RunRoutine starts a routine with parameters and, for routines that are polled, requests its results until it
+// finishes. A routine that hasn't finished by its timeout is stopped and an error returned.
+func RunRoutine(ctx context.Context, r Requester, senderID uint16, routine Routine, parameters map[string]float64) (*RoutineResult, error) {
+	options, err := routine.EncodeParameters(parameters)
+	if err != nil {
+		return nil, err
+	}
+	record, err := RoutineControl(ctx, r, senderID, SubfunctionStartRoutine, routine.ID, options)
+	if err != nil {
+		return nil, err
+	}
+	timeout := routine.Timeout
+	if timeout == 0 {
+		timeout = DefaultRoutineTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for routine.Poll && (len(record) == 0 || record[0] == RoutineStatusInProgress) {
+		if time.Now().After(deadline) {
+			// Don't leave the routine running once we've given up on it
+			_, stopErr := RoutineControl(context.WithoutCancel(ctx), r, senderID, SubfunctionStopRoutine, routine.ID, nil)
+			if stopErr != nil {
+				return nil, fmt.Errorf("%s didn't finish within %v and couldn't be stopped: %v", routine.Name, timeout, stopErr)
+			}
+			return nil, fmt.Errorf("%s didn't finish within %v", routine.Name, timeout)
+		}
+		select {
+		case <-ctx.Done():
+			_, _ = RoutineControl(context.WithoutCancel(ctx), r, senderID, SubfunctionStopRoutine, routine.ID, nil)
+			return nil, ctx.Err()
+		case <-time.After(RoutinePollInterval):
+		}
+		record, err = RoutineControl(ctx, r, senderID, SubfunctionRequestRoutineResults, routine.ID, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(record) == 0 {
+		// A routine that isn't polled may finish without a status record, the positive response means it ran
+		return &RoutineResult{Routine: routine, Status: RoutineStatusCorrect}, nil
+	}
+	result := &RoutineResult{Routine: routine, Status: record[0], Record: bytes.Clone(record[1:])}
+	if !result.Succeeded() {
+		return result, nil
+	}
+	for _, field := range routine.Results {
+		value, err := field.Decode(result.Record)
+		if err != nil {
+			return result, err
+		}
+		result.Values = append(result.Values, value)
+	}
+	return result, nil
+}
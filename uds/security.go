@@ -0,0 +1,97 @@
+package uds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"husk/logging"
+	"husk/seedkey"
+	"husk/services"
+)
+
+// SecurityLockoutDelay is how long the ECU refuses security access after too many invalid keys
+const SecurityLockoutDelay = 10 * time.Second
+
+var ErrSecurityAccessLocked = errors.New("security access is locked out")
+
+// SecurityAccess unlocks security levels with the seed key algorithms registered for an ECU family.
+type SecurityAccess struct {
+	client      *Client
+	ecuType     seedkey.ECUType
+	lock        sync.Mutex
+	lockedUntil time.Time
+}
+
+// NewSecurityAccess creates a security access routine sending requests with client.
+func NewSecurityAccess(client *Client, ecuType seedkey.ECUType) *SecurityAccess {
+	return &SecurityAccess{
+		client:  client,
+		ecuType: ecuType,
+	}
+}
+
+// Unlock requests a seed for level, calculates the key with the registered algorithm and sends it.
+// Once the ECU reports too many attempts or an unexpired delay no requests are sent until SecurityLockoutDelay
+// has passed, sending more would only restart the ECU's timer.
+func (s *SecurityAccess) Unlock(ctx context.Context, level seedkey.SecurityLevel) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if remaining := time.Until(s.lockedUntil); remaining > 0 {
+		return fmt.Errorf("%w for another %s", ErrSecurityAccessLocked, remaining.Round(time.Second))
+	}
+	algorithm, err := seedkey.Lookup(s.ecuType, level)
+	if err != nil {
+		return err
+	}
+	subfunction := level.RequestSeedSubfunction()
+	resp, err := s.request(ctx, subfunction, nil)
+	if err != nil {
+		return err
+	}
+	// Data holds the echoed subfunction followed by the seed
+	if len(resp.Data) < 2 {
+		return fmt.Errorf("security access response has no seed")
+	}
+	seed := resp.Data[1:]
+	if isZero(seed) {
+		// A zero seed means the level is already unlocked
+		return nil
+	}
+	key, err := algorithm(seed)
+	if err != nil {
+		return err
+	}
+	_, err = s.request(ctx, subfunction+1, key)
+	if errors.Is(err, ErrNRCInvalidKey) {
+		l := services.Get(services.ServiceLogger).(*logging.Logger)
+		l.WriteLog(fmt.Sprintf("ECU rejected the key for security level %d, the algorithm is likely wrong", level), logging.LogLevelWarning)
+	}
+	return err
+}
+
+// request sends a SecurityAccess request and starts the lockout timer if the ECU is refusing access.
+func (s *SecurityAccess) request(ctx context.Context, subfunction byte, data []byte) (*Message, error) {
+	resp, err := s.client.Request(ctx, &Message{
+		SenderID:    s.client.testerID,
+		ServiceID:   ServiceSecurityAccess,
+		Subfunction: &subfunction,
+		Data:        data,
+	})
+	if errors.Is(err, ErrNRCExceededNumberOfAttempts) || errors.Is(err, ErrNRCRequiredTimeDelayNotExpired) {
+		s.lockedUntil = time.Now().Add(SecurityLockoutDelay)
+		return resp, fmt.Errorf("%w: %w", ErrSecurityAccessLocked, err)
+	}
+	return resp, err
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -1,9 +1,5 @@
 package uds
 
-import (
-	"fmt"
-)
-
 // UDS Service ID constants
 const (
 	ServiceDiagnosticSessionControl       byte = 0x10
@@ -46,16 +42,14 @@ var serviceIDNames = map[byte]string{
 	ServiceRequestTransferExit:            "Request Transfer Exit",
 	ServiceTesterPresent:                  "Tester Present",
 	ServiceControlDTCSetting:              "Control DTC Setting",
-	// K01
-	ServiceReadIdK01:      "Read ECU ID",
-	ServiceReadErrorsK01:  "Read Errors",
-	ServiceClearErrorsK01: "Clear Errors",
 }
 
+// ServiceLabel returns the name of the service in the protocol of the message.
 func (m *Message) ServiceLabel() string {
-	// Lookup the service ID name
-	if serviceName, ok := serviceIDNames[m.ServiceID]; ok {
-		return serviceName
-	}
-	return fmt.Sprintf("0x%02X", m.ServiceID)
+	return m.protocol().ServiceLabel(m.ServiceID)
+}
+
+// ServiceLabel returns the name of a UDS service.
+func ServiceLabel(serviceID byte) string {
+	return ProtocolUDS.ServiceLabel(serviceID)
 }
@@ -0,0 +1,228 @@
+package uds
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"husk/logging"
+	"husk/seedkey"
+	"husk/services"
+)
+
+// S3Timeout is how long an ECU stays in a non default session without receiving a request (S3server)
+const S3Timeout = 5 * time.Second
+
+// SecurityUnlocker performs the SecurityAccess exchange for a security level.
+type SecurityUnlocker func(ctx context.Context, level seedkey.SecurityLevel) error
+
+// SessionManager tracks the diagnostic session and security level of an ECU. Requests sent through it
+// restore the session and security level an operation asked for if the ECU has dropped them.
+type SessionManager struct {
+	client *Client
+	unlock SecurityUnlocker
+	lock   sync.Mutex
+	// restoreLock stops the keep alive and an operation restoring the session at the same time
+	restoreLock sync.Mutex
+	// session and securityLevel are what the ECU is believed to be in
+	session       byte
+	securityLevel seedkey.SecurityLevel
+	// requiredSession and requiredSecurityLevel are what operations asked for
+	requiredSession       byte
+	requiredSecurityLevel seedkey.SecurityLevel
+}
+
+// NewSessionManager creates a session manager sending requests with client. The ECU is assumed to be in the
+// default session with security access locked.
+func NewSessionManager(client *Client, unlock SecurityUnlocker) *SessionManager {
+	return &SessionManager{
+		client:          client,
+		unlock:          unlock,
+		session:         SubfunctionDefaultSession,
+		requiredSession: SubfunctionDefaultSession,
+	}
+}
+
+// Session returns the session the ECU is believed to be in.
+func (m *SessionManager) Session() byte {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.session
+}
+
+// SecurityLevel returns the security level the ECU is believed to have unlocked.
+func (m *SessionManager) SecurityLevel() seedkey.SecurityLevel {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.securityLevel
+}
+
+// RequireSession switches the ECU to session if it isn't already in it. The session is restored if the ECU drops it.
+func (m *SessionManager) RequireSession(ctx context.Context, session byte) error {
+	m.lock.Lock()
+	// Security access belongs to the previous session
+	if m.session != session || m.requiredSession != session {
+		m.requiredSecurityLevel = seedkey.SecurityLevelUnspecified
+	}
+	m.requiredSession = session
+	m.lock.Unlock()
+	return m.restore(ctx)
+}
+
+// RequireSecurityLevel unlocks level in the current session if it isn't already unlocked.
+// The level is unlocked again if the ECU drops it.
+func (m *SessionManager) RequireSecurityLevel(ctx context.Context, level seedkey.SecurityLevel) error {
+	m.lock.Lock()
+	m.requiredSecurityLevel = level
+	m.lock.Unlock()
+	return m.restore(ctx)
+}
+
+// EndSession returns the ECU to the default session.
+func (m *SessionManager) EndSession(ctx context.Context) error {
+	return m.RequireSession(ctx, SubfunctionDefaultSession)
+}
+
+// Request sends req with the client. If the ECU rejects it because the session or security level has been lost
+// they are restored and the request is sent again.
+func (m *SessionManager) Request(ctx context.Context, req *Message) (*Message, error) {
+	resp, err := m.request(ctx, req)
+	if req.ServiceID == ServiceDiagnosticSessionControl || req.ServiceID == ServiceSecurityAccess {
+		return resp, err
+	}
+	if !IsSessionError(err) && !errors.Is(err, ErrNRCSecurityAccessDenied) {
+		return resp, err
+	}
+	if !m.lost() {
+		return resp, err
+	}
+	l := services.Get(services.ServiceLogger).(*logging.Logger)
+	l.WriteLog("ECU dropped the diagnostic session, restoring it", logging.LogLevelWarning)
+	restoreErr := m.restore(ctx)
+	if restoreErr != nil {
+		return resp, fmt.Errorf("%w, failed to restore session: %v", err, restoreErr)
+	}
+	return m.request(ctx, req)
+}
+
+// KeepAlive restores the required session and security level if they have been lost, then sends a TesterPresent,
+// with the positive response suppressed, if the ECU is in a non default session. Call it more often than S3Timeout.
+func (m *SessionManager) KeepAlive(ctx context.Context) error {
+	err := m.restore(ctx)
+	if err != nil {
+		return err
+	}
+	if m.Session() == SubfunctionDefaultSession {
+		return nil
+	}
+	req := NewTesterPresentRequest(m.client.testerID)
+	*req.Subfunction |= suppressPositiveResponseBit
+	_, err = m.request(ctx, req)
+	return err
+}
+
+// request sends req with the client and updates the session state from the response.
+func (m *SessionManager) request(ctx context.Context, req *Message) (*Message, error) {
+	resp, err := m.client.Request(ctx, req)
+	if err != nil || resp == nil {
+		return resp, err
+	}
+	switch req.ServiceID {
+	case ServiceDiagnosticSessionControl:
+		p2, p2Extended, err := ParseSessionTiming(resp)
+		if err != nil {
+			// Not every ECU reports its timing
+			p2, p2Extended = DefaultP2, DefaultP2Extended
+		}
+		m.client.SetTiming(p2, p2Extended)
+		m.lock.Lock()
+		m.session = *req.Subfunction &^ suppressPositiveResponseBit
+		m.securityLevel = seedkey.SecurityLevelUnspecified
+		m.lock.Unlock()
+	case ServiceECUReset:
+		// The ECU restarts in the default session with security access locked
+		m.lock.Lock()
+		m.session = SubfunctionDefaultSession
+		m.securityLevel = seedkey.SecurityLevelUnspecified
+		m.lock.Unlock()
+		m.client.SetTiming(DefaultP2, DefaultP2Extended)
+	}
+	return resp, nil
+}
+
+// lost marks the session and security level as dropped by the ECU. It returns false if there is nothing to restore.
+func (m *SessionManager) lost() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.requiredSession == SubfunctionDefaultSession && m.requiredSecurityLevel == seedkey.SecurityLevelUnspecified {
+		return false
+	}
+	m.session = SubfunctionDefaultSession
+	m.securityLevel = seedkey.SecurityLevelUnspecified
+	return true
+}
+
+// restore switches to the required session and unlocks the required security level.
+func (m *SessionManager) restore(ctx context.Context) error {
+	m.restoreLock.Lock()
+	defer m.restoreLock.Unlock()
+	m.lock.Lock()
+	session, requiredSession := m.session, m.requiredSession
+	m.lock.Unlock()
+	if session != requiredSession {
+		_, err := m.request(ctx, NewDiagnosticSessionControlRequest(m.client.testerID, requiredSession))
+		if err != nil {
+			return err
+		}
+	}
+	m.lock.Lock()
+	securityLevel, requiredSecurityLevel := m.securityLevel, m.requiredSecurityLevel
+	m.lock.Unlock()
+	if requiredSecurityLevel == seedkey.SecurityLevelUnspecified || securityLevel == requiredSecurityLevel {
+		return nil
+	}
+	if m.unlock == nil {
+		return fmt.Errorf("no security unlocker for security level %d", requiredSecurityLevel)
+	}
+	err := m.unlock(ctx, requiredSecurityLevel)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	m.securityLevel = requiredSecurityLevel
+	m.lock.Unlock()
+	return nil
+}
+
+// NewDiagnosticSessionControlRequest creates a DiagnosticSessionControl request.
+func NewDiagnosticSessionControlRequest(senderID uint16, session byte) *Message {
+	return &Message{
+		SenderID:    senderID,
+		ServiceID:   ServiceDiagnosticSessionControl,
+		Subfunction: &session,
+	}
+}
+
+// NewTesterPresentRequest creates a TesterPresent request.
+func NewTesterPresentRequest(senderID uint16) *Message {
+	subfunction := byte(0x00)
+	return &Message{
+		SenderID:    senderID,
+		ServiceID:   ServiceTesterPresent,
+		Subfunction: &subfunction,
+	}
+}
+
+// ParseSessionTiming returns the P2 and P2* times from a DiagnosticSessionControl response.
+func ParseSessionTiming(resp *Message) (p2 time.Duration, p2Extended time.Duration, err error) {
+	// Data holds the echoed session followed by P2 in milliseconds and P2* in units of 10 milliseconds
+	if len(resp.Data) < 5 {
+		return 0, 0, fmt.Errorf("diagnostic session control response too short")
+	}
+	p2 = time.Duration(binary.BigEndian.Uint16(resp.Data[1:3])) * time.Millisecond
+	p2Extended = time.Duration(binary.BigEndian.Uint16(resp.Data[3:5])) * 10 * time.Millisecond
+	return p2, p2Extended, nil
+}
@@ -1,9 +1,5 @@
 package uds
 
-import (
-	"fmt"
-)
-
 // UDS Subfunction constants for Diagnostic Session Control
 const (
 	SubfunctionDefaultSession                byte = 0x01
@@ -48,6 +44,15 @@ const (
 	SubfunctionDTCSettingOff byte = 0x02
 )
 
+// UDS Subfunction constants for Read DTC Information
+const (
+	SubfunctionReportNumberOfDTCByStatusMask      byte = 0x01
+	SubfunctionReportDTCByStatusMask              byte = 0x02
+	SubfunctionReportDTCSnapshotRecordByDTCNumber byte = 0x04
+	SubfunctionReportDTCExtDataRecordByDTCNumber  byte = 0x06
+	SubfunctionReportSupportedDTC                 byte = 0x0A
+)
+
 // Map of UDS subfunctions (for specific service IDs) to their names.
 var subfunctionNames = map[byte]map[byte]string{
 	ServiceDiagnosticSessionControl: {
@@ -65,6 +70,13 @@ var subfunctionNames = map[byte]map[byte]string{
 		SubfunctionRequestSeed: "Request Seed",
 		SubfunctionSendKey:     "Send Key",
 	},
+	ServiceReadDTCInformation: {
+		SubfunctionReportNumberOfDTCByStatusMask:      "Report Number of DTC by Status Mask",
+		SubfunctionReportDTCByStatusMask:              "Report DTC by Status Mask",
+		SubfunctionReportDTCSnapshotRecordByDTCNumber: "Report DTC Snapshot Record by DTC Number",
+		SubfunctionReportDTCExtDataRecordByDTCNumber:  "Report DTC Extended Data Record by DTC Number",
+		SubfunctionReportSupportedDTC:                 "Report Supported DTC",
+	},
 	ServiceRoutineControl: {
 		SubfunctionStartRoutine:          "Start Routine",
 		SubfunctionStopRoutine:           "Stop Routine",
@@ -80,25 +92,15 @@ var subfunctionNames = map[byte]map[byte]string{
 		SubfunctionDTCSettingOn:  "DTC Setting On",
 		SubfunctionDTCSettingOff: "DTC Setting Off",
 	},
-	// K01
-	ServiceReadIdK01: {
-		SubfunctionReadVINK01:           "Read VIN",
-		SubfunctionReadECUHardwareIdK01: "Read ECU Hardware Id",
-		SubfunctionReadECUSoftwareIdK01: "Read ECU Software Id",
-		SubfunctionReadCountryK01:       "Read Manufacturer Country Code",
-		SubfunctionReadManufacturerK01:  "Read Brand",
-		SubfunctionReadModelK01:         "Read Model",
-	},
 }
 
 func (m *Message) SubfunctionLabel() string {
 	if m.Subfunction == nil {
 		return "N/A"
 	}
-	if subMap, exists := subfunctionNames[m.ServiceID]; exists {
-		if subName, found := subMap[*m.Subfunction]; found {
-			return subName
-		}
+	// Identification options and OBD-II PIDs are named by the definitions of the ECU
+	if identifier, _, ok := m.Identifier(); ok && (identifier.Source == SourceECUIdentification || identifier.Source == SourceOBDPID) {
+		return "Read " + identifier.Name
 	}
-	return fmt.Sprintf("0x%02X", *m.Subfunction)
+	return m.protocol().SubfunctionLabel(m.ServiceID, *m.Subfunction)
 }
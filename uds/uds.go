@@ -2,6 +2,7 @@ package uds
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"time"
@@ -12,11 +13,14 @@ import (
 	"husk/services"
 )
 
-// wait 5 seconds between consecutive frames before timing out
-const frameWaitTimeout = 5 * time.Second
-
-// 10 millisecond tester separation time
-const testerSeparationTime byte = 0x10
+const (
+	// NBsTimeout is how long the sender waits for a flow control frame (N_Bs)
+	NBsTimeout = 1 * time.Second
+	// NCrTimeout is how long the receiver waits for the next consecutive frame (N_Cr)
+	NCrTimeout = 1 * time.Second
+	// MaxFlowControlWaitFrames is the number of consecutive FS=Wait frames accepted before giving up (N_WFTmax)
+	MaxFlowControlWaitFrames = 10
+)
 
 const (
 	// PCIFrameTypeSF represents a Single Frame.
@@ -39,9 +43,18 @@ const (
 	PCIFrameTypeFC byte = 0x3
 )
 
+// Flow status values carried in the lower nibble of a flow control frame PCI byte
+const (
+	FlowStatusContinueToSend byte = 0x0
+	FlowStatusWait           byte = 0x1
+	FlowStatusOverflow       byte = 0x2
+)
+
 const (
 	TesterID uint16 = 0x7E0
 	ECUID    uint16 = 0x7E8
+	// FunctionalID addresses a request to every ECU on the bus, each answers from its own physical response ID
+	FunctionalID uint16 = 0x7DF
 )
 
 const (
@@ -51,8 +64,14 @@ const (
 
 var (
 	errorFCFrameTimeout        = errors.New("timeout while waiting for flow control frame from ecu for multi frame send")
+	errorFCOverflow            = errors.New("ecu reported overflow in flow control frame, message is too large")
+	errorFCTooManyWaits        = errors.New("ecu sent too many flow control wait frames")
+	errorFCInvalidFlowStatus   = errors.New("invalid flow status in flow control frame")
+	errorFrameChannelClosed    = errors.New("frame channel has been closed")
 	errorMultiFrameReadTimeout = errors.New("timeout while waiting for consecutive frames from ecu")
 	errorUnexpectedFrameIndex  = errors.New("unexpected frame index")
+	errorInvalidFirstFrame     = errors.New("invalid first frame length")
+	errorMessageTooLarge       = errors.New("multi frame message is larger than the max message length")
 )
 
 func SendTesterPresent(ctx context.Context) error {
@@ -68,51 +87,115 @@ func SendTesterPresent(ctx context.Context) error {
 }
 
 func sendSingleFrame(ctx context.Context, id uint16, dataLength uint16, data []byte) error {
-	d := services.Get(services.ServiceDriver).(drivers.Driver)
 	frame := &canbus.CanFrame{ID: id}
 	frame.DLC = byte(dataLength) + 1
 	// Set PCI. Upper nibble is 0x0 (Single Frame) and lower nibble is length
 	frame.Data[0] = PCIFrameTypeSF | byte(dataLength&0x0F)
 	// Set the actual data bytes
 	copy(frame.Data[1:], data)
-	err := d.SendFrame(ctx, frame)
-	if err != nil {
-		return err
-	}
-	return nil
+	return sendTransportFrame(ctx, frame)
 }
 
-func sendFirstFrame(ctx context.Context, id uint16, dataLength uint16, data []byte) error {
+// sendTransportFrame pads the frame if the transport for its ID requires it and sends it with the driver.
+func sendTransportFrame(ctx context.Context, frame *canbus.CanFrame) error {
 	d := services.Get(services.ServiceDriver).(drivers.Driver)
+	config := getTransportConfig(frame.ID)
+	if config.Padding {
+		for i := int(frame.DLC); i < len(frame.Data); i++ {
+			frame.Data[i] = config.PaddingByte
+		}
+		frame.DLC = byte(len(frame.Data))
+	}
+	return d.SendFrame(ctx, frame)
+}
+
+// sendFirstFrame sends the first frame of a multi frame message and returns the number of data bytes it carried.
+// Messages longer than 4095 bytes use the escape sequence with a 32 bit length.
+func sendFirstFrame(ctx context.Context, id uint16, data []byte) (int, error) {
 	frame := &canbus.CanFrame{ID: id, DLC: 8}
+	if len(data) > maxFirstFrameLength {
+		// Escape sequence, the 12 bit length is 0 and the next 4 bytes hold the length
+		frame.Data[0] = PCIFrameTypeFF << 4
+		binary.BigEndian.PutUint32(frame.Data[2:6], uint32(len(data)))
+		copy(frame.Data[6:], data[:2])
+		return 2, sendTransportFrame(ctx, frame)
+	}
 	// Set PCI. Upper nibble is 0x1 (First Frame) and lower nibble is the upper 4 bits of the data length
-	frame.Data[0] = PCIFrameTypeFF | byte((dataLength>>8)&0x0F)
+	frame.Data[0] = (PCIFrameTypeFF << 4) | byte((len(data)>>8)&0x0F)
 	// Send second byte holds the remaining 8 bits of the 12 bit data length
-	frame.Data[1] = byte(dataLength & 0xFF)
+	frame.Data[1] = byte(len(data) & 0xFF)
 	// Copy in the first 6 data bytes
 	copy(frame.Data[2:], data[:6])
-	return d.SendFrame(ctx, frame)
+	return 6, sendTransportFrame(ctx, frame)
 }
 
-func waitForFlowControlFrame(ctx context.Context) (separationTime byte, err error) {
+// sendMultiFrame sends a first frame followed by consecutive frames, pacing them as instructed by the
+// flow control frames sent from responderID.
+func sendMultiFrame(ctx context.Context, id uint16, data []byte) error {
 	d := services.Get(services.ServiceDriver).(drivers.Driver)
-	readCtx, cancel := context.WithTimeout(ctx, frameWaitTimeout)
-	defer cancel()
+	// Subscribe before sending the first frame so a fast flow control frame can't be missed
 	frameChan := d.SubscribeReadFrames()
 	defer d.UnsubscribeReadFrames(frameChan)
+	bytesSent, err := sendFirstFrame(ctx, id, data)
+	if err != nil {
+		return err
+	}
+	responderID := ResponderID(id)
+	frameIndex := byte(1) // Consecutive Frame index starts at 1
+	for bytesSent < len(data) {
+		// Wait for Flow Control Frame from ECU (FC), one is sent after the first frame and after every block
+		blockSize, separationTime, err := waitForFlowControlFrame(ctx, frameChan, responderID)
+		if err != nil {
+			return err
+		}
+		// Wait for separation time from FC frame
+		sleepForSeparationTime(separationTime)
+		bytesSent, frameIndex, err = sendConsecutiveFrames(ctx, id, data, bytesSent, frameIndex, blockSize, separationTime)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForFlowControlFrame waits for a flow control frame from responderID that allows consecutive frames to be sent.
+func waitForFlowControlFrame(ctx context.Context, frameChan chan *canbus.CanFrame, responderID uint16) (blockSize byte, separationTime byte, err error) {
+	waitFrames := 0
+	timer := time.NewTimer(NBsTimeout)
+	defer timer.Stop()
 	for {
 		select {
-		case frame := <-frameChan:
+		case frame, ok := <-frameChan:
+			if !ok {
+				return 0, 0, errorFrameChannelClosed
+			}
+			if frame.ID != responderID {
+				continue
+			}
 			pciFrameType := (frame.Data[0] & 0xF0) >> 4
 			if pciFrameType != PCIFrameTypeFC {
 				continue
 			}
-			// flowStatus := frame.Data[0] & 0x0F
-			// blockSize := frame.Data[1]
-			separationTime = frame.Data[2]
-			return separationTime, nil
-		case <-readCtx.Done():
-			return 0, errorFCFrameTimeout
+			flowStatus := frame.Data[0] & 0x0F
+			switch flowStatus {
+			case FlowStatusContinueToSend:
+				return frame.Data[1], frame.Data[2], nil
+			case FlowStatusWait:
+				// The receiver isn't ready yet, restart N_Bs and wait for another flow control frame
+				waitFrames++
+				if waitFrames > MaxFlowControlWaitFrames {
+					return 0, 0, errorFCTooManyWaits
+				}
+				timer.Reset(NBsTimeout)
+			case FlowStatusOverflow:
+				return 0, 0, errorFCOverflow
+			default:
+				return 0, 0, errorFCInvalidFlowStatus
+			}
+		case <-timer.C:
+			return 0, 0, errorFCFrameTimeout
+		case <-ctx.Done():
+			return 0, 0, ctx.Err()
 		}
 	}
 }
@@ -132,13 +215,21 @@ func sleepForSeparationTime(separationTime byte) {
 	}
 }
 
-func sendConsecutiveFrames(ctx context.Context, id uint16, data []byte, separationTime byte) error {
-	d := services.Get(services.ServiceDriver).(drivers.Driver)
-	frameIndex := byte(1) // Consecutive Frame index starts at 1
-	chunkSize := 7        // Consecutive frames carry 7 bytes of data
-	bytesSent := 6        // Start from the 7th byte (as first 6 bytes were sent in the first frame)
+// sendConsecutiveFrames sends one block of consecutive frames starting at bytesSent. A block size of 0 sends
+// the rest of the data. It returns the updated byte count and frame index so the next block can continue.
+func sendConsecutiveFrames(
+	ctx context.Context,
+	id uint16,
+	data []byte,
+	bytesSent int,
+	frameIndex byte,
+	blockSize byte,
+	separationTime byte,
+) (int, byte, error) {
+	chunkSize := 7 // Consecutive frames carry 7 bytes of data
 	totalBytes := len(data)
-	for bytesSent < totalBytes {
+	framesSent := 0
+	for bytesSent < totalBytes && (blockSize == 0 || framesSent < int(blockSize)) {
 		frame := &canbus.CanFrame{ID: id}
 		// Set PCI. Upper nibble is 0x2 (Consecutive Frame) and lower nibble is the frame index (mod 16)
 		frame.Data[0] = (PCIFrameTypeCF << 4) | (frameIndex & 0x0F)
@@ -152,133 +243,278 @@ func sendConsecutiveFrames(ctx context.Context, id uint16, data []byte, separati
 		// Set the correct DLC (PCI byte + actual data bytes)
 		frame.DLC = byte(1 + bytesToSend)
 		// Send the frame
-		err := d.SendFrame(ctx, frame)
+		err := sendTransportFrame(ctx, frame)
 		if err != nil {
-			return err
+			return bytesSent, frameIndex, err
 		}
 		// Update bytesSent and frameIndex
 		bytesSent += bytesToSend
+		framesSent++
 		frameIndex = (frameIndex + 1) % 16 // Sequence number cycles from 0 to 15
-		// Sleep for the separation time between consecutive frames
-		sleepForSeparationTime(separationTime)
+		// Sleep for the separation time between consecutive frames, the end of a block waits for flow control instead
+		if bytesSent < totalBytes && (blockSize == 0 || framesSent < int(blockSize)) {
+			sleepForSeparationTime(separationTime)
+		}
 	}
-	return nil
+	return bytesSent, frameIndex, nil
 }
 
-func Read(ctx context.Context) (*Message, error) {
+// Reader reassembles messages from the frames of every ECU on the bus. Multi frame messages are reassembled per
+// sender so responses from several ECUs can arrive at the same time, and a reception in progress carries over to
+// the next call to Read.
+type Reader struct {
+	driver     drivers.Driver
+	frameChan  chan *canbus.CanFrame
+	receptions map[uint16]*reception
+}
+
+// reception is a multi frame message being received from one ECU.
+type reception struct {
+	testerID      uint16
+	config        ISOTPConfig
+	data          []byte
+	bytesReceived uint32
+	frameIndex    byte
+	framesInBlock int
+	// receivedCF is set once a consecutive frame has been received, before that there is nothing to repeat
+	receivedCF bool
+	deadline   time.Time
+}
+
+// NewReader subscribes to the frames read by the driver. Close must be called once the reader is no longer used.
+func NewReader() *Reader {
 	d := services.Get(services.ServiceDriver).(drivers.Driver)
-	frameChan := d.SubscribeReadFrames()
-	defer d.UnsubscribeReadFrames(frameChan)
+	return &Reader{
+		driver:     d,
+		frameChan:  d.SubscribeReadFrames(),
+		receptions: make(map[uint16]*reception),
+	}
+}
+
+// Close unsubscribes the reader and abandons the messages still being received.
+func (r *Reader) Close() {
+	for senderID := range r.receptions {
+		r.abort(senderID)
+	}
+	r.driver.UnsubscribeReadFrames(r.frameChan)
+}
+
+// Read blocks until a complete message has been received and returns it.
+func Read(ctx context.Context) (*Message, error) {
+	r := NewReader()
+	defer r.Close()
+	return r.Read(ctx)
+}
+
+// Read blocks until a complete message has been received from any ECU and returns it.
+// An error ends only the reception it occurred in, the reader can still be used.
+func (r *Reader) Read(ctx context.Context) (*Message, error) {
 	for {
-		select {
-		case frame := <-frameChan:
-			pciFrameType := (frame.Data[0] & 0xF0) >> 4
-			switch pciFrameType {
-			case PCIFrameTypeSF:
-				// Handle single frame reception
-				rawData, err := receiveSingleFrame(frame)
-				if err != nil {
-					return nil, err
-				}
-				return RawDataToMessage(frame.ID, rawData, true), nil
-			case PCIFrameTypeFF:
-				rawData, err := receiveMultiFrame(ctx, frame)
-				if err != nil {
-					return nil, err
-				}
-				return RawDataToMessage(frame.ID, rawData, true), nil
-			default:
-				// Ignore frames that don't match expected types
-				continue
-			}
-		case <-ctx.Done():
-			return nil, ctx.Err()
+		frame, err := r.nextFrame(ctx)
+		if err != nil {
+			return nil, err
+		}
+		message, err := r.handleFrame(ctx, frame)
+		if err != nil {
+			return nil, err
+		}
+		if message != nil {
+			return message, nil
+		}
+	}
+}
+
+// nextFrame waits for the next frame, or until the reception closest to its N_Cr deadline times out.
+func (r *Reader) nextFrame(ctx context.Context) (*canbus.CanFrame, error) {
+	var timeout <-chan time.Time
+	senderID, deadline, ok := r.nextDeadline()
+	if ok {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case frame, ok := <-r.frameChan:
+		if !ok {
+			return nil, errorFrameChannelClosed
+		}
+		return frame, nil
+	case <-timeout:
+		r.abort(senderID)
+		return nil, errorMultiFrameReadTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// handleFrame processes a frame and returns the message it completes, if any.
+func (r *Reader) handleFrame(ctx context.Context, frame *canbus.CanFrame) (*Message, error) {
+	pciFrameType := (frame.Data[0] & 0xF0) >> 4
+	switch pciFrameType {
+	case PCIFrameTypeSF:
+		// Handle single frame reception
+		rawData, ok := receiveSingleFrame(frame)
+		if !ok {
+			return nil, nil
 		}
+		// A new message from the sender ends the one it was sending
+		r.abort(frame.ID)
+		return RawDataToMessage(frame.ID, rawData, true), nil
+	case PCIFrameTypeFF:
+		// Only ECUs we can address get flow control frames
+		if !isPhysicalResponseID(frame.ID) {
+			return nil, nil
+		}
+		r.abort(frame.ID)
+		return nil, r.startReception(ctx, frame)
+	case PCIFrameTypeCF:
+		return r.receiveConsecutiveFrame(ctx, frame)
+	default:
+		// Ignore frames that don't match expected types
+		return nil, nil
 	}
 }
 
-func receiveSingleFrame(frame *canbus.CanFrame) ([]byte, error) {
+// receiveSingleFrame returns the data in a single frame, or false if the frame is malformed.
+func receiveSingleFrame(frame *canbus.CanFrame) ([]byte, bool) {
 	// Extract data length from the lower nibble of the PCI byte
 	dataLength := frame.Data[0] & 0x0F
+	if dataLength == 0 || dataLength > 7 || dataLength >= frame.DLC {
+		return nil, false
+	}
 	// Copy the data into a byte slice
 	data := make([]byte, dataLength)
 	copy(data, frame.Data[1:dataLength+1])
-	return data, nil
+	return data, true
 }
 
-func receiveMultiFrame(ctx context.Context, firstFrame *canbus.CanFrame) ([]byte, error) {
-	d := services.Get(services.ServiceDriver).(drivers.Driver)
-	frameChan := d.SubscribeReadFrames()
-	defer d.UnsubscribeReadFrames(frameChan)
+// parseFirstFrame returns the message length from a first frame and the index of its first data byte.
+func parseFirstFrame(firstFrame *canbus.CanFrame) (dataLength uint32, dataStart int, err error) {
 	// Extract data length from the first two bytes of the first frame
-	dataLength := (uint16(firstFrame.Data[0]&0x0F) << 8) | uint16(firstFrame.Data[1])
-	// Allocate a buffer to hold the entire message
-	data := make([]byte, dataLength)
-	// Copy the first 6 bytes of data from the first frame
-	copy(data, firstFrame.Data[2:8])
-	bytesReceived := 6
-	frameIndex := byte(1)
+	dataLength = uint32(firstFrame.Data[0]&0x0F)<<8 | uint32(firstFrame.Data[1])
+	if dataLength != 0 {
+		if dataLength <= 7 {
+			// The message fits in a single frame
+			return 0, 0, errorInvalidFirstFrame
+		}
+		return dataLength, 2, nil
+	}
+	// A length of 0 is the escape sequence for a 32 bit length, only used for lengths that don't fit in 12 bits
+	dataLength = binary.BigEndian.Uint32(firstFrame.Data[2:6])
+	if dataLength <= maxFirstFrameLength {
+		return 0, 0, errorInvalidFirstFrame
+	}
+	return dataLength, 6, nil
+}
+
+// startReception starts receiving the multi frame message announced by firstFrame and sends the first flow control frame.
+func (r *Reader) startReception(ctx context.Context, firstFrame *canbus.CanFrame) error {
+	testerID := RequesterID(firstFrame.ID)
+	config := getTransportConfig(testerID)
+	dataLength, dataStart, err := parseFirstFrame(firstFrame)
+	if err != nil {
+		return err
+	}
+	if dataLength > config.MaxMessageLength {
+		// Tell the ECU to abort, we won't buffer a message this large
+		err = sendFlowControlFrame(ctx, testerID, FlowStatusOverflow, config)
+		if err != nil {
+			return fmt.Errorf("failed to send flow control frame: %v", err)
+		}
+		return errorMessageTooLarge
+	}
+	// Allocate a buffer to hold the entire message and copy in the data from the first frame
+	rec := &reception{
+		testerID:   testerID,
+		config:     config,
+		data:       make([]byte, dataLength),
+		frameIndex: 1, // Consecutive Frame index starts at 1
+		deadline:   time.Now().Add(NCrTimeout),
+	}
+	rec.bytesReceived = uint32(copy(rec.data, firstFrame.Data[dataStart:]))
+	// The response has started, requests waiting on it give the transport until N_Cr to finish
+	r.receptions[firstFrame.ID] = rec
+	setReceiving(firstFrame.ID, true)
 	// Send Flow Control Frame before proceeding
-	err := sendFlowControlFrame()
+	err = sendFlowControlFrame(ctx, testerID, FlowStatusContinueToSend, config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send flow control frame: %v", err)
+		r.abort(firstFrame.ID)
+		return fmt.Errorf("failed to send flow control frame: %v", err)
 	}
-	var cancel context.CancelFunc
-	for bytesReceived < int(dataLength) {
-		var readCtx context.Context
-		readCtx, cancel = context.WithTimeout(ctx, frameWaitTimeout)
-		select {
-		case frame := <-frameChan:
-			if frame.ID != firstFrame.ID {
-				continue
-			}
-			pciFrameType := (frame.Data[0] & 0xF0) >> 4
-			if pciFrameType != PCIFrameTypeCF {
-				// We are expecting consecutive frames; ignore any other frames
-				continue
-			}
-			// Check the sequence number
-			seqNum := frame.Data[0] & 0x0F
-			if seqNum != frameIndex {
-				cancel()
-				return nil, errorUnexpectedFrameIndex
-			}
-			// Determine how many bytes to copy
-			bytesToCopy := int(dataLength) - bytesReceived
-			if bytesToCopy > 7 {
-				bytesToCopy = 7
-			}
-			// Copy the data from the frame
-			copy(data[bytesReceived:], frame.Data[1:bytesToCopy+1])
-			bytesReceived += bytesToCopy
-			frameIndex = (frameIndex + 1) % 16
-			cancel()
-		case <-readCtx.Done():
-			cancel()
-			return nil, errorMultiFrameReadTimeout
+	return nil
+}
+
+// receiveConsecutiveFrame adds a consecutive frame to the reception from its sender and returns the message once complete.
+func (r *Reader) receiveConsecutiveFrame(ctx context.Context, frame *canbus.CanFrame) (*Message, error) {
+	rec, ok := r.receptions[frame.ID]
+	if !ok {
+		// Not part of a message we are receiving
+		return nil, nil
+	}
+	// Check the sequence number, it wraps from 15 back to 0
+	seqNum := frame.Data[0] & 0x0F
+	if rec.receivedCF && seqNum == (rec.frameIndex+15)%16 {
+		// A repeat of the previous consecutive frame, the data has already been copied
+		return nil, nil
+	}
+	if seqNum != rec.frameIndex {
+		r.abort(frame.ID)
+		return nil, errorUnexpectedFrameIndex
+	}
+	// Copy the data from the frame
+	dataLength := uint32(len(rec.data))
+	bytesToCopy := min(dataLength-rec.bytesReceived, 7)
+	copy(rec.data[rec.bytesReceived:], frame.Data[1:bytesToCopy+1])
+	rec.bytesReceived += bytesToCopy
+	rec.receivedCF = true
+	rec.framesInBlock++
+	rec.frameIndex = (rec.frameIndex + 1) % 16
+	rec.deadline = time.Now().Add(NCrTimeout)
+	if rec.bytesReceived == dataLength {
+		r.abort(frame.ID)
+		return RawDataToMessage(frame.ID, rec.data, true), nil
+	}
+	// Once a block has been received the ECU waits for another flow control frame
+	if rec.config.BlockSize != 0 && rec.framesInBlock == int(rec.config.BlockSize) {
+		err := sendFlowControlFrame(ctx, rec.testerID, FlowStatusContinueToSend, rec.config)
+		if err != nil {
+			r.abort(frame.ID)
+			return nil, fmt.Errorf("failed to send flow control frame: %v", err)
 		}
+		rec.framesInBlock = 0
 	}
-	cancel()
-	return data, nil
+	return nil, nil
 }
 
-func sendFlowControlFrame() error {
-	d := services.Get(services.ServiceDriver).(drivers.Driver)
-	// Construct the FC frame data
-	fcFrameData := [8]byte{}
-	fcFrameData[0] = (PCIFrameTypeFC << 4) | 0x00 // Flow Status: Continue to send (CTS)
-	fcFrameData[1] = 0x00                         // Block Size (BS): 0 means sender can send all CFs without waiting for further FCs
-	fcFrameData[2] = testerSeparationTime         // Separation Time: 0 means minimum separation time
-	// Create the CAN frame
-	fcFrame := &canbus.CanFrame{
-		ID:   TesterID,
-		DLC:  3,
-		Data: fcFrameData,
+// nextDeadline returns the sender of the reception that times out first and its N_Cr deadline.
+func (r *Reader) nextDeadline() (uint16, time.Time, bool) {
+	var senderID uint16
+	var deadline time.Time
+	for id, rec := range r.receptions {
+		if deadline.IsZero() || rec.deadline.Before(deadline) {
+			senderID, deadline = id, rec.deadline
+		}
 	}
-	// Send the FC frame using your CAN bus interface
-	err := d.SendFrame(context.Background(), fcFrame)
-	if err != nil {
-		return err
+	return senderID, deadline, !deadline.IsZero()
+}
+
+// abort ends the reception from senderID, if there is one.
+func (r *Reader) abort(senderID uint16) {
+	if _, ok := r.receptions[senderID]; !ok {
+		return
 	}
-	return nil
+	delete(r.receptions, senderID)
+	setReceiving(senderID, false)
+}
+
+// sendFlowControlFrame sends a flow control frame from testerID using the block size and separation time from config.
+func sendFlowControlFrame(ctx context.Context, testerID uint16, flowStatus byte, config ISOTPConfig) error {
+	fcFrame := &canbus.CanFrame{
+		ID:  testerID,
+		DLC: 3,
+	}
+	fcFrame.Data[0] = (PCIFrameTypeFC << 4) | flowStatus // Flow Status
+	fcFrame.Data[1] = config.BlockSize                   // Block Size (BS): 0 means sender can send all CFs without waiting for further FCs
+	fcFrame.Data[2] = config.SeparationTime              // Separation Time (STmin) between consecutive frames
+	return sendTransportFrame(ctx, fcFrame)
 }
@@ -0,0 +1,435 @@
+package uds
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"husk/canbus"
+	"husk/drivers"
+	"husk/logging"
+)
+
+// testFrameTimeout is how long the ECU side of a test waits for a frame from the tester
+const testFrameTimeout = time.Second
+
+// testECU is the ECU side of a virtual bus. It sends raw frames and reads the frames sent by the tester.
+type testECU struct {
+	driver *drivers.VirtualDriver
+	frames chan *canbus.CanFrame
+}
+
+// setupVirtualBus registers a tester endpoint on a new virtual bus and returns an ECU endpoint on the same bus.
+func setupVirtualBus(t *testing.T) (context.Context, *testECU) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	logging.RegisterLogger().Start(ctx)
+	bus := drivers.NewVirtualBus(drivers.VirtualBusConfig{})
+	t.Cleanup(bus.Close)
+	tester := drivers.NewVirtualDriver(bus, drivers.VirtualTesterName)
+	_, _ = tester.Register()
+	_, _ = tester.Start(ctx)
+	ecu := drivers.NewVirtualDriver(bus, "ecu")
+	_, _ = ecu.Start(ctx)
+	t.Cleanup(func() {
+		transportConfigsLock.Lock()
+		clear(transportConfigs)
+		transportConfigsLock.Unlock()
+	})
+	return ctx, &testECU{driver: ecu, frames: ecu.SubscribeReadFrames()}
+}
+
+// configureBlockSize sets the block size the tester asks ECUs responding to testerID for.
+func configureBlockSize(testerID uint16, blockSize byte) {
+	config := DefaultISOTPConfig()
+	config.BlockSize = blockSize
+	config.SeparationTime = 0
+	ConfigureTransport(testerID, config)
+}
+
+// testPayload returns a positive ReadDataByIdentifier response of length bytes.
+func testPayload(length int) []byte {
+	payload := make([]byte, length)
+	payload[0] = ServiceReadDataByIdentifier + PositiveResponseServiceIdOffset
+	for i := 1; i < length; i++ {
+		payload[i] = byte(i)
+	}
+	return payload
+}
+
+// send sends a frame with the given data from id.
+func (e *testECU) send(id uint16, data ...byte) error {
+	frame := &canbus.CanFrame{ID: id, DLC: byte(len(data))}
+	copy(frame.Data[:], data)
+	return e.driver.SendFrame(context.Background(), frame)
+}
+
+// receive waits for the next frame of the given PCI frame type sent on id.
+func (e *testECU) receive(id uint16, pciFrameType byte) (*canbus.CanFrame, error) {
+	timer := time.NewTimer(testFrameTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case frame := <-e.frames:
+			if frame.ID == id && frame.Data[0]>>4 == pciFrameType {
+				return frame, nil
+			}
+		case <-timer.C:
+			return nil, fmt.Errorf("no frame of type %d on 0x%03X", pciFrameType, id)
+		}
+	}
+}
+
+// expectNoFrame fails if a frame is sent on id within wait.
+func (e *testECU) expectNoFrame(id uint16, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case frame := <-e.frames:
+			if frame.ID == id {
+				return fmt.Errorf("unexpected frame on 0x%03X: % X", id, frame.Data[:frame.DLC])
+			}
+		case <-timer.C:
+			return nil
+		}
+	}
+}
+
+// waitFlowControl waits for a flow control frame from the tester talking to the ECU on id.
+func (e *testECU) waitFlowControl(id uint16) (flowStatus byte, blockSize byte, err error) {
+	frame, err := e.receive(RequesterID(id), PCIFrameTypeFC)
+	if err != nil {
+		return 0, 0, err
+	}
+	return frame.Data[0] & 0x0F, frame.Data[1], nil
+}
+
+// sendFirstFrame sends the first frame of payload from id and returns the number of bytes it carried.
+func (e *testECU) sendFirstFrame(id uint16, payload []byte, escaped bool) (int, error) {
+	var pci []byte
+	if escaped {
+		pci = binary.BigEndian.AppendUint32([]byte{PCIFrameTypeFF << 4, 0x00}, uint32(len(payload)))
+	} else {
+		pci = []byte{PCIFrameTypeFF<<4 | byte(len(payload)>>8), byte(len(payload))}
+	}
+	sent := 8 - len(pci)
+	return sent, e.send(id, append(pci, payload[:sent]...)...)
+}
+
+// sendMessage sends payload from id as a multi frame message, following the block size in the flow control
+// frames from the tester. With duplicate every consecutive frame is sent twice. It returns the number of flow
+// control frames received.
+func (e *testECU) sendMessage(id uint16, payload []byte, escaped bool, duplicate bool) (int, error) {
+	sent, err := e.sendFirstFrame(id, payload, escaped)
+	if err != nil {
+		return 0, err
+	}
+	flowControlFrames := 0
+	seqNum := byte(1)
+	for sent < len(payload) {
+		flowStatus, blockSize, err := e.waitFlowControl(id)
+		if err != nil {
+			return flowControlFrames, err
+		}
+		flowControlFrames++
+		if flowStatus != FlowStatusContinueToSend {
+			return flowControlFrames, fmt.Errorf("flow status %d", flowStatus)
+		}
+		for framesSent := 0; sent < len(payload) && (blockSize == 0 || framesSent < int(blockSize)); framesSent++ {
+			n := min(len(payload)-sent, 7)
+			frame := append([]byte{PCIFrameTypeCF<<4 | seqNum}, payload[sent:sent+n]...)
+			if err := e.send(id, frame...); err != nil {
+				return flowControlFrames, err
+			}
+			if duplicate {
+				if err := e.send(id, frame...); err != nil {
+					return flowControlFrames, err
+				}
+			}
+			sent += n
+			seqNum = (seqNum + 1) % 16
+		}
+	}
+	return flowControlFrames, nil
+}
+
+// readAsync runs send on the ECU side while reading the next message. Errors from send fail the test unless
+// reading failed as well.
+func readAsync(t *testing.T, ctx context.Context, send func() error) (*Message, error) {
+	t.Helper()
+	r := NewReader()
+	defer r.Close()
+	errChan := make(chan error, 1)
+	go func() { errChan <- send() }()
+	message, err := r.Read(ctx)
+	if sendErr := <-errChan; sendErr != nil && err == nil {
+		t.Fatalf("ecu: %v", sendErr)
+	}
+	return message, err
+}
+
+// checkMessage fails the test unless message is the response payload from senderID.
+func checkMessage(t *testing.T, message *Message, senderID uint16, payload []byte) {
+	t.Helper()
+	if message == nil {
+		t.Fatal("no message")
+	}
+	if message.SenderID != senderID {
+		t.Fatalf("sender 0x%03X, want 0x%03X", message.SenderID, senderID)
+	}
+	if !bytes.Equal(message.Data, payload[1:]) {
+		t.Fatalf("data mismatch, got %d bytes want %d", len(message.Data), len(payload)-1)
+	}
+}
+
+func TestReadMultiFrameSequenceWraps(t *testing.T) {
+	ctx, ecu := setupVirtualBus(t)
+	// 40 consecutive frames, the sequence number wraps from 15 to 0 twice
+	payload := testPayload(6 + 40*7)
+	message, err := readAsync(t, ctx, func() error {
+		_, err := ecu.sendMessage(ECUID, payload, false, false)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMessage(t, message, ECUID, payload)
+}
+
+func TestReadIgnoresRepeatedConsecutiveFrame(t *testing.T) {
+	ctx, ecu := setupVirtualBus(t)
+	payload := testPayload(100)
+	message, err := readAsync(t, ctx, func() error {
+		_, err := ecu.sendMessage(ECUID, payload, false, true)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMessage(t, message, ECUID, payload)
+}
+
+func TestReadRejectsUnexpectedSequenceNumber(t *testing.T) {
+	tests := []struct {
+		name    string
+		seqNums []byte
+	}{
+		// A frame with the sequence number before the first consecutive frame isn't a repeat
+		{name: "before first consecutive frame", seqNums: []byte{0}},
+		{name: "skipped frame", seqNums: []byte{1, 3}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, ecu := setupVirtualBus(t)
+			payload := testPayload(100)
+			_, err := readAsync(t, ctx, func() error {
+				sent, err := ecu.sendFirstFrame(ECUID, payload, false)
+				if err != nil {
+					return err
+				}
+				if _, _, err = ecu.waitFlowControl(ECUID); err != nil {
+					return err
+				}
+				for _, seqNum := range test.seqNums {
+					err = ecu.send(ECUID, append([]byte{PCIFrameTypeCF<<4 | seqNum}, payload[sent:sent+7]...)...)
+					if err != nil {
+						return err
+					}
+					sent += 7
+				}
+				return nil
+			})
+			if !errors.Is(err, errorUnexpectedFrameIndex) {
+				t.Fatalf("got %v, want %v", err, errorUnexpectedFrameIndex)
+			}
+		})
+	}
+}
+
+func TestReadBlockSize(t *testing.T) {
+	ctx, ecu := setupVirtualBus(t)
+	configureBlockSize(TesterID, 4)
+	// 14 consecutive frames are sent in 4 blocks, each announced by a flow control frame
+	payload := testPayload(6 + 14*7)
+	var flowControlFrames int
+	message, err := readAsync(t, ctx, func() error {
+		var err error
+		flowControlFrames, err = ecu.sendMessage(ECUID, payload, false, false)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMessage(t, message, ECUID, payload)
+	if flowControlFrames != 4 {
+		t.Fatalf("%d flow control frames, want 4", flowControlFrames)
+	}
+}
+
+func TestReadEscapedFirstFrame(t *testing.T) {
+	ctx, ecu := setupVirtualBus(t)
+	configureBlockSize(TesterID, 0x20)
+	payload := testPayload(maxFirstFrameLength + 100)
+	message, err := readAsync(t, ctx, func() error {
+		// Repeats are detected after an escaped first frame too
+		_, err := ecu.sendMessage(ECUID, payload, true, true)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMessage(t, message, ECUID, payload)
+}
+
+func TestReadRejectsShortEscapedFirstFrame(t *testing.T) {
+	ctx, ecu := setupVirtualBus(t)
+	_, err := readAsync(t, ctx, func() error {
+		// A length that fits in 12 bits must not use the escape sequence
+		_, err := ecu.sendFirstFrame(ECUID, testPayload(maxFirstFrameLength), true)
+		return err
+	})
+	if !errors.Is(err, errorInvalidFirstFrame) {
+		t.Fatalf("got %v, want %v", err, errorInvalidFirstFrame)
+	}
+}
+
+func TestReadRejectsMessageTooLarge(t *testing.T) {
+	ctx, ecu := setupVirtualBus(t)
+	config := DefaultISOTPConfig()
+	config.MaxMessageLength = 64
+	ConfigureTransport(TesterID, config)
+	var flowStatus byte
+	_, err := readAsync(t, ctx, func() error {
+		_, err := ecu.sendFirstFrame(ECUID, testPayload(100), false)
+		if err != nil {
+			return err
+		}
+		flowStatus, _, err = ecu.waitFlowControl(ECUID)
+		return err
+	})
+	if !errors.Is(err, errorMessageTooLarge) {
+		t.Fatalf("got %v, want %v", err, errorMessageTooLarge)
+	}
+	if flowStatus != FlowStatusOverflow {
+		t.Fatalf("flow status %d, want overflow", flowStatus)
+	}
+}
+
+func TestReadConcurrentResponders(t *testing.T) {
+	ctx, ecu := setupVirtualBus(t)
+	const otherECUID = ECUID + 1
+	const singleFrameECUID = ECUID + 2
+	payloads := map[uint16][]byte{
+		ECUID:            testPayload(6 + 5*7),
+		otherECUID:       testPayload(6 + 3*7),
+		singleFrameECUID: testPayload(5),
+	}
+	r := NewReader()
+	defer r.Close()
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- func() error {
+			// Both ECUs start their responses before either has finished
+			sent := make(map[uint16]int)
+			for _, id := range []uint16{ECUID, otherECUID} {
+				n, err := ecu.sendFirstFrame(id, payloads[id], false)
+				if err != nil {
+					return err
+				}
+				if _, _, err = ecu.waitFlowControl(id); err != nil {
+					return err
+				}
+				sent[id] = n
+			}
+			// A single frame response arrives in the middle of both
+			singleFrame := payloads[singleFrameECUID]
+			err := ecu.send(singleFrameECUID, append([]byte{byte(len(singleFrame))}, singleFrame...)...)
+			if err != nil {
+				return err
+			}
+			// Interleave the consecutive frames
+			for seqNum := byte(1); sent[ECUID] < len(payloads[ECUID]) || sent[otherECUID] < len(payloads[otherECUID]); seqNum++ {
+				for _, id := range []uint16{ECUID, otherECUID} {
+					payload := payloads[id]
+					if sent[id] == len(payload) {
+						continue
+					}
+					n := min(len(payload)-sent[id], 7)
+					err = ecu.send(id, append([]byte{PCIFrameTypeCF<<4 | seqNum}, payload[sent[id]:sent[id]+n]...)...)
+					if err != nil {
+						return err
+					}
+					sent[id] += n
+				}
+			}
+			return nil
+		}()
+	}()
+	// The ECUs are still reading payloads while the messages are checked
+	received := make(map[uint16]bool)
+	for range payloads {
+		message, err := r.Read(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload, ok := payloads[message.SenderID]
+		if !ok || received[message.SenderID] {
+			t.Fatalf("unexpected message from 0x%03X", message.SenderID)
+		}
+		checkMessage(t, message, message.SenderID, payload)
+		received[message.SenderID] = true
+	}
+	if err := <-errChan; err != nil {
+		t.Fatalf("ecu: %v", err)
+	}
+}
+
+func TestSendMultiFrameBlockSize(t *testing.T) {
+	ctx, ecu := setupVirtualBus(t)
+	data := testPayload(6 + 5*7)
+	errChan := make(chan error, 1)
+	go func() { errChan <- sendMultiFrame(ctx, TesterID, data) }()
+
+	firstFrame, err := ecu.receive(TesterID, PCIFrameTypeFF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := append([]byte{}, firstFrame.Data[2:]...)
+	// The tester keeps waiting while the ECU asks it to
+	if err = ecu.send(ECUID, PCIFrameTypeFC<<4|FlowStatusWait, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = ecu.expectNoFrame(TesterID, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	for len(received) < len(data) {
+		// Blocks of 2 consecutive frames
+		if err = ecu.send(ECUID, PCIFrameTypeFC<<4|FlowStatusContinueToSend, 2, 0); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2 && len(received) < len(data); i++ {
+			frame, err := ecu.receive(TesterID, PCIFrameTypeCF)
+			if err != nil {
+				t.Fatal(err)
+			}
+			received = append(received, frame.Data[1:frame.DLC]...)
+		}
+		if len(received) < len(data) {
+			// The rest of the message waits for the next flow control frame
+			if err = ecu.expectNoFrame(TesterID, 50*time.Millisecond); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err = <-errChan; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, data) {
+		t.Fatalf("received % X, want % X", received, data)
+	}
+}